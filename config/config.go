@@ -0,0 +1,250 @@
+package config
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/golang-jwt/jwt/v5"
+	"net/url"
+	"slices"
+	"time"
+)
+
+// Config is the complete configuration of the api and the worker.
+// The json names of the session and database fields match the secrets object stored in S3.
+// If SessionJWKSURL is set, the session keys are loaded from the referenced JWKS document and the SessionRSA* fields are ignored.
+// A file or s3 document contains the signing key itself; an http(s) document only contains public keys,
+// in which case the signing key is loaded from the JWKS document referenced by SessionSigningKeyURL (file or s3).
+type Config struct {
+	ListenAddr                     string   `json:"listenAddr" yaml:"listenAddr"`
+	DatabaseURL                    string   `json:"databaseURL" yaml:"databaseURL"`
+	SessionRSAPublicKid1           string   `json:"sessionRSAPublicKid1" yaml:"sessionRSAPublicKid1"`
+	SessionRSAPublicKid2           string   `json:"sessionRSAPublicKid2" yaml:"sessionRSAPublicKid2"`
+	SessionRSAPrivateKid2          string   `json:"sessionRSAPrivateKid2" yaml:"sessionRSAPrivateKid2"`
+	SessionRSAPublicPEM1           string   `json:"sessionRSAPublicPEM1" yaml:"sessionRSAPublicPEM1"`
+	SessionRSAPublicPEM2           string   `json:"sessionRSAPublicPEM2" yaml:"sessionRSAPublicPEM2"`
+	SessionRSAPrivatePEM2          string   `json:"sessionRSAPrivatePEM2" yaml:"sessionRSAPrivatePEM2"`
+	SessionJWKSURL                 string   `json:"sessionJWKSURL" yaml:"sessionJWKSURL"`
+	SessionSigningKeyURL           string   `json:"sessionSigningKeyURL" yaml:"sessionSigningKeyURL"`
+	SessionJWKSRefreshInterval     Duration `json:"sessionJWKSRefreshInterval" yaml:"sessionJWKSRefreshInterval"`
+	SessionAnomalyMaxDistanceKm    float64  `json:"sessionAnomalyMaxDistanceKm" yaml:"sessionAnomalyMaxDistanceKm"`
+	SessionAnomalyAlwaysAllowedKm  float64  `json:"sessionAnomalyAlwaysAllowedKm" yaml:"sessionAnomalyAlwaysAllowedKm"`
+	SessionAnomalyMaxKmPerDay      float64  `json:"sessionAnomalyMaxKmPerDay" yaml:"sessionAnomalyMaxKmPerDay"`
+	SessionCacheTTL                Duration `json:"sessionCacheTTL" yaml:"sessionCacheTTL"`
+	SessionCacheSize               int      `json:"sessionCacheSize" yaml:"sessionCacheSize"`
+	SessionRenewThreshold          Duration `json:"sessionRenewThreshold" yaml:"sessionRenewThreshold"`
+	Gw2ApiURL                      string   `json:"gw2ApiURL" yaml:"gw2ApiURL"`
+	Gw2EfficiencyStatusURL         string   `json:"gw2EfficiencyStatusURL" yaml:"gw2EfficiencyStatusURL"`
+	WorkerInterval                 Duration `json:"workerInterval" yaml:"workerInterval"`
+	TokenRevalidationRequestBudget int      `json:"tokenRevalidationRequestBudget" yaml:"tokenRevalidationRequestBudget"`
+	AccountLogRetention            Duration `json:"accountLogRetention" yaml:"accountLogRetention"`
+	APIKeyUsageFlushInterval       Duration `json:"apiKeyUsageFlushInterval" yaml:"apiKeyUsageFlushInterval"`
+	APIKeyUsageFlushThreshold      int      `json:"apiKeyUsageFlushThreshold" yaml:"apiKeyUsageFlushThreshold"`
+	APIKeyCacheTTL                 Duration `json:"apiKeyCacheTTL" yaml:"apiKeyCacheTTL"`
+	APIKeyCacheSize                int      `json:"apiKeyCacheSize" yaml:"apiKeyCacheSize"`
+	APIKeyFailureLimit             int      `json:"apiKeyFailureLimit" yaml:"apiKeyFailureLimit"`
+	APIKeyFailureLimitPerClient    int      `json:"apiKeyFailureLimitPerClient" yaml:"apiKeyFailureLimitPerClient"`
+	APIKeyFailureWindow            Duration `json:"apiKeyFailureWindow" yaml:"apiKeyFailureWindow"`
+}
+
+// Duration is a time.Duration read from strings like "1m30s"
+type Duration time.Duration
+
+func (d *Duration) UnmarshalText(text []byte) error {
+	v, err := time.ParseDuration(string(text))
+	if err != nil {
+		return err
+	}
+
+	*d = Duration(v)
+	return nil
+}
+
+func (d Duration) MarshalText() ([]byte, error) {
+	return []byte(time.Duration(d).String()), nil
+}
+
+// Source applies configuration values onto cfg; values not known to the source must be left untouched.
+type Source func(ctx context.Context, cfg *Config) error
+
+func Default() Config {
+	return Config{
+		ListenAddr:                     ":8090",
+		Gw2ApiURL:                      "https://api.guildwars2.com",
+		Gw2EfficiencyStatusURL:         "https://status.gw2efficiency.com/api",
+		SessionJWKSRefreshInterval:     Duration(5 * time.Minute),
+		SessionAnomalyMaxDistanceKm:    1000,
+		SessionAnomalyAlwaysAllowedKm:  30,
+		SessionAnomalyMaxKmPerDay:      333.3,
+		SessionCacheTTL:                Duration(10 * time.Second),
+		SessionCacheSize:               10_000,
+		SessionRenewThreshold:          Duration(29 * 24 * time.Hour),
+		WorkerInterval:                 Duration(time.Minute),
+		TokenRevalidationRequestBudget: 200,
+		AccountLogRetention:            Duration(90 * 24 * time.Hour),
+		APIKeyUsageFlushInterval:       Duration(30 * time.Second),
+		APIKeyUsageFlushThreshold:      1_000,
+		APIKeyCacheTTL:                 Duration(time.Minute),
+		APIKeyCacheSize:                10_000,
+		APIKeyFailureLimit:             50,
+		APIKeyFailureLimitPerClient:    10,
+		APIKeyFailureWindow:            Duration(5 * time.Minute),
+	}
+}
+
+// Load applies all sources in order onto the default configuration and validates the result.
+func Load(ctx context.Context, sources ...Source) (Config, error) {
+	cfg := Default()
+	for _, src := range sources {
+		if err := src(ctx, &cfg); err != nil {
+			return Config{}, fmt.Errorf("failed to load config: %w", err)
+		}
+	}
+
+	if err := cfg.Validate(); err != nil {
+		return Config{}, fmt.Errorf("invalid config: %w", err)
+	}
+
+	return cfg, nil
+}
+
+func (c Config) Validate() error {
+	var errs []error
+	fieldErr := func(field, format string, args ...any) {
+		errs = append(errs, fmt.Errorf("%s: %s", field, fmt.Sprintf(format, args...)))
+	}
+
+	if c.ListenAddr == "" {
+		fieldErr("listenAddr", "must not be empty")
+	}
+
+	if u, err := url.Parse(c.DatabaseURL); c.DatabaseURL == "" {
+		fieldErr("databaseURL", "must not be empty")
+	} else if err != nil {
+		fieldErr("databaseURL", "invalid url: %v", err)
+	} else if u.Scheme != "postgres" && u.Scheme != "postgresql" {
+		fieldErr("databaseURL", "scheme must be postgres or postgresql, got %q", u.Scheme)
+	}
+
+	for field, v := range map[string]string{"gw2ApiURL": c.Gw2ApiURL, "gw2EfficiencyStatusURL": c.Gw2EfficiencyStatusURL} {
+		if u, err := url.Parse(v); v == "" {
+			fieldErr(field, "must not be empty")
+		} else if err != nil {
+			fieldErr(field, "invalid url: %v", err)
+		} else if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			fieldErr(field, "must be an absolute http(s) url, got %q", v)
+		}
+	}
+
+	if c.SessionJWKSURL != "" {
+		if u, err := url.Parse(c.SessionJWKSURL); err != nil {
+			fieldErr("sessionJWKSURL", "invalid url: %v", err)
+		} else if !slices.Contains([]string{"file", "http", "https", "s3"}, u.Scheme) {
+			fieldErr("sessionJWKSURL", "scheme must be one of file, http, https or s3, got %q", u.Scheme)
+		} else if u.Scheme == "http" || u.Scheme == "https" {
+			// the signing key must not be served publicly
+			if u, err := url.Parse(c.SessionSigningKeyURL); c.SessionSigningKeyURL == "" {
+				fieldErr("sessionSigningKeyURL", "must not be empty if sessionJWKSURL is an http(s) url")
+			} else if err != nil {
+				fieldErr("sessionSigningKeyURL", "invalid url: %v", err)
+			} else if !slices.Contains([]string{"file", "s3"}, u.Scheme) {
+				fieldErr("sessionSigningKeyURL", "scheme must be file or s3, got %q", u.Scheme)
+			}
+		}
+
+		if c.SessionJWKSRefreshInterval <= 0 {
+			fieldErr("sessionJWKSRefreshInterval", "must be positive")
+		}
+	} else {
+		c.validateSessionRSA(fieldErr)
+	}
+
+	for field, v := range map[string]float64{"sessionAnomalyMaxDistanceKm": c.SessionAnomalyMaxDistanceKm, "sessionAnomalyAlwaysAllowedKm": c.SessionAnomalyAlwaysAllowedKm, "sessionAnomalyMaxKmPerDay": c.SessionAnomalyMaxKmPerDay} {
+		if v < 0 {
+			fieldErr(field, "must not be negative")
+		}
+	}
+
+	if c.SessionAnomalyAlwaysAllowedKm > c.SessionAnomalyMaxDistanceKm {
+		fieldErr("sessionAnomalyAlwaysAllowedKm", "must not be greater than sessionAnomalyMaxDistanceKm")
+	}
+
+	if c.SessionCacheTTL < 0 {
+		fieldErr("sessionCacheTTL", "must not be negative")
+	}
+
+	if c.SessionCacheTTL > 0 && c.SessionCacheSize <= 0 {
+		fieldErr("sessionCacheSize", "must be positive if sessionCacheTTL is set")
+	}
+
+	if c.SessionRenewThreshold < 0 {
+		fieldErr("sessionRenewThreshold", "must not be negative")
+	}
+
+	if c.WorkerInterval <= 0 {
+		fieldErr("workerInterval", "must be positive")
+	}
+
+	if c.TokenRevalidationRequestBudget < 0 {
+		fieldErr("tokenRevalidationRequestBudget", "must not be negative")
+	}
+
+	if c.AccountLogRetention <= 0 {
+		fieldErr("accountLogRetention", "must be positive")
+	}
+
+	if c.APIKeyUsageFlushInterval <= 0 {
+		fieldErr("apiKeyUsageFlushInterval", "must be positive")
+	}
+
+	if c.APIKeyUsageFlushThreshold <= 0 {
+		fieldErr("apiKeyUsageFlushThreshold", "must be positive")
+	}
+
+	if c.APIKeyCacheTTL < 0 {
+		fieldErr("apiKeyCacheTTL", "must not be negative")
+	}
+
+	if c.APIKeyCacheTTL > 0 && c.APIKeyCacheSize <= 0 {
+		fieldErr("apiKeyCacheSize", "must be positive if apiKeyCacheTTL is set")
+	}
+
+	if c.APIKeyFailureLimit <= 0 {
+		fieldErr("apiKeyFailureLimit", "must be positive")
+	}
+
+	if c.APIKeyFailureLimitPerClient < 0 {
+		fieldErr("apiKeyFailureLimitPerClient", "must not be negative")
+	} else if c.APIKeyFailureLimitPerClient > c.APIKeyFailureLimit {
+		fieldErr("apiKeyFailureLimitPerClient", "must not be greater than apiKeyFailureLimit")
+	}
+
+	if c.APIKeyFailureWindow <= 0 {
+		fieldErr("apiKeyFailureWindow", "must be positive")
+	}
+
+	return errors.Join(errs...)
+}
+
+func (c Config) validateSessionRSA(fieldErr func(field, format string, args ...any)) {
+	publicKids := make(map[string]struct{})
+	for i, k := range []struct{ kid, pem string }{{c.SessionRSAPublicKid1, c.SessionRSAPublicPEM1}, {c.SessionRSAPublicKid2, c.SessionRSAPublicPEM2}} {
+		if k.kid == "" {
+			fieldErr(fmt.Sprintf("sessionRSAPublicKid%d", i+1), "must not be empty")
+		}
+
+		if _, err := jwt.ParseRSAPublicKeyFromPEM([]byte(k.pem)); err != nil {
+			fieldErr(fmt.Sprintf("sessionRSAPublicPEM%d", i+1), "invalid rsa public key: %v", err)
+		}
+
+		publicKids[k.kid] = struct{}{}
+	}
+
+	if _, ok := publicKids[c.SessionRSAPrivateKid2]; !ok {
+		fieldErr("sessionRSAPrivateKid2", "must match one of the public kids")
+	}
+
+	if _, err := jwt.ParseRSAPrivateKeyFromPEM([]byte(c.SessionRSAPrivatePEM2)); err != nil {
+		fieldErr("sessionRSAPrivatePEM2", "invalid rsa private key: %v", err)
+	}
+}
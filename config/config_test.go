@@ -0,0 +1,162 @@
+package config
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestLoad(t *testing.T) {
+	pub, priv := newPEMs(t)
+
+	t.Run("json", func(t *testing.T) {
+		path := writeFile(t, "config.json", validJSON(t, pub, priv))
+
+		cfg, err := Load(context.Background(), FromFile(path))
+		if assert.NoError(t, err) {
+			assert.Equal(t, "postgres://user@localhost:26257/db", cfg.DatabaseURL)
+			assert.Equal(t, "kid1", cfg.SessionRSAPublicKid1)
+			assert.Equal(t, Default().ListenAddr, cfg.ListenAddr)
+			assert.Equal(t, Default().Gw2ApiURL, cfg.Gw2ApiURL)
+		}
+	})
+
+	t.Run("yaml overrides json", func(t *testing.T) {
+		jsonPath := writeFile(t, "config.json", validJSON(t, pub, priv))
+		yamlPath := writeFile(t, "config.yaml", "listenAddr: \":9000\"\ngw2ApiURL: http://localhost:8091\nworkerInterval: 30s\n")
+
+		cfg, err := Load(context.Background(), FromFile(jsonPath), FromFile(yamlPath))
+		if assert.NoError(t, err) {
+			assert.Equal(t, ":9000", cfg.ListenAddr)
+			assert.Equal(t, "http://localhost:8091", cfg.Gw2ApiURL)
+			assert.Equal(t, Duration(30*time.Second), cfg.WorkerInterval)
+			assert.Equal(t, "kid1", cfg.SessionRSAPublicKid1)
+		}
+	})
+
+	t.Run("env overrides files", func(t *testing.T) {
+		path := writeFile(t, "config.json", validJSON(t, pub, priv))
+		t.Setenv(EnvPrefix+"DATABASE_URL", "postgresql://other@localhost:26257/other")
+		t.Setenv(EnvPrefix+"TOKEN_REVALIDATION_REQUEST_BUDGET", "10")
+
+		cfg, err := Load(context.Background(), FromFile(path), FromEnv())
+		if assert.NoError(t, err) {
+			assert.Equal(t, "postgresql://other@localhost:26257/other", cfg.DatabaseURL)
+			assert.Equal(t, 10, cfg.TokenRevalidationRequestBudget)
+		}
+	})
+
+	t.Run("invalid env", func(t *testing.T) {
+		t.Setenv(EnvPrefix+"WORKER_INTERVAL", "soon")
+
+		_, err := Load(context.Background(), FromEnv())
+		assert.ErrorContains(t, err, "GW2AUTH_WORKER_INTERVAL")
+	})
+
+	t.Run("optional file", func(t *testing.T) {
+		path := writeFile(t, "config.json", validJSON(t, pub, priv))
+
+		_, err := Load(context.Background(), FromFile(path), FromOptionalFile(filepath.Join(t.TempDir(), "missing.yaml")))
+		assert.NoError(t, err)
+
+		_, err = Load(context.Background(), FromFile(path), FromFile(filepath.Join(t.TempDir(), "missing.yaml")))
+		assert.Error(t, err)
+	})
+
+	t.Run("unsupported extension", func(t *testing.T) {
+		path := writeFile(t, "config.toml", "")
+
+		_, err := Load(context.Background(), FromFile(path))
+		assert.ErrorContains(t, err, "unsupported config file extension")
+	})
+}
+
+func TestConfig_Validate(t *testing.T) {
+	err := Default().Validate()
+	for _, field := range []string{"databaseURL", "sessionRSAPublicKid1", "sessionRSAPublicPEM2", "sessionRSAPrivatePEM2"} {
+		assert.ErrorContains(t, err, field+":")
+	}
+
+	cfg := Default()
+	cfg.DatabaseURL = "mysql://localhost"
+	cfg.Gw2ApiURL = "localhost:8091"
+	err = cfg.Validate()
+	assert.ErrorContains(t, err, "databaseURL: scheme must be postgres or postgresql")
+	assert.ErrorContains(t, err, "gw2ApiURL: must be an absolute http(s) url")
+
+	cfg = Default()
+	cfg.DatabaseURL = "postgres://user@localhost:26257/db"
+	cfg.SessionJWKSURL = "s3://bucket/jwks.json"
+	assert.NoError(t, cfg.Validate())
+
+	cfg.SessionJWKSURL = "https://localhost/jwks.json"
+	cfg.SessionSigningKeyURL = "s3://bucket/signing.json"
+	assert.NoError(t, cfg.Validate())
+
+	cfg.SessionSigningKeyURL = "https://localhost/signing.json"
+	assert.ErrorContains(t, cfg.Validate(), "sessionSigningKeyURL: scheme must be file or s3")
+
+	cfg.SessionJWKSURL = "ftp://localhost/jwks.json"
+	cfg.SessionJWKSRefreshInterval = 0
+	err = cfg.Validate()
+	assert.ErrorContains(t, err, "sessionJWKSURL: scheme must be one of file, http, https or s3")
+	assert.ErrorContains(t, err, "sessionJWKSRefreshInterval: must be positive")
+
+	cfg = Default()
+	cfg.APIKeyFailureLimit = 5
+	cfg.APIKeyFailureWindow = 0
+	err = cfg.Validate()
+	assert.ErrorContains(t, err, "apiKeyFailureLimitPerClient: must not be greater than apiKeyFailureLimit")
+	assert.ErrorContains(t, err, "apiKeyFailureWindow: must be positive")
+}
+
+func newPEMs(t *testing.T) (string, string) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+
+	pubDER, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+
+	pub := pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: pubDER})
+	priv := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+
+	return string(pub), string(priv)
+}
+
+func validJSON(t *testing.T, pub, priv string) string {
+	b, err := json.Marshal(map[string]string{
+		"databaseURL":           "postgres://user@localhost:26257/db",
+		"sessionRSAPublicKid1":  "kid1",
+		"sessionRSAPublicKid2":  "kid2",
+		"sessionRSAPrivateKid2": "kid2",
+		"sessionRSAPublicPEM1":  pub,
+		"sessionRSAPublicPEM2":  pub,
+		"sessionRSAPrivatePEM2": priv,
+	})
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+
+	return string(b)
+}
+
+func writeFile(t *testing.T, name, content string) string {
+	path := filepath.Join(t.TempDir(), name)
+	if !assert.NoError(t, os.WriteFile(path, []byte(content), 0o600)) {
+		t.FailNow()
+	}
+
+	return path
+}
@@ -0,0 +1,163 @@
+package config
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"gopkg.in/yaml.v3"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+)
+
+const EnvPrefix = "GW2AUTH_"
+
+// FromJSON reads the configuration from r; fields missing in the document are left untouched.
+func FromJSON(r io.Reader) Source {
+	return func(ctx context.Context, cfg *Config) error {
+		return json.NewDecoder(r).Decode(cfg)
+	}
+}
+
+// FromYAML reads the configuration from r; fields missing in the document are left untouched.
+func FromYAML(r io.Reader) Source {
+	return func(ctx context.Context, cfg *Config) error {
+		err := yaml.NewDecoder(r).Decode(cfg)
+		if errors.Is(err, io.EOF) {
+			// empty document
+			return nil
+		}
+
+		return err
+	}
+}
+
+// FromFile reads a .json, .yaml or .yml file.
+func FromFile(path string) Source {
+	return func(ctx context.Context, cfg *Config) error {
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+
+		defer f.Close()
+
+		var src Source
+		switch ext := filepath.Ext(path); ext {
+		case ".json":
+			src = FromJSON(f)
+
+		case ".yaml", ".yml":
+			src = FromYAML(f)
+
+		default:
+			return fmt.Errorf("unsupported config file extension %q", ext)
+		}
+
+		if err = src(ctx, cfg); err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+
+		return nil
+	}
+}
+
+// FromOptionalFile is like FromFile, but does nothing if the file does not exist.
+func FromOptionalFile(path string) Source {
+	return func(ctx context.Context, cfg *Config) error {
+		err := FromFile(path)(ctx, cfg)
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil
+		}
+
+		return err
+	}
+}
+
+// FromEnv reads all fields from environment variables prefixed with EnvPrefix, e.g. GW2AUTH_DATABASE_URL.
+func FromEnv() Source {
+	return func(ctx context.Context, cfg *Config) error {
+		strs := map[string]*string{
+			"LISTEN_ADDR":               &cfg.ListenAddr,
+			"DATABASE_URL":              &cfg.DatabaseURL,
+			"SESSION_RSA_PUBLIC_KID_1":  &cfg.SessionRSAPublicKid1,
+			"SESSION_RSA_PUBLIC_KID_2":  &cfg.SessionRSAPublicKid2,
+			"SESSION_RSA_PRIVATE_KID_2": &cfg.SessionRSAPrivateKid2,
+			"SESSION_RSA_PUBLIC_PEM_1":  &cfg.SessionRSAPublicPEM1,
+			"SESSION_RSA_PUBLIC_PEM_2":  &cfg.SessionRSAPublicPEM2,
+			"SESSION_RSA_PRIVATE_PEM_2": &cfg.SessionRSAPrivatePEM2,
+			"SESSION_JWKS_URL":          &cfg.SessionJWKSURL,
+			"SESSION_SIGNING_KEY_URL":   &cfg.SessionSigningKeyURL,
+			"GW2_API_URL":               &cfg.Gw2ApiURL,
+			"GW2EFFICIENCY_STATUS_URL":  &cfg.Gw2EfficiencyStatusURL,
+		}
+
+		for name, p := range strs {
+			if v, ok := os.LookupEnv(EnvPrefix + name); ok {
+				*p = v
+			}
+		}
+
+		durations := map[string]*Duration{
+			"SESSION_JWKS_REFRESH_INTERVAL": &cfg.SessionJWKSRefreshInterval,
+			"SESSION_CACHE_TTL":             &cfg.SessionCacheTTL,
+			"SESSION_RENEW_THRESHOLD":       &cfg.SessionRenewThreshold,
+			"WORKER_INTERVAL":               &cfg.WorkerInterval,
+			"ACCOUNT_LOG_RETENTION":         &cfg.AccountLogRetention,
+			"API_KEY_USAGE_FLUSH_INTERVAL":  &cfg.APIKeyUsageFlushInterval,
+			"API_KEY_CACHE_TTL":             &cfg.APIKeyCacheTTL,
+			"API_KEY_FAILURE_WINDOW":        &cfg.APIKeyFailureWindow,
+		}
+
+		var errs []error
+		for name, p := range durations {
+			if v, ok := os.LookupEnv(EnvPrefix + name); ok {
+				if err := p.UnmarshalText([]byte(v)); err != nil {
+					errs = append(errs, fmt.Errorf("%s%s: %w", EnvPrefix, name, err))
+				}
+			}
+		}
+
+		floats := map[string]*float64{
+			"SESSION_ANOMALY_MAX_DISTANCE_KM":   &cfg.SessionAnomalyMaxDistanceKm,
+			"SESSION_ANOMALY_ALWAYS_ALLOWED_KM": &cfg.SessionAnomalyAlwaysAllowedKm,
+			"SESSION_ANOMALY_MAX_KM_PER_DAY":    &cfg.SessionAnomalyMaxKmPerDay,
+		}
+
+		for name, p := range floats {
+			if v, ok := os.LookupEnv(EnvPrefix + name); ok {
+				f, err := strconv.ParseFloat(v, 64)
+				if err != nil {
+					errs = append(errs, fmt.Errorf("%s%s: %w", EnvPrefix, name, err))
+				}
+
+				*p = f
+			}
+		}
+
+		ints := map[string]*int{
+			"SESSION_CACHE_SIZE":                &cfg.SessionCacheSize,
+			"TOKEN_REVALIDATION_REQUEST_BUDGET": &cfg.TokenRevalidationRequestBudget,
+			"API_KEY_USAGE_FLUSH_THRESHOLD":     &cfg.APIKeyUsageFlushThreshold,
+			"API_KEY_CACHE_SIZE":                &cfg.APIKeyCacheSize,
+			"API_KEY_FAILURE_LIMIT":             &cfg.APIKeyFailureLimit,
+			"API_KEY_FAILURE_LIMIT_PER_CLIENT":  &cfg.APIKeyFailureLimitPerClient,
+		}
+
+		for name, p := range ints {
+			if v, ok := os.LookupEnv(EnvPrefix + name); ok {
+				i, err := strconv.Atoi(v)
+				if err != nil {
+					errs = append(errs, fmt.Errorf("%s%s: %w", EnvPrefix, name, err))
+				}
+
+				*p = i
+			}
+		}
+
+		return errors.Join(errs...)
+	}
+}
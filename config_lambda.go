@@ -0,0 +1,64 @@
+//go:build lambda
+
+package main
+
+import (
+	"bytes"
+	"context"
+	awsconfig "github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/gw2auth/gw2auth.com-api/config"
+	"github.com/gw2auth/gw2auth.com-api/service"
+	"go.opentelemetry.io/contrib/instrumentation/github.com/aws/aws-sdk-go-v2/otelaws"
+	"io"
+	"os"
+)
+
+// loadConfig reads the secrets object from S3; GW2AUTH_* environment variables override its values
+func loadConfig(ctx context.Context) (config.Config, error) {
+	return config.Load(
+		ctx,
+		fromS3(os.Getenv("SECRETS_BUCKET"), os.Getenv("SECRETS_KEY")),
+		config.FromEnv(),
+	)
+}
+
+func fromS3(bucket, key string) config.Source {
+	return func(ctx context.Context, cfg *config.Config) error {
+		b, err := getS3Object(ctx, bucket, key)
+		if err != nil {
+			return err
+		}
+
+		return config.FromJSON(bytes.NewReader(b))(ctx, cfg)
+	}
+}
+
+func keySetFromS3(bucket, key string) service.KeySetSource {
+	return func(ctx context.Context) (*service.KeySet, error) {
+		b, err := getS3Object(ctx, bucket, key)
+		if err != nil {
+			return nil, err
+		}
+
+		return service.ParseJWKS(b)
+	}
+}
+
+func getS3Object(ctx context.Context, bucket, key string) ([]byte, error) {
+	awsCfg, err := awsconfig.LoadDefaultConfig(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	otelaws.AppendMiddlewares(&awsCfg.APIOptions)
+	s3Client := s3.NewFromConfig(awsCfg)
+
+	res, err := s3Client.GetObject(ctx, &s3.GetObjectInput{Bucket: &bucket, Key: &key})
+	if err != nil {
+		return nil, err
+	}
+
+	defer res.Body.Close()
+	return io.ReadAll(res.Body)
+}
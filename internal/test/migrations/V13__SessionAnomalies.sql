@@ -0,0 +1,23 @@
+ALTER TABLE account_federation_sessions
+ADD COLUMN step_up_required BOOLEAN NOT NULL DEFAULT FALSE ;
+
+CREATE TABLE account_trusted_regions (
+    id UUID NOT NULL,
+    account_id UUID NOT NULL,
+    creation_time TIMESTAMP WITH TIME ZONE NOT NULL,
+    display_name TEXT NOT NULL,
+    lat DOUBLE PRECISION NOT NULL,
+    lng DOUBLE PRECISION NOT NULL,
+    radius_km DOUBLE PRECISION NOT NULL,
+    PRIMARY KEY (id),
+    FOREIGN KEY (account_id) REFERENCES accounts (id) ON DELETE CASCADE,
+    CHECK ( LENGTH(display_name) BETWEEN 1 AND 100 ),
+    CHECK ( lat BETWEEN -90 AND 90 ),
+    CHECK ( lng BETWEEN -180 AND 180 ),
+    CHECK ( radius_km > 0 AND radius_km <= 500 )
+) ;
+
+CREATE INDEX ON account_trusted_regions (account_id) ;
+
+-- acls
+GRANT SELECT, INSERT, UPDATE, DELETE ON TABLE account_trusted_regions TO gw2auth_app ;
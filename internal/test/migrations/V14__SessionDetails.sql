@@ -0,0 +1,2 @@
+ALTER TABLE account_federation_sessions
+ADD COLUMN last_seen_time TIMESTAMP WITH TIME ZONE ;
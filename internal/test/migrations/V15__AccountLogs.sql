@@ -0,0 +1,14 @@
+CREATE TABLE account_logs (
+    id UUID NOT NULL,
+    account_id UUID NOT NULL,
+    timestamp TIMESTAMP WITH TIME ZONE NOT NULL,
+    type TEXT NOT NULL,
+    fields JSONB NOT NULL,
+    PRIMARY KEY (id),
+    FOREIGN KEY (account_id) REFERENCES accounts (id) ON DELETE CASCADE
+) ;
+
+CREATE INDEX ON account_logs (account_id, timestamp DESC, id) ;
+CREATE INDEX ON account_logs (timestamp) ;
+
+GRANT SELECT, INSERT, UPDATE, DELETE ON TABLE account_logs TO gw2auth_app ;
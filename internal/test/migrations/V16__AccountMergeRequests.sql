@@ -0,0 +1,13 @@
+CREATE TABLE account_merge_requests (
+    token_hash BYTES NOT NULL,
+    account_id UUID NOT NULL,
+    creation_time TIMESTAMP WITH TIME ZONE NOT NULL,
+    expiration_time TIMESTAMP WITH TIME ZONE NOT NULL,
+    PRIMARY KEY (token_hash),
+    FOREIGN KEY (account_id) REFERENCES accounts (id) ON DELETE CASCADE
+) ;
+
+CREATE INDEX ON account_merge_requests (account_id) ;
+
+-- acls
+GRANT SELECT, INSERT, UPDATE, DELETE ON TABLE account_merge_requests TO gw2auth_app ;
@@ -0,0 +1,12 @@
+ALTER TABLE application_api_keys
+ADD COLUMN last_used_time TIMESTAMP WITH TIME ZONE ;
+
+-- rounded location of the last request, same as for sessions
+ALTER TABLE application_api_keys
+ADD COLUMN last_used_approx_lat DOUBLE PRECISION ;
+
+ALTER TABLE application_api_keys
+ADD COLUMN last_used_approx_lng DOUBLE PRECISION ;
+
+ALTER TABLE application_api_keys
+ADD COLUMN request_count INT8 NOT NULL DEFAULT 0 ;
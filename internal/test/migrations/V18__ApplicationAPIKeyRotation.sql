@@ -0,0 +1,3 @@
+-- the key this key was issued to replace
+ALTER TABLE application_api_keys
+ADD COLUMN rotated_from_id UUID REFERENCES application_api_keys (id) ON DELETE SET NULL ;
@@ -0,0 +1,3 @@
+-- pending challenges are claimed by a worker until the lease expires, so concurrent workers do not evaluate the same challenge
+ALTER TABLE gw2_account_verification_pending_challenges
+ADD COLUMN claimed_until TIMESTAMP WITH TIME ZONE ;
@@ -0,0 +1,2 @@
+-- a key can be rotated only once
+CREATE UNIQUE INDEX ON application_api_keys (rotated_from_id) ;
@@ -102,3 +102,121 @@ VALUES ($1, $2)
 		accountId,
 	)
 }
+
+func CreateGw2AccountVerificationChallenge(t testing.TB, pool *pgxpool.Pool, accountId uuid.UUID, challengeId int, state string) {
+	MustExec(
+		t,
+		pool,
+		`
+INSERT INTO gw2_account_verification_challenges
+(account_id, challenge_id, state, creation_time)
+VALUES ($1, $2, $3, $4)
+`,
+		accountId,
+		challengeId,
+		state,
+		time.Now(),
+	)
+}
+
+func CreateGw2AccountVerificationPendingChallenge(t testing.TB, pool *pgxpool.Pool, accountId, gw2AccountId uuid.UUID, challengeId int, state, token string, creationTime, timeoutTime time.Time) {
+	MustExec(
+		t,
+		pool,
+		`
+INSERT INTO gw2_account_verification_pending_challenges
+(account_id, gw2_account_id, challenge_id, state, gw2_api_token, creation_time, submit_time, timeout_time)
+VALUES ($1, $2, $3, $4, $5, $6, $6, $7)
+`,
+		accountId,
+		gw2AccountId,
+		challengeId,
+		state,
+		token,
+		creationTime,
+		timeoutTime,
+	)
+}
+
+func CreateGw2ApiSubToken(t testing.TB, pool *pgxpool.Pool, accountId, gw2AccountId uuid.UUID, perms []gw2.Permission, subToken string, expirationTime time.Time) {
+	MustExec(
+		t,
+		pool,
+		`
+INSERT INTO gw2_account_api_subtokens
+(account_id, gw2_account_id, gw2_api_permissions_bit_set, gw2_api_subtoken, expiration_time)
+VALUES ($1, $2, $3, $4, $5)
+`,
+		accountId,
+		gw2AccountId,
+		gw2.PermissionsToBitSet(perms),
+		subToken,
+		expirationTime,
+	)
+}
+
+func CreateApplication(t testing.TB, pool *pgxpool.Pool, accountId, applicationId uuid.UUID, displayName string) {
+	MustExec(
+		t,
+		pool,
+		`INSERT INTO applications (id, account_id, creation_time, display_name) VALUES ($1, $2, NOW(), $3)`,
+		applicationId,
+		accountId,
+		displayName,
+	)
+}
+
+func CreateApplicationClient(t testing.TB, pool *pgxpool.Pool, applicationId, clientId uuid.UUID, displayName string, redirectURIs []string) {
+	MustExec(
+		t,
+		pool,
+		`
+INSERT INTO application_clients
+(id, application_id, creation_time, display_name, client_secret, authorization_grant_types, redirect_uris, requires_approval, api_version, type)
+VALUES
+($1, $2, NOW(), $3, 'secret', ARRAY['authorization_code', 'refresh_token'], $4, FALSE, 0, 'CONFIDENTIAL')
+`,
+		clientId,
+		applicationId,
+		displayName,
+		redirectURIs,
+	)
+}
+
+func CreateApplicationAPIKey(t testing.TB, pool *pgxpool.Pool, applicationId, keyId uuid.UUID, keyEncoded string, perms []string, notBefore, expiresAt time.Time) {
+	MustExec(
+		t,
+		pool,
+		`
+INSERT INTO application_api_keys
+(id, application_id, key, permissions, not_before, expires_at)
+VALUES
+($1, $2, $3, $4, $5, $6)
+`,
+		keyId,
+		applicationId,
+		keyEncoded,
+		perms,
+		notBefore,
+		expiresAt,
+	)
+}
+
+func CreateApplicationAccount(t testing.TB, pool *pgxpool.Pool, applicationId, accountId, accountSub uuid.UUID) {
+	MustExec(
+		t,
+		pool,
+		`INSERT INTO application_account_subs (application_id, account_id, account_sub) VALUES ($1, $2, $3)`,
+		applicationId,
+		accountId,
+		accountSub,
+	)
+
+	MustExec(
+		t,
+		pool,
+		`INSERT INTO application_accounts (application_id, account_id, creation_time) VALUES ($1, $2, NOW())`,
+		applicationId,
+		accountId,
+	)
+}
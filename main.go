@@ -4,13 +4,18 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"github.com/gw2auth/gw2auth.com-api/config"
+	"github.com/gw2auth/gw2auth.com-api/service"
 	"github.com/gw2auth/gw2auth.com-api/telemetry"
 	"github.com/labstack/echo/v4"
 	"io"
+	"io/fs"
 	"os"
 	"os/signal"
 	"path/filepath"
+	"time"
 )
 
 func main() {
@@ -21,7 +26,22 @@ func main() {
 		ctx,
 		"GW2AuthAPILambda",
 		func(ctx context.Context, t *telemetry.Telemetry) error {
-			return WithEchoServer(ctx, func(ctx context.Context, app *echo.Echo) error {
+			cfg, err := loadConfig(ctx)
+			if err != nil {
+				return err
+			}
+
+			go func() {
+				err := WithWorker(ctx, cfg, func(ctx context.Context, w *Worker) error {
+					return w.Run(ctx, time.Duration(cfg.WorkerInterval))
+				})
+
+				if err != nil && !errors.Is(err, context.Canceled) {
+					fmt.Printf("error running worker: %v\n", err)
+				}
+			}()
+
+			return WithEchoServer(ctx, cfg, func(ctx context.Context, app *echo.Echo) error {
 				go func() {
 					<-ctx.Done()
 					if err := app.Shutdown(context.Background()); err != nil {
@@ -31,7 +51,7 @@ func main() {
 					}
 				}()
 
-				return app.Start(":8090")
+				return app.Start(cfg.ListenAddr)
 			}, WithFlusher(t))
 		},
 		telemetry.WithResource(telemetry.NewLocalResource),
@@ -44,36 +64,65 @@ func main() {
 	}
 }
 
-func loadSecrets(ctx context.Context) (Secrets, error) {
+// loadConfig reads the local configuration in the following order (later sources override earlier ones):
+// the keys and defaults in ~/.gw2auth, ~/.gw2auth/config.yaml, the file referenced by GW2AUTH_CONFIG_FILE and
+// the GW2AUTH_* environment variables
+func loadConfig(ctx context.Context) (config.Config, error) {
 	home, err := os.UserHomeDir()
 	if err != nil {
-		return Secrets{}, err
+		return config.Config{}, err
 	}
 
-	pub1, err := loadFile(filepath.Join(home, ".gw2auth", "session_id_rsa_1.pub"))
-	if err != nil {
-		return Secrets{}, err
+	dir := filepath.Join(home, ".gw2auth")
+	sources := []config.Source{
+		localDefaults(dir),
+		config.FromOptionalFile(filepath.Join(dir, "config.yaml")),
 	}
 
-	pub2, err := loadFile(filepath.Join(home, ".gw2auth", "session_id_rsa_2.pub"))
-	if err != nil {
-		return Secrets{}, err
+	if path := os.Getenv(config.EnvPrefix + "CONFIG_FILE"); path != "" {
+		sources = append(sources, config.FromFile(path))
 	}
 
-	priv, err := loadFile(filepath.Join(home, ".gw2auth", "session_id_rsa_2"))
-	if err != nil {
-		return Secrets{}, err
+	sources = append(sources, config.FromEnv())
+
+	return config.Load(ctx, sources...)
+}
+
+func localDefaults(dir string) config.Source {
+	return func(ctx context.Context, cfg *config.Config) error {
+		cfg.DatabaseURL = "postgres://gw2auth_app:@localhost:26257/defaultdb"
+		cfg.SessionRSAPublicKid1 = "0412f229-a208-45d1-8c00-93f1ff92d24b"
+		cfg.SessionRSAPublicKid2 = "dbb703c6-87ec-4329-844e-01d03e373dac"
+		cfg.SessionRSAPrivateKid2 = "dbb703c6-87ec-4329-844e-01d03e373dac"
+
+		files := map[string]*string{
+			"session_id_rsa_1.pub": &cfg.SessionRSAPublicPEM1,
+			"session_id_rsa_2.pub": &cfg.SessionRSAPublicPEM2,
+			"session_id_rsa_2":     &cfg.SessionRSAPrivatePEM2,
+		}
+
+		for name, p := range files {
+			v, err := loadFile(filepath.Join(dir, name))
+			if err != nil {
+				// the keys may also be provided by one of the other sources
+				if errors.Is(err, fs.ErrNotExist) {
+					continue
+				}
+
+				return err
+			}
+
+			*p = v
+		}
+
+		return nil
 	}
+}
 
-	return Secrets{
-		DatabaseURL:           "postgres://gw2auth_app:@localhost:26257/defaultdb",
-		SessionRSAPublicKid1:  "0412f229-a208-45d1-8c00-93f1ff92d24b",
-		SessionRSAPublicKid2:  "dbb703c6-87ec-4329-844e-01d03e373dac",
-		SessionRSAPrivateKid2: "dbb703c6-87ec-4329-844e-01d03e373dac",
-		SessionRSAPublicPEM1:  pub1,
-		SessionRSAPublicPEM2:  pub2,
-		SessionRSAPrivatePEM2: priv,
-	}, nil
+func keySetFromS3(bucket, key string) service.KeySetSource {
+	return func(ctx context.Context) (*service.KeySet, error) {
+		return nil, errors.New("s3 jwks urls are only supported in lambda builds")
+	}
 }
 
 func loadFile(path string) (string, error) {
@@ -0,0 +1,42 @@
+//go:build lambda && lambda.norpc && worker
+
+package main
+
+import (
+	"context"
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/gw2auth/gw2auth.com-api/telemetry"
+	"go.opentelemetry.io/contrib/instrumentation/github.com/aws/aws-lambda-go/otellambda"
+)
+
+func main() {
+	ctx := context.Background()
+
+	err := WithTelemetry(
+		ctx,
+		"GW2AuthAPIWorkerLambda",
+		func(ctx context.Context, t *telemetry.Telemetry) error {
+			cfg, err := loadConfig(ctx)
+			if err != nil {
+				return err
+			}
+
+			return WithWorker(ctx, cfg, func(ctx context.Context, w *Worker) error {
+				h := func(ctx context.Context) error {
+					return w.RunOnce(ctx)
+				}
+
+				lambda.Start(otellambda.InstrumentHandler(h, otellambda.WithTracerProvider(t.TracerProvider()), otellambda.WithFlusher(t)))
+
+				return nil
+			})
+		},
+		telemetry.WithResource(telemetry.NewLambdaResource),
+		telemetry.WithTracerProvider(telemetry.NewLambdaTracerProvider),
+		telemetry.WithLoggerProvider(telemetry.NewLambdaLoggerProvider),
+	)
+
+	if err != nil {
+		panic(err)
+	}
+}
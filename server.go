@@ -3,8 +3,10 @@ package main
 import (
 	"context"
 	"crypto/rsa"
+	"fmt"
 	"github.com/exaring/otelpgx"
 	"github.com/golang-jwt/jwt/v5"
+	"github.com/gw2auth/gw2auth.com-api/config"
 	"github.com/gw2auth/gw2auth.com-api/service"
 	"github.com/gw2auth/gw2auth.com-api/service/auth"
 	"github.com/gw2auth/gw2auth.com-api/service/gw2"
@@ -13,62 +15,106 @@ import (
 	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgxpool"
 	"github.com/labstack/echo/v4"
+	"github.com/labstack/echo/v4/middleware"
 	"go.opentelemetry.io/contrib/instrumentation/github.com/aws/aws-lambda-go/otellambda"
 	"go.opentelemetry.io/contrib/instrumentation/github.com/labstack/echo/otelecho"
 	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"golang.org/x/time/rate"
+	"log/slog"
 	"net/http"
+	"net/url"
+	"strings"
+	"time"
 )
 
-type Secrets struct {
-	DatabaseURL           string `json:"databaseURL"`
-	SessionRSAPublicKid1  string `json:"sessionRSAPublicKid1"`
-	SessionRSAPublicKid2  string `json:"sessionRSAPublicKid2"`
-	SessionRSAPrivateKid2 string `json:"sessionRSAPrivateKid2"`
-	SessionRSAPublicPEM1  string `json:"sessionRSAPublicPEM1"`
-	SessionRSAPublicPEM2  string `json:"sessionRSAPublicPEM2"`
-	SessionRSAPrivatePEM2 string `json:"sessionRSAPrivatePEM2"`
-}
+type Option func(o *serverOptions)
 
-type Option func(app *echo.Echo)
+type serverOptions struct {
+	pre      []echo.MiddlewareFunc
+	shutdown []func(shutdown func())
+}
 
-func newPgx(secrets Secrets) (*pgxpool.Pool, error) {
-	config, err := pgxpool.ParseConfig(secrets.DatabaseURL)
+func newPgx(cfg config.Config) (*pgxpool.Pool, error) {
+	poolConfig, err := pgxpool.ParseConfig(cfg.DatabaseURL)
 	if err != nil {
 		return nil, err
 	}
 
-	config.ConnConfig.Tracer = otelpgx.NewTracer()
-	config.ConnConfig.RuntimeParams["application_name"] = "api.gw2auth.com"
-	config.AfterConnect = func(ctx context.Context, conn *pgx.Conn) error {
+	poolConfig.ConnConfig.Tracer = otelpgx.NewTracer()
+	poolConfig.ConnConfig.RuntimeParams["application_name"] = "api.gw2auth.com"
+	poolConfig.AfterConnect = func(ctx context.Context, conn *pgx.Conn) error {
 		pgxuuid.Register(conn.TypeMap())
 		return nil
 	}
 
-	return pgxpool.NewWithConfig(context.Background(), config)
+	return pgxpool.NewWithConfig(context.Background(), poolConfig)
 }
 
-func newConv(secrets Secrets) (*service.SessionJwtConverter, error) {
-	pub1, err := jwt.ParseRSAPublicKeyFromPEM([]byte(secrets.SessionRSAPublicPEM1))
+func newConv(cfg config.Config) (*service.SessionJwtConverter, error) {
+	pub1, err := jwt.ParseRSAPublicKeyFromPEM([]byte(cfg.SessionRSAPublicPEM1))
 	if err != nil {
 		return nil, err
 	}
 
-	pub2, err := jwt.ParseRSAPublicKeyFromPEM([]byte(secrets.SessionRSAPublicPEM2))
+	pub2, err := jwt.ParseRSAPublicKeyFromPEM([]byte(cfg.SessionRSAPublicPEM2))
 	if err != nil {
 		return nil, err
 	}
 
-	priv, err := jwt.ParseRSAPrivateKeyFromPEM([]byte(secrets.SessionRSAPrivatePEM2))
+	priv, err := jwt.ParseRSAPrivateKeyFromPEM([]byte(cfg.SessionRSAPrivatePEM2))
 	if err != nil {
 		return nil, err
 	}
 
 	pub := map[string]*rsa.PublicKey{
-		secrets.SessionRSAPublicKid1: pub1,
-		secrets.SessionRSAPublicKid2: pub2,
+		cfg.SessionRSAPublicKid1: pub1,
+		cfg.SessionRSAPublicKid2: pub2,
+	}
+
+	return service.NewSessionJwtConverter(cfg.SessionRSAPrivateKid2, priv, pub), nil
+}
+
+// newKeySetSource supports file:, http(s): and s3: urls; s3 is only available in lambda builds.
+// An http(s) document only provides the public keys, the signing key is loaded from signingKeyURL.
+func newKeySetSource(httpClient *http.Client, rawURL, signingKeyURL string) (service.KeySetSource, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return newPrivateKeySetSource(u)
+	}
+
+	su, err := url.Parse(signingKeyURL)
+	if err != nil {
+		return nil, err
 	}
 
-	return service.NewSessionJwtConverter(secrets.SessionRSAPrivateKid2, priv, pub), nil
+	signing, err := newPrivateKeySetSource(su)
+	if err != nil {
+		return nil, err
+	}
+
+	return service.KeySetFromURL(httpClient, rawURL, signing), nil
+}
+
+// newPrivateKeySetSource supports file: and s3: urls for documents which contain the signing key
+func newPrivateKeySetSource(u *url.URL) (service.KeySetSource, error) {
+	switch u.Scheme {
+	case "file":
+		if u.Opaque != "" {
+			return service.KeySetFromFile(u.Opaque), nil
+		}
+
+		return service.KeySetFromFile(u.Path), nil
+
+	case "s3":
+		return keySetFromS3(u.Host, strings.TrimPrefix(u.Path, "/")), nil
+
+	default:
+		return nil, fmt.Errorf("unsupported jwks url scheme %q", u.Scheme)
+	}
 }
 
 func newHttpClient() *http.Client {
@@ -77,30 +123,63 @@ func newHttpClient() *http.Client {
 	}
 }
 
-func newGw2ApiClient(httpClient *http.Client) *gw2.ApiClient {
-	return gw2.NewApiClient(httpClient, "https://api.guildwars2.com")
+func newGw2ApiClient(httpClient *http.Client, cfg config.Config) *gw2.ApiClient {
+	return gw2.NewApiClient(
+		httpClient,
+		cfg.Gw2ApiURL,
+		gw2.WithRetries(2, 250*time.Millisecond, 3*time.Second),
+		gw2.WithRateLimit(rate.Limit(5), 50),
+		gw2.WithCircuitBreaker(10, 30*time.Second),
+	)
 }
 
-func newEchoServer(pool *pgxpool.Pool, httpClient *http.Client, gw2ApiClient *gw2.ApiClient, conv *service.SessionJwtConverter, options ...Option) *echo.Echo {
+func newEchoServer(cfg config.Config, pool *pgxpool.Pool, httpClient *http.Client, gw2ApiClient *gw2.ApiClient, conv *service.SessionJwtConverter, apiKeyUsage *auth.APIKeyUsageRecorder, opts serverOptions) *echo.Echo {
 	app := echo.New()
-
-	for _, opt := range options {
-		opt(app)
-	}
+	app.HTTPErrorHandler = web.HTTPErrorHandler
+	app.Pre(opts.pre...)
 
 	app.Use(
+		middleware.RequestID(),
 		otelecho.Middleware("api.gw2auth.com"),
 		web.Middleware(pool),
 	)
 
 	// region UI
 	uiGroup := app.Group("/api-v2", web.DeleteHistoricalCookiesMiddleware(), web.CSRFMiddleware())
-	authMw := web.AuthenticatedMiddleware(conv)
+	var sessionCache *auth.SessionCache
+	if cfg.SessionCacheTTL > 0 {
+		sessionCache = auth.NewSessionCache(time.Duration(cfg.SessionCacheTTL), cfg.SessionCacheSize)
+	}
+
+	authMw := web.AuthenticatedMiddleware(
+		conv,
+		auth.AnomalyPolicy{
+			MaxDistanceKm:   cfg.SessionAnomalyMaxDistanceKm,
+			AlwaysAllowedKm: cfg.SessionAnomalyAlwaysAllowedKm,
+			MaxKmPerDay:     cfg.SessionAnomalyMaxKmPerDay,
+		},
+		web.WithSessionCache(sessionCache),
+		web.WithSessionRenewThreshold(time.Duration(cfg.SessionRenewThreshold)),
+	)
+	stepUpMw := web.StepUpMiddleware()
+
+	var apiKeyCache *auth.APIKeyCache
+	if cfg.APIKeyCacheTTL > 0 {
+		apiKeyCache = auth.NewAPIKeyCache(time.Duration(cfg.APIKeyCacheTTL), cfg.APIKeyCacheSize)
+	}
 
 	uiGroup.GET("/account", web.AccountEndpoint(), authMw)
-	uiGroup.DELETE("/account", web.DeleteAccountEndpoint(), authMw)
+	uiGroup.DELETE("/account", web.DeleteAccountEndpoint(), authMw, stepUpMw)
 	uiGroup.DELETE("/account/federation", web.DeleteAccountFederationEndpoint(), authMw)
 	uiGroup.DELETE("/account/session", web.DeleteAccountFederationSessionEndpoint(), authMw)
+	uiGroup.DELETE("/account/session/other", web.DeleteOtherAccountFederationSessionsEndpoint(), authMw)
+	uiGroup.GET("/account/log", web.AccountLogsEndpoint(), authMw)
+	uiGroup.GET("/account/export", web.AccountExportEndpoint(), authMw, stepUpMw)
+	uiGroup.PUT("/account/merge", web.CreateAccountMergeRequestEndpoint(), authMw, stepUpMw)
+	uiGroup.POST("/account/merge", web.MergeAccountEndpoint(), authMw, stepUpMw)
+	uiGroup.GET("/account/trustedregion", web.AccountTrustedRegionsEndpoint(), authMw)
+	uiGroup.PUT("/account/trustedregion", web.CreateAccountTrustedRegionEndpoint(), authMw, stepUpMw)
+	uiGroup.DELETE("/account/trustedregion/:id", web.DeleteAccountTrustedRegionEndpoint(), authMw)
 
 	uiGroup.GET("/application/summary", web.AppSummaryEndpoint())
 	uiGroup.GET("/authinfo", web.AuthInfoEndpoint(), authMw)
@@ -120,54 +199,84 @@ func newEchoServer(pool *pgxpool.Pool, httpClient *http.Client, gw2ApiClient *gw
 	uiGroup.GET("/application/:id", web.UserApplicationEndpoint(), authMw)
 	uiGroup.DELETE("/application/:id", web.DeleteUserApplicationEndpoint(), authMw)
 
+	uiGroup.PUT("/verification", web.StartVerificationEndpoint(), authMw)
+	uiGroup.DELETE("/verification", web.CancelVerificationEndpoint(), authMw)
+	uiGroup.POST("/verification", web.SubmitVerificationEndpoint(gw2ApiClient), authMw)
 	uiGroup.GET("/verification/active", web.VerificationActiveEndpoint(), authMw)
 	uiGroup.GET("/verification/pending", web.VerificationPendingEndpoint(), authMw)
 
 	uiGroup.PUT("/dev/application", web.CreateDevApplicationEndpoint(), authMw)
 	uiGroup.GET("/dev/application", web.DevApplicationsEndpoint(), authMw)
 	uiGroup.GET("/dev/application/:id", web.DevApplicationEndpoint(), authMw)
-	uiGroup.DELETE("/dev/application/:id", web.DeleteDevApplicationEndpoint(), authMw)
+	uiGroup.DELETE("/dev/application/:id", web.DeleteDevApplicationEndpoint(apiKeyCache), authMw)
 	uiGroup.GET("/dev/application/:id/user", web.DevApplicationUsersEndpoint(), authMw)
 	uiGroup.PUT("/dev/application/:id/client", web.CreateDevApplicationClientEndpoint(), authMw)
 	uiGroup.GET("/dev/application/:app_id/client/:client_id", web.DevApplicationClientEndpoint(), authMw)
 	uiGroup.DELETE("/dev/application/:app_id/client/:client_id", web.DeleteDevApplicationClientEndpoint(), authMw)
-	uiGroup.POST("/dev/application/:app_id/client/:client_id/secret", web.RegenerateDevApplicationClientSecretEndpoint(), authMw)
+	uiGroup.POST("/dev/application/:app_id/client/:client_id/secret", web.RegenerateDevApplicationClientSecretEndpoint(), authMw, stepUpMw)
 	uiGroup.PUT("/dev/application/:app_id/client/:client_id/redirecturi", web.UpdateDevApplicationClientRedirectURIsEndpoint(), authMw)
 	uiGroup.PATCH("/dev/application/:app_id/client/:client_id/user/:user_id", web.UpdateDevApplicationClientUserEndpoint(), authMw)
-	uiGroup.PUT("/dev/application/:id/apikey", web.CreateDevApplicationAPIKeyEndpoint(), authMw)
-	uiGroup.DELETE("/dev/application/:app_id/apikey/:key_id", web.DeleteDevApplicationAPIKeyEndpoint(), authMw)
+	uiGroup.PUT("/dev/application/:id/apikey", web.CreateDevApplicationAPIKeyEndpoint(), authMw, stepUpMw)
+	uiGroup.DELETE("/dev/application/:app_id/apikey/:key_id", web.DeleteDevApplicationAPIKeyEndpoint(apiKeyCache), authMw)
+	uiGroup.POST("/dev/application/:app_id/apikey/:key_id/rotate", web.RotateDevApplicationAPIKeyEndpoint(apiKeyCache), authMw, stepUpMw)
 
-	uiGroup.GET("/notifications", web.NotificationsEndpoint(httpClient))
+	uiGroup.GET("/notifications", web.NotificationsEndpoint(httpClient, cfg.Gw2ApiURL, cfg.Gw2EfficiencyStatusURL))
 	// endregion
 
 	// region application api
-	applicationAPIGroup := app.Group("/api-app", web.ApplicationAPIKeyAuthenticatedMiddleware())
+	applicationAPIGroup := app.Group("/api-app", web.ApplicationAPIKeyAuthenticatedMiddleware(
+		web.WithAPIKeyUsageRecorder(apiKeyUsage),
+		web.WithAPIKeyCache(apiKeyCache),
+		web.WithAPIKeyFailureLimiter(auth.NewAPIKeyFailureLimiter(cfg.APIKeyFailureLimit, cfg.APIKeyFailureLimitPerClient, time.Duration(cfg.APIKeyFailureWindow))),
+	))
+	applicationAPIGroup.POST("/apikey/rotate", web.APIKeyRotateEndpoint(apiKeyCache), web.ApplicationAPIKeyPermissionMiddleware(auth.PermissionAPIKeyRotate))
+	applicationAPIGroup.GET("/application", web.APIKeyDevApplicationEndpoint(), web.ApplicationAPIKeyPermissionMiddleware(auth.PermissionRead))
+	applicationAPIGroup.GET("/application/user", web.APIKeyDevApplicationUsersEndpoint(), web.ApplicationAPIKeyPermissionMiddleware(auth.PermissionRead))
+	applicationAPIGroup.PUT("/application/client", web.APIKeyCreateDevApplicationClientEndpoint(), web.ApplicationAPIKeyPermissionMiddleware(auth.PermissionClientCreate))
+	applicationAPIGroup.GET("/application/client/:client_id", web.APIKeyDevApplicationClientEndpoint(), web.ApplicationAPIKeyPermissionMiddleware(auth.PermissionRead))
+	applicationAPIGroup.DELETE("/application/client/:client_id", web.APIKeyDeleteDevApplicationClientEndpoint(), web.ApplicationAPIKeyPermissionMiddleware(auth.PermissionClientDelete))
+	applicationAPIGroup.POST("/application/client/:client_id/secret", web.APIKeyRegenerateDevApplicationClientSecretEndpoint(), web.ApplicationAPIKeyPermissionMiddleware(auth.PermissionClientSecret))
 	applicationAPIGroup.PATCH("/application/client/:client_id/redirecturi", web.ModifyDevApplicationClientRedirectURIsEndpoint(), web.ApplicationAPIKeyPermissionMiddleware(auth.PermissionClientModify))
+	applicationAPIGroup.PATCH("/application/client/:client_id/user/:user_id", web.APIKeyUpdateDevApplicationClientUserEndpoint(), web.ApplicationAPIKeyPermissionMiddleware(auth.PermissionUserApprove))
 	// endregion
 
 	return app
 }
 
-func WithEchoServer(ctx context.Context, fn func(ctx context.Context, app *echo.Echo) error, options ...Option) error {
+func WithEchoServer(ctx context.Context, cfg config.Config, fn func(ctx context.Context, app *echo.Echo) error, options ...Option) error {
 	ctx, cancel := context.WithCancel(ctx)
 	defer cancel()
 
-	secrets, err := loadSecrets(ctx)
-	if err != nil {
-		return err
+	var opts serverOptions
+	for _, opt := range options {
+		opt(&opts)
 	}
 
-	return withPgx(secrets, func(pool *pgxpool.Pool) error {
-		return withConv(secrets, func(conv *service.SessionJwtConverter) error {
-			httpClient := newHttpClient()
-			return fn(ctx, newEchoServer(pool, httpClient, newGw2ApiClient(httpClient), conv, options...))
+	httpClient := newHttpClient()
+
+	return withPgx(cfg, func(pool *pgxpool.Pool) error {
+		return withConv(ctx, cfg, httpClient, func(conv *service.SessionJwtConverter) error {
+			return withAPIKeyUsageRecorder(ctx, cfg, pool, func(apiKeyUsage *auth.APIKeyUsageRecorder) error {
+				for _, register := range opts.shutdown {
+					register(func() {
+						ctx, cancel := context.WithTimeout(context.Background(), 250*time.Millisecond)
+						defer cancel()
+
+						if err := apiKeyUsage.Flush(ctx); err != nil {
+							slog.ErrorContext(ctx, "api key usage flush on shutdown failed", slog.String("error", err.Error()))
+						}
+					})
+				}
+
+				return fn(ctx, newEchoServer(cfg, pool, httpClient, newGw2ApiClient(httpClient, cfg), conv, apiKeyUsage, opts))
+			})
 		})
 	})
 }
 
 func WithFlusher(flusher otellambda.Flusher) Option {
-	return func(app *echo.Echo) {
-		app.Pre(func(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(o *serverOptions) {
+		o.pre = append(o.pre, func(next echo.HandlerFunc) echo.HandlerFunc {
 			return func(c echo.Context) error {
 				defer flusher.ForceFlush(c.Request().Context())
 				return next(c)
@@ -176,8 +285,16 @@ func WithFlusher(flusher otellambda.Flusher) Option {
 	}
 }
 
-func withPgx(secrets Secrets, fn func(pool *pgxpool.Pool) error) error {
-	pool, err := newPgx(secrets)
+// WithShutdown calls register with a function which synchronously flushes the buffered api key usage.
+// Required where the process is terminated without WithEchoServer returning, e.g. on SIGTERM in lambda.
+func WithShutdown(register func(shutdown func())) Option {
+	return func(o *serverOptions) {
+		o.shutdown = append(o.shutdown, register)
+	}
+}
+
+func withPgx(cfg config.Config, fn func(pool *pgxpool.Pool) error) error {
+	pool, err := newPgx(cfg)
 	if err != nil {
 		return err
 	}
@@ -187,11 +304,49 @@ func withPgx(secrets Secrets, fn func(pool *pgxpool.Pool) error) error {
 	return fn(pool)
 }
 
-func withConv(secrets Secrets, fn func(conv *service.SessionJwtConverter) error) error {
-	conv, err := newConv(secrets)
+// withConv keeps the keys of the converter up to date until ctx is done if cfg.SessionJWKSURL is set
+func withConv(ctx context.Context, cfg config.Config, httpClient *http.Client, fn func(conv *service.SessionJwtConverter) error) error {
+	if cfg.SessionJWKSURL == "" {
+		conv, err := newConv(cfg)
+		if err != nil {
+			return err
+		}
+
+		return fn(conv)
+	}
+
+	src, err := newKeySetSource(httpClient, cfg.SessionJWKSURL, cfg.SessionSigningKeyURL)
 	if err != nil {
 		return err
 	}
 
+	ks, err := src(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to load session key set: %w", err)
+	}
+
+	conv := service.NewSessionJwtConverterFromKeySet(ks)
+	go conv.RefreshEvery(ctx, src, time.Duration(cfg.SessionJWKSRefreshInterval))
+
 	return fn(conv)
 }
+
+// withAPIKeyUsageRecorder flushes the recorded api key usage in the background every cfg.APIKeyUsageFlushInterval
+// or once cfg.APIKeyUsageFlushThreshold requests were recorded, and once more before returning.
+func withAPIKeyUsageRecorder(ctx context.Context, cfg config.Config, pool *pgxpool.Pool, fn func(apiKeyUsage *auth.APIKeyUsageRecorder) error) error {
+	apiKeyUsage := auth.NewAPIKeyUsageRecorder(pool, cfg.APIKeyUsageFlushThreshold, time.Duration(cfg.APIKeyUsageFlushInterval))
+	ctx, cancel := context.WithCancel(ctx)
+	done := make(chan struct{})
+
+	go func() {
+		defer close(done)
+		_ = apiKeyUsage.FlushEvery(ctx, time.Duration(cfg.APIKeyUsageFlushInterval))
+	}()
+
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	return fn(apiKeyUsage)
+}
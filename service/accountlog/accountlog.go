@@ -0,0 +1,126 @@
+package accountlog
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	crdbpgx "github.com/cockroachdb/cockroach-go/v2/crdb/crdbpgxv5"
+	"github.com/gofrs/uuid/v5"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"log/slog"
+	"time"
+)
+
+type Type string
+
+const (
+	TypeApiTokenAdded            Type = "api_token_added"
+	TypeApiTokenUpdated          Type = "api_token_updated"
+	TypeApiTokenDeleted          Type = "api_token_deleted"
+	TypeVerificationStarted      Type = "verification_started"
+	TypeVerificationSubmitted    Type = "verification_submitted"
+	TypeVerificationCompleted    Type = "verification_completed"
+	TypeFederationDeleted        Type = "federation_deleted"
+	TypeSessionDeleted           Type = "session_deleted"
+	TypeOtherSessionsDeleted     Type = "other_sessions_deleted"
+	TypeApplicationRevoked       Type = "application_revoked"
+	TypeApplicationAPIKeyCreated Type = "application_api_key_created"
+	TypeApplicationAPIKeyDeleted Type = "application_api_key_deleted"
+	TypeApplicationAPIKeyRotated Type = "application_api_key_rotated"
+	TypeAccountMerged            Type = "account_merged"
+	TypeTrustedRegionCreated     Type = "trusted_region_created"
+	TypeTrustedRegionDeleted     Type = "trusted_region_deleted"
+)
+
+const defaultCleanerBatchSize = 1000
+
+type Fields map[string]any
+
+type Execer interface {
+	Exec(ctx context.Context, sql string, arguments ...any) (pgconn.CommandTag, error)
+}
+
+// Write adds an entry to the log of the account. It should be called with the transaction
+// of the change it describes, so that an entry exists if and only if the change was committed.
+func Write(ctx context.Context, conn Execer, accountId uuid.UUID, t Type, fields Fields) error {
+	id, err := uuid.NewV4()
+	if err != nil {
+		return err
+	}
+
+	if fields == nil {
+		fields = Fields{}
+	}
+
+	rawFields, err := json.Marshal(fields)
+	if err != nil {
+		return err
+	}
+
+	const sql = `
+INSERT INTO account_logs
+(id, account_id, timestamp, type, fields)
+VALUES
+($1, $2, $3, $4, $5)
+`
+	if _, err = conn.Exec(ctx, sql, id, accountId, time.Now(), t, rawFields); err != nil {
+		return fmt.Errorf("failed to write account log: %w", err)
+	}
+
+	return nil
+}
+
+// Cleaner deletes log entries which are older than the retention.
+// Entries are deleted in batches of batchSize, each in its own transaction, so a large backlog does not result in a single huge transaction.
+type Cleaner struct {
+	pool      *pgxpool.Pool
+	retention time.Duration
+	batchSize int
+}
+
+func NewCleaner(pool *pgxpool.Pool, retention time.Duration) *Cleaner {
+	return &Cleaner{
+		pool:      pool,
+		retention: retention,
+		batchSize: defaultCleanerBatchSize,
+	}
+}
+
+func (c *Cleaner) RunOnce(ctx context.Context) error {
+	before := time.Now().Add(-c.retention)
+	var deleted int64
+	for {
+		var n int64
+		err := crdbpgx.ExecuteTx(ctx, c.pool, pgx.TxOptions{}, func(tx pgx.Tx) error {
+			const sql = `
+DELETE FROM account_logs
+WHERE timestamp < $1
+LIMIT $2
+`
+			tag, err := tx.Exec(ctx, sql, before, c.batchSize)
+			if err != nil {
+				return err
+			}
+
+			n = tag.RowsAffected()
+			return nil
+		})
+
+		if err != nil {
+			return err
+		}
+
+		deleted += n
+		if n < int64(c.batchSize) {
+			break
+		}
+	}
+
+	if deleted > 0 {
+		slog.InfoContext(ctx, "deleted expired account logs", slog.Int64("account_log.deleted", deleted))
+	}
+
+	return nil
+}
@@ -0,0 +1,79 @@
+package accountlog
+
+import (
+	"context"
+	"github.com/gw2auth/gw2auth.com-api/internal/test"
+	"github.com/gw2auth/gw2auth.com-api/service"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestAccountLogAll(t *testing.T) {
+	test.RunAll(t, dbScope, map[string]map[string]test.Fn{
+		"Write": {
+			"simple": testWriteSimple,
+		},
+		"Cleaner": {
+			"retention": testCleanerRetention,
+			"batches":   testCleanerBatches,
+		},
+	})
+}
+
+func testWriteSimple(t *testing.T, pool *pgxpool.Pool, conv *service.SessionJwtConverter, truncateTablesFn func() error) {
+	accountId := test.NewUUID(t)
+	test.CreateAccount(t, pool, accountId, time.Now())
+
+	assert.NoError(t, Write(context.Background(), pool, accountId, TypeSessionDeleted, Fields{"sessionId": "abc"}))
+	test.MustExist(
+		t,
+		pool,
+		`SELECT TRUE FROM account_logs WHERE account_id = $1 AND type = $2 AND fields = '{"sessionId": "abc"}'::JSONB`,
+		accountId,
+		TypeSessionDeleted,
+	)
+}
+
+func testCleanerRetention(t *testing.T, pool *pgxpool.Pool, conv *service.SessionJwtConverter, truncateTablesFn func() error) {
+	accountId := test.NewUUID(t)
+	test.CreateAccount(t, pool, accountId, time.Now())
+
+	oldId, newId := test.NewUUID(t), test.NewUUID(t)
+	test.MustExec(
+		t,
+		pool,
+		`INSERT INTO account_logs (id, account_id, timestamp, type, fields) VALUES ($1, $3, $4, 'session_deleted', '{}'), ($2, $3, NOW(), 'session_deleted', '{}')`,
+		oldId,
+		newId,
+		accountId,
+		time.Now().Add(-48*time.Hour),
+	)
+
+	assert.NoError(t, NewCleaner(pool, 24*time.Hour).RunOnce(context.Background()))
+	test.MustNotExist(t, pool, `SELECT TRUE FROM account_logs WHERE id = $1`, oldId)
+	test.MustExist(t, pool, `SELECT TRUE FROM account_logs WHERE id = $1`, newId)
+}
+
+func testCleanerBatches(t *testing.T, pool *pgxpool.Pool, conv *service.SessionJwtConverter, truncateTablesFn func() error) {
+	accountId := test.NewUUID(t)
+	test.CreateAccount(t, pool, accountId, time.Now())
+
+	for range 5 {
+		test.MustExec(
+			t,
+			pool,
+			`INSERT INTO account_logs (id, account_id, timestamp, type, fields) VALUES ($1, $2, $3, 'session_deleted', '{}')`,
+			test.NewUUID(t),
+			accountId,
+			time.Now().Add(-48*time.Hour),
+		)
+	}
+
+	c := NewCleaner(pool, 24*time.Hour)
+	c.batchSize = 2
+
+	assert.NoError(t, c.RunOnce(context.Background()))
+	test.MustNotExist(t, pool, `SELECT TRUE FROM account_logs WHERE account_id = $1`, accountId)
+}
@@ -0,0 +1,234 @@
+package accountmerge
+
+import (
+	"context"
+	"errors"
+	"github.com/gofrs/uuid/v5"
+	"github.com/jackc/pgx/v5"
+)
+
+type Result struct {
+	// Gw2AccountConflicts are gw2 accounts present on both accounts; the name and order of the target account are kept
+	Gw2AccountConflicts []uuid.UUID
+	// ApplicationConflicts are applications both accounts were known to; access granted by the source account is dropped
+	ApplicationConflicts []uuid.UUID
+}
+
+type applicationSub struct {
+	ApplicationId uuid.UUID
+	AccountSub    uuid.UUID
+}
+
+// Merge moves everything owned by the source account into the target account and deletes the source account.
+// It must run within a single transaction.
+//
+// All sessions of the source account are deleted, so that the merged account can not be used anymore.
+//
+// Conflicts are resolved in favor of the target account, except for api tokens of gw2 accounts present on both accounts:
+// the token which was valid most recently is kept.
+func Merge(ctx context.Context, tx pgx.Tx, targetAccountId, sourceAccountId uuid.UUID) (Result, error) {
+	if targetAccountId == sourceAccountId {
+		return Result{}, errors.New("can not merge an account into itself")
+	}
+
+	var res Result
+	var err error
+
+	const gw2AccountConflictsSQL = `
+SELECT src.gw2_account_id
+FROM gw2_accounts src
+INNER JOIN gw2_accounts tgt
+ON src.gw2_account_id = tgt.gw2_account_id
+WHERE tgt.account_id = $1
+AND src.account_id = $2
+`
+	if res.Gw2AccountConflicts, err = collectUUIDs(ctx, tx, gw2AccountConflictsSQL, targetAccountId, sourceAccountId); err != nil {
+		return Result{}, err
+	}
+
+	const applicationConflictsSQL = `
+SELECT src.application_id
+FROM application_account_subs src
+INNER JOIN application_account_subs tgt
+ON src.application_id = tgt.application_id
+WHERE tgt.account_id = $1
+AND src.account_id = $2
+`
+	if res.ApplicationConflicts, err = collectUUIDs(ctx, tx, applicationConflictsSQL, targetAccountId, sourceAccountId); err != nil {
+		return Result{}, err
+	}
+
+	sqls := []string{
+		// gw2 accounts, api tokens and verifications
+		`
+INSERT INTO gw2_accounts
+(account_id, gw2_account_id, creation_time, display_name, order_rank, gw2_account_name, last_name_check_time)
+SELECT $1, gw2_account_id, creation_time, display_name, order_rank, gw2_account_name, last_name_check_time
+FROM gw2_accounts
+WHERE account_id = $2
+ON CONFLICT (account_id, gw2_account_id) DO NOTHING
+`,
+		// subtokens of api tokens which might be replaced below
+		`
+DELETE FROM gw2_account_api_subtokens
+WHERE account_id = $1
+AND gw2_account_id IN (
+	SELECT gw2_account_id
+	FROM gw2_account_api_tokens
+	WHERE account_id = $2
+)
+`,
+		`
+INSERT INTO gw2_account_api_tokens
+(account_id, gw2_account_id, creation_time, gw2_api_token, gw2_api_permissions_bit_set, last_valid_time, last_valid_check_time)
+SELECT $1, gw2_account_id, creation_time, gw2_api_token, gw2_api_permissions_bit_set, last_valid_time, last_valid_check_time
+FROM gw2_account_api_tokens
+WHERE account_id = $2
+ON CONFLICT (account_id, gw2_account_id) DO UPDATE SET
+creation_time = EXCLUDED.creation_time,
+gw2_api_token = EXCLUDED.gw2_api_token,
+gw2_api_permissions_bit_set = EXCLUDED.gw2_api_permissions_bit_set,
+last_valid_time = EXCLUDED.last_valid_time,
+last_valid_check_time = EXCLUDED.last_valid_check_time
+WHERE EXCLUDED.last_valid_time > gw2_account_api_tokens.last_valid_time
+`,
+		"UPDATE gw2_account_verifications SET account_id = $1 WHERE account_id = $2",
+		`
+INSERT INTO gw2_account_verification_pending_challenges
+(account_id, gw2_account_id, challenge_id, state, gw2_api_token, creation_time, submit_time, timeout_time)
+SELECT $1, gw2_account_id, challenge_id, state, gw2_api_token, creation_time, submit_time, timeout_time
+FROM gw2_account_verification_pending_challenges
+WHERE account_id = $2
+ON CONFLICT (account_id, gw2_account_id) DO NOTHING
+`,
+		`
+INSERT INTO gw2_account_verification_challenges
+(account_id, challenge_id, state, creation_time)
+SELECT $1, challenge_id, state, creation_time
+FROM gw2_account_verification_challenges
+WHERE account_id = $2
+ON CONFLICT (account_id) DO NOTHING
+`,
+		"UPDATE application_client_authorization_gw2_accounts SET account_id = $1 WHERE account_id = $2",
+		// developer applications, including their clients and api keys
+		"UPDATE applications SET account_id = $1 WHERE account_id = $2",
+		// everything else directly owned by the account
+		"UPDATE account_trusted_regions SET account_id = $1 WHERE account_id = $2",
+		"UPDATE account_logs SET account_id = $1 WHERE account_id = $2",
+	}
+
+	for _, sql := range sqls {
+		if _, err = tx.Exec(ctx, sql, targetAccountId, sourceAccountId); err != nil {
+			return Result{}, err
+		}
+	}
+
+	if err = moveApplicationAccounts(ctx, tx, targetAccountId, sourceAccountId); err != nil {
+		return Result{}, err
+	}
+
+	// sessions of the source account are revoked for every instance; federations only move for future logins
+	const deleteSessionsSQL = `
+DELETE FROM account_federation_sessions
+WHERE (issuer, id_at_issuer) IN (
+	SELECT issuer, id_at_issuer
+	FROM account_federations
+	WHERE account_id = $1
+)
+`
+	if _, err = tx.Exec(ctx, deleteSessionsSQL, sourceAccountId); err != nil {
+		return Result{}, err
+	}
+
+	if _, err = tx.Exec(ctx, "UPDATE account_federations SET account_id = $1 WHERE account_id = $2", targetAccountId, sourceAccountId); err != nil {
+		return Result{}, err
+	}
+
+	if _, err = tx.Exec(ctx, "DELETE FROM accounts WHERE id = $1", sourceAccountId); err != nil {
+		return Result{}, err
+	}
+
+	return res, nil
+}
+
+// moveApplicationAccounts moves the access the source account granted to applications the target account is not known to.
+// The account_sub is moved as well, so that applications keep seeing the same user.
+func moveApplicationAccounts(ctx context.Context, tx pgx.Tx, targetAccountId, sourceAccountId uuid.UUID) error {
+	const sql = `
+SELECT src.application_id, src.account_sub
+FROM application_account_subs src
+WHERE src.account_id = $2
+AND NOT EXISTS(
+	SELECT TRUE
+	FROM application_account_subs tgt
+	WHERE tgt.application_id = src.application_id
+	AND tgt.account_id = $1
+)
+`
+	rows, err := tx.Query(ctx, sql, targetAccountId, sourceAccountId)
+	if err != nil {
+		return err
+	}
+
+	subs, err := pgx.CollectRows(rows, pgx.RowToStructByPos[applicationSub])
+	if err != nil || len(subs) < 1 {
+		return err
+	}
+
+	sqls := []string{
+		`
+INSERT INTO application_accounts
+(application_id, account_id, creation_time)
+SELECT application_id, $1, creation_time
+FROM application_accounts
+WHERE account_id = $2
+AND application_id = $3
+`,
+		`
+INSERT INTO application_client_accounts
+(application_client_id, account_id, application_id, approval_status, approval_request_message, authorized_scopes)
+SELECT application_client_id, $1, application_id, approval_status, approval_request_message, authorized_scopes
+FROM application_client_accounts
+WHERE account_id = $2
+AND application_id = $3
+`,
+		`
+UPDATE application_client_authorizations
+SET account_id = $1
+WHERE account_id = $2
+AND application_client_id IN (
+	SELECT id
+	FROM application_clients
+	WHERE application_id = $3
+)
+`,
+	}
+
+	for _, sub := range subs {
+		// account_subs are never reused: the source account keeps a new, never published account_sub
+		if _, err = tx.Exec(ctx, "UPDATE application_account_subs SET account_sub = GEN_RANDOM_UUID() WHERE application_id = $1 AND account_id = $2", sub.ApplicationId, sourceAccountId); err != nil {
+			return err
+		}
+
+		if _, err = tx.Exec(ctx, "INSERT INTO application_account_subs (application_id, account_id, account_sub) VALUES ($1, $2, $3)", sub.ApplicationId, targetAccountId, sub.AccountSub); err != nil {
+			return err
+		}
+
+		for _, sql := range sqls {
+			if _, err = tx.Exec(ctx, sql, targetAccountId, sourceAccountId, sub.ApplicationId); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func collectUUIDs(ctx context.Context, tx pgx.Tx, sql string, args ...any) ([]uuid.UUID, error) {
+	rows, err := tx.Query(ctx, sql, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	return pgx.CollectRows(rows, pgx.RowTo[uuid.UUID])
+}
@@ -0,0 +1,120 @@
+package accountmerge
+
+import (
+	"context"
+	"github.com/gofrs/uuid/v5"
+	"github.com/gw2auth/gw2auth.com-api/internal/test"
+	"github.com/gw2auth/gw2auth.com-api/service"
+	"github.com/gw2auth/gw2auth.com-api/service/gw2"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestMergeAll(t *testing.T) {
+	test.RunAll(t, dbScope, map[string]map[string]test.Fn{
+		"Merge": {
+			"simple":    testMergeSimple,
+			"conflicts": testMergeConflicts,
+			"self":      testMergeSelf,
+		},
+	})
+}
+
+func testMergeSimple(t *testing.T, pool *pgxpool.Pool, conv *service.SessionJwtConverter, truncateTablesFn func() error) {
+	targetId, sourceId := test.NewUUID(t), test.NewUUID(t)
+	test.CreateAccountAndFederation(t, pool, targetId, "google", "GoogleA", time.Now())
+	test.CreateAccountAndFederation(t, pool, sourceId, "github", "GitHubA", time.Now())
+	test.CreateSession(t, pool, "sourceSession", "github", "GitHubA", time.Now(), time.Now().Add(time.Hour), nil)
+
+	gw2AccountId := test.NewUUID(t)
+	test.CreateGw2Account(t, pool, sourceId, gw2AccountId, "Felix.9127", "Felix")
+	test.CreateGw2ApiToken(t, pool, sourceId, gw2AccountId, "token", []gw2.Permission{gw2.PermissionAccount})
+	test.CreateGw2AccountVerification(t, pool, sourceId, gw2AccountId)
+
+	ownedApplicationId := test.NewUUID(t)
+	test.CreateApplication(t, pool, sourceId, ownedApplicationId, "Owned")
+
+	otherAccountId, usedApplicationId, accountSub := test.NewUUID(t), test.NewUUID(t), test.NewUUID(t)
+	test.CreateAccount(t, pool, otherAccountId, time.Now())
+	test.CreateApplication(t, pool, otherAccountId, usedApplicationId, "Used")
+	test.CreateApplicationAccount(t, pool, usedApplicationId, sourceId, accountSub)
+
+	res, err := merge(pool, targetId, sourceId)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	assert.Empty(t, res.Gw2AccountConflicts)
+	assert.Empty(t, res.ApplicationConflicts)
+
+	test.MustNotExist(t, pool, `SELECT TRUE FROM accounts WHERE id = $1`, sourceId)
+	test.MustExist(t, pool, `SELECT TRUE FROM account_federations WHERE account_id = $1 AND issuer = 'github'`, targetId)
+	test.MustNotExist(t, pool, `SELECT TRUE FROM account_federation_sessions WHERE id = 'sourceSession'`)
+	test.MustExist(t, pool, `SELECT TRUE FROM gw2_account_api_tokens WHERE account_id = $1 AND gw2_account_id = $2 AND gw2_api_token = 'token'`, targetId, gw2AccountId)
+	test.MustExist(t, pool, `SELECT TRUE FROM gw2_account_verifications WHERE account_id = $1 AND gw2_account_id = $2`, targetId, gw2AccountId)
+	test.MustExist(t, pool, `SELECT TRUE FROM applications WHERE id = $1 AND account_id = $2`, ownedApplicationId, targetId)
+	test.MustExist(t, pool, `SELECT TRUE FROM application_accounts WHERE application_id = $1 AND account_id = $2`, usedApplicationId, targetId)
+
+	// the application keeps seeing the same user
+	test.MustExist(t, pool, `SELECT TRUE FROM application_account_subs WHERE application_id = $1 AND account_id = $2 AND account_sub = $3`, usedApplicationId, targetId, accountSub)
+	test.MustNotExist(t, pool, `SELECT TRUE FROM application_account_subs WHERE application_id = $1 AND account_id = $2 AND account_sub = $3`, usedApplicationId, sourceId, accountSub)
+}
+
+func testMergeConflicts(t *testing.T, pool *pgxpool.Pool, conv *service.SessionJwtConverter, truncateTablesFn func() error) {
+	targetId, sourceId := test.NewUUID(t), test.NewUUID(t)
+	test.CreateAccountAndFederation(t, pool, targetId, "google", "GoogleA", time.Now())
+	test.CreateAccountAndFederation(t, pool, sourceId, "github", "GitHubA", time.Now())
+
+	// the most recently valid token is kept, the display name of the target account is kept
+	gw2AccountId := test.NewUUID(t)
+	test.CreateGw2Account(t, pool, targetId, gw2AccountId, "Felix.9127", "Target")
+	test.CreateGw2ApiToken(t, pool, targetId, gw2AccountId, "targetToken", []gw2.Permission{gw2.PermissionAccount})
+	test.MustExec(t, pool, `UPDATE gw2_account_api_tokens SET last_valid_time = $2 WHERE account_id = $1`, targetId, time.Now().Add(-time.Hour))
+	test.CreateGw2Account(t, pool, sourceId, gw2AccountId, "Felix.9127", "Source")
+	test.CreateGw2ApiToken(t, pool, sourceId, gw2AccountId, "sourceToken", []gw2.Permission{gw2.PermissionAccount})
+
+	otherAccountId, applicationId := test.NewUUID(t), test.NewUUID(t)
+	test.CreateAccount(t, pool, otherAccountId, time.Now())
+	test.CreateApplication(t, pool, otherAccountId, applicationId, "Used")
+	targetSub, sourceSub := test.NewUUID(t), test.NewUUID(t)
+	test.CreateApplicationAccount(t, pool, applicationId, targetId, targetSub)
+	test.CreateApplicationAccount(t, pool, applicationId, sourceId, sourceSub)
+
+	res, err := merge(pool, targetId, sourceId)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	assert.Equal(t, []uuid.UUID{gw2AccountId}, res.Gw2AccountConflicts)
+	assert.Equal(t, []uuid.UUID{applicationId}, res.ApplicationConflicts)
+
+	test.MustExist(t, pool, `SELECT TRUE FROM gw2_accounts WHERE account_id = $1 AND gw2_account_id = $2 AND display_name = 'Target'`, targetId, gw2AccountId)
+	test.MustExist(t, pool, `SELECT TRUE FROM gw2_account_api_tokens WHERE account_id = $1 AND gw2_account_id = $2 AND gw2_api_token = 'sourceToken'`, targetId, gw2AccountId)
+	test.MustExist(t, pool, `SELECT TRUE FROM application_account_subs WHERE application_id = $1 AND account_id = $2 AND account_sub = $3`, applicationId, targetId, targetSub)
+	// account_subs are kept even after the account is deleted
+	test.MustExist(t, pool, `SELECT TRUE FROM application_account_subs WHERE application_id = $1 AND account_id = $2 AND account_sub = $3`, applicationId, sourceId, sourceSub)
+}
+
+func testMergeSelf(t *testing.T, pool *pgxpool.Pool, conv *service.SessionJwtConverter, truncateTablesFn func() error) {
+	accountId := test.NewUUID(t)
+	test.CreateAccountAndFederation(t, pool, accountId, "google", "GoogleA", time.Now())
+
+	_, err := merge(pool, accountId, accountId)
+	assert.Error(t, err)
+	test.MustExist(t, pool, `SELECT TRUE FROM accounts WHERE id = $1`, accountId)
+}
+
+func merge(pool *pgxpool.Pool, targetAccountId, sourceAccountId uuid.UUID) (Result, error) {
+	ctx := context.Background()
+	var res Result
+	err := pgx.BeginTxFunc(ctx, pool, pgx.TxOptions{}, func(tx pgx.Tx) error {
+		var err error
+		res, err = Merge(ctx, tx, targetAccountId, sourceAccountId)
+		return err
+	})
+
+	return res, err
+}
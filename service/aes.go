@@ -7,41 +7,127 @@ import (
 	"crypto/rand"
 	"encoding/binary"
 	"errors"
+	"fmt"
+	"io"
 )
 
 const keySize = 256 / 8
 const ivSize = 16
 
+// Blobs encrypted by KeyAndIv.Encrypt are prefixed with a version and never have a length which is a multiple of the aes block size.
+// Legacy blobs were encrypted using AES-CBC with the iv of the key and always have such a length,
+// so the format is decided by the length alone and a blob is never decrypted in more than one way.
+const (
+	encryptionVersionAESGCM byte = 1
+	// encryptionVersionAESGCMPadded is followed by a single zero byte to move the length off the block size
+	encryptionVersionAESGCMPadded byte = 2
+)
+
+// KeyAndIv is the per-session key carried in the session jwt.
+// The iv is only used to decrypt blobs written before the switch to AES-GCM.
 type KeyAndIv struct {
 	key []byte
 	iv  []byte
 }
 
+// Encrypt encrypts b using AES-GCM with a random nonce; the result is header || nonce || ciphertext
 func (k KeyAndIv) Encrypt(b []byte) ([]byte, error) {
+	aead, err := k.newGCM()
+	if err != nil {
+		return nil, err
+	}
+
+	header := []byte{encryptionVersionAESGCM}
+	if (len(header)+aead.NonceSize()+len(b)+aead.Overhead())%aes.BlockSize == 0 {
+		header = []byte{encryptionVersionAESGCMPadded, 0}
+	}
+
+	out := make([]byte, len(header)+aead.NonceSize(), len(header)+aead.NonceSize()+len(b)+aead.Overhead())
+	copy(out, header)
+
+	nonce := out[len(header):]
+	if _, err = rand.Read(nonce); err != nil {
+		return nil, err
+	}
+
+	return aead.Seal(out, nonce, b, out[:len(header)]), nil
+}
+
+// Decrypt decrypts blobs written by Encrypt as well as legacy AES-CBC blobs
+func (k KeyAndIv) Decrypt(b []byte) ([]byte, error) {
+	if len(b)%aes.BlockSize == 0 {
+		return k.decryptCBC(b)
+	}
+
+	return k.decryptGCM(b)
+}
+
+func (k KeyAndIv) newGCM() (cipher.AEAD, error) {
 	block, err := aes.NewCipher(k.key)
 	if err != nil {
 		return nil, err
 	}
 
-	ecb := cipher.NewCBCEncrypter(block, k.iv)
+	return cipher.NewGCM(block)
+}
+
+func (k KeyAndIv) decryptGCM(b []byte) ([]byte, error) {
+	aead, err := k.newGCM()
+	if err != nil {
+		return nil, err
+	}
+
+	var headerLen int
+	switch b[0] {
+	case encryptionVersionAESGCM:
+		headerLen = 1
+
+	case encryptionVersionAESGCMPadded:
+		headerLen = 2
+
+	default:
+		return nil, fmt.Errorf("unsupported encryption version %d", b[0])
+	}
+
+	if len(b) < headerLen+aead.NonceSize()+aead.Overhead() {
+		return nil, errors.New("ciphertext too short")
+	}
+
+	header, nonce, ciphertext := b[:headerLen], b[headerLen:headerLen+aead.NonceSize()], b[headerLen+aead.NonceSize():]
+	return aead.Open(nil, nonce, ciphertext, header)
+}
+
+func (k KeyAndIv) encryptCBC(b []byte) ([]byte, error) {
+	block, err := aes.NewCipher(k.key)
+	if err != nil {
+		return nil, err
+	}
+
+	cbc := cipher.NewCBCEncrypter(block, k.iv)
 	b = pkcs5Padding(b, block.BlockSize())
 	encrypted := make([]byte, len(b))
-	ecb.CryptBlocks(encrypted, b)
+	cbc.CryptBlocks(encrypted, b)
 
 	return encrypted, nil
 }
 
-func (k KeyAndIv) Decrypt(b []byte) ([]byte, error) {
+func (k KeyAndIv) decryptCBC(b []byte) ([]byte, error) {
 	block, err := aes.NewCipher(k.key)
 	if err != nil {
 		return nil, err
 	}
 
-	ecb := cipher.NewCBCDecrypter(block, k.iv)
+	if len(b) == 0 || len(b)%block.BlockSize() != 0 {
+		return nil, errors.New("ciphertext is not a multiple of the block size")
+	} else if len(k.iv) != block.BlockSize() {
+		return nil, errors.New("unexpected iv length")
+	}
+
+	cbc := cipher.NewCBCDecrypter(block, k.iv)
 	decrypted := make([]byte, len(b))
-	ecb.CryptBlocks(decrypted, b)
+	cbc.CryptBlocks(decrypted, b)
 
-	return pkcs5Trimming(decrypted), nil
+	return pkcs5Trimming(decrypted, block.BlockSize())
 }
 
 func (k KeyAndIv) ToBytes() []byte {
@@ -70,28 +156,55 @@ func NewKeyAndIv() (KeyAndIv, error) {
 func NewKeyAndIvFromBytes(b []byte) (KeyAndIv, error) {
 	buf := bytes.NewBuffer(b)
 
-	keyLen := int(binary.BigEndian.Uint32(buf.Next(4)))
-	key := buf.Next(keyLen)
-	if len(key) != keyLen {
-		return KeyAndIv{}, errors.New("unexpected key length")
+	key, err := readLengthPrefixed(buf)
+	if err != nil {
+		return KeyAndIv{}, fmt.Errorf("unexpected key length: %w", err)
 	}
 
-	ivLen := int(binary.BigEndian.Uint32(buf.Next(4)))
-	iv := buf.Next(ivLen)
-	if len(key) != keyLen {
-		return KeyAndIv{}, errors.New("unexpected iv length")
+	iv, err := readLengthPrefixed(buf)
+	if err != nil {
+		return KeyAndIv{}, fmt.Errorf("unexpected iv length: %w", err)
 	}
 
 	return KeyAndIv{key, iv}, nil
 }
 
+func readLengthPrefixed(buf *bytes.Buffer) ([]byte, error) {
+	lenBytes := buf.Next(4)
+	if len(lenBytes) != 4 {
+		return nil, io.ErrUnexpectedEOF
+	}
+
+	l := int(binary.BigEndian.Uint32(lenBytes))
+	b := buf.Next(l)
+	if len(b) != l {
+		return nil, io.ErrUnexpectedEOF
+	}
+
+	return b, nil
+}
+
 func pkcs5Padding(b []byte, blockSize int) []byte {
 	padding := blockSize - len(b)%blockSize
 	padtext := bytes.Repeat([]byte{byte(padding)}, padding)
 	return append(b, padtext...)
 }
 
-func pkcs5Trimming(b []byte) []byte {
-	padding := b[len(b)-1]
-	return b[:len(b)-int(padding)]
+func pkcs5Trimming(b []byte, blockSize int) ([]byte, error) {
+	if len(b) == 0 {
+		return nil, errors.New("invalid padding")
+	}
+
+	padding := int(b[len(b)-1])
+	if padding == 0 || padding > blockSize || padding > len(b) {
+		return nil, errors.New("invalid padding")
+	}
+
+	for _, v := range b[len(b)-padding:] {
+		if int(v) != padding {
+			return nil, errors.New("invalid padding")
+		}
+	}
+
+	return b[:len(b)-padding], nil
 }
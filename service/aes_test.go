@@ -59,3 +59,165 @@ func testEncryptDecryptSize(t *testing.T, size int) {
 		t.Fatalf("decr and src are not equal")
 	}
 }
+
+func TestDecryptLegacyCBC(t *testing.T) {
+	k, err := NewKeyAndIv()
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+
+	for _, v := range []int{0, 5, 16, 37} {
+		t.Run(fmt.Sprintf("input size %d", v), func(t *testing.T) {
+			src := make([]byte, v)
+			if _, err = rand.Read(src); err != nil {
+				t.Fatalf("failed to create random input: %v", err)
+			}
+
+			encr, err := k.encryptCBC(src)
+			if err != nil {
+				t.Fatalf("encryptCBC failed: %v", err)
+			}
+
+			decr, err := k.Decrypt(encr)
+			if err != nil {
+				t.Fatalf("Decrypt failed: %v", err)
+			}
+
+			if !bytes.Equal(decr, src) {
+				t.Fatalf("decr and src are not equal")
+			}
+		})
+	}
+}
+
+func TestEncryptUsesRandomNonce(t *testing.T) {
+	k, err := NewKeyAndIv()
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+
+	a, err := k.Encrypt([]byte("metadata"))
+	if err != nil {
+		t.Fatalf("Encrypt failed: %v", err)
+	}
+
+	b, err := k.Encrypt([]byte("metadata"))
+	if err != nil {
+		t.Fatalf("Encrypt failed: %v", err)
+	}
+
+	if a[0] != encryptionVersionAESGCM {
+		t.Fatalf("unexpected version byte: %v", a[0])
+	}
+
+	if len(a)%16 == 0 {
+		t.Fatalf("length of the output must not be a multiple of the block size: %d", len(a))
+	}
+
+	if bytes.Equal(a, b) {
+		t.Fatalf("encrypting the same input twice must not produce the same output")
+	}
+}
+
+func TestEncryptNeverBlockSized(t *testing.T) {
+	k, err := NewKeyAndIv()
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+
+	for size := range 64 {
+		encr, err := k.Encrypt(make([]byte, size))
+		if err != nil {
+			t.Fatalf("Encrypt failed: %v", err)
+		}
+
+		// otherwise the output would be decrypted as a legacy AES-CBC blob
+		if len(encr)%16 == 0 {
+			t.Fatalf("length of the output for input size %d must not be a multiple of the block size: %d", size, len(encr))
+		}
+	}
+}
+
+func TestDecryptTampered(t *testing.T) {
+	k, err := NewKeyAndIv()
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+
+	// 3 bytes of input require the padded header
+	encr, err := k.Encrypt([]byte("abc"))
+	if err != nil {
+		t.Fatalf("Encrypt failed: %v", err)
+	}
+
+	encr[len(encr)-1] ^= 1
+	if _, err = k.Decrypt(encr); err == nil {
+		t.Fatal("should fail to decrypt tampered ciphertext; err is nil")
+	}
+
+	other, err := NewKeyAndIv()
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+
+	encr[len(encr)-1] ^= 1
+	if _, err = other.Decrypt(encr); err == nil {
+		t.Fatal("should fail to decrypt with another key; err is nil")
+	}
+}
+
+func TestDecryptInvalidInput(t *testing.T) {
+	k, err := NewKeyAndIv()
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+
+	for name, b := range map[string][]byte{
+		"empty":           {},
+		"not block sized": {1, 2, 3},
+	} {
+		t.Run(name, func(t *testing.T) {
+			if _, err := k.Decrypt(b); err == nil {
+				t.Fatal("should fail to decrypt invalid input; err is nil")
+			}
+		})
+	}
+}
+
+func TestFromBytesTruncated(t *testing.T) {
+	k, err := NewKeyAndIv()
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+
+	b := k.ToBytes()
+	for _, l := range []int{0, 2, 4, 20, len(b) - 1} {
+		if _, err = NewKeyAndIvFromBytes(b[:l]); err == nil {
+			t.Fatalf("should fail to read truncated key of length %d; err is nil", l)
+		}
+	}
+}
+
+func TestPkcs5Trimming(t *testing.T) {
+	for name, b := range map[string][]byte{
+		"empty":             {},
+		"zero padding":      {1, 2, 3, 0},
+		"padding too large": {1, 2, 3, 17},
+		"inconsistent":      {1, 2, 1, 3, 3},
+	} {
+		t.Run(name, func(t *testing.T) {
+			if _, err := pkcs5Trimming(b, 16); err == nil {
+				t.Fatal("should fail to trim invalid padding; err is nil")
+			}
+		})
+	}
+
+	b, err := pkcs5Trimming([]byte{1, 2, 2, 2}, 16)
+	if err != nil {
+		t.Fatalf("pkcs5Trimming failed: %v", err)
+	}
+
+	if !bytes.Equal(b, []byte{1, 2}) {
+		t.Fatalf("unexpected result: %v", b)
+	}
+}
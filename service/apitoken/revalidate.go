@@ -0,0 +1,266 @@
+package apitoken
+
+import (
+	"context"
+	"errors"
+	crdbpgx "github.com/cockroachdb/cockroach-go/v2/crdb/crdbpgxv5"
+	"github.com/gofrs/uuid/v5"
+	"github.com/gw2auth/gw2auth.com-api/service/gw2"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"log/slog"
+	"time"
+)
+
+const (
+	defaultRequestBudget = 200
+	defaultCheckInterval = 6 * time.Hour
+	batchSize            = 10
+	// requestsPerToken is the number of gw2 api requests needed to revalidate a single token
+	requestsPerToken = 2
+)
+
+type RevalidatorOption func(r *Revalidator)
+
+// WithRequestBudget limits the number of gw2 api requests made by a single RunOnce call.
+func WithRequestBudget(budget int) RevalidatorOption {
+	return func(r *Revalidator) {
+		r.requestBudget = budget
+	}
+}
+
+// WithCheckInterval sets the minimum duration between two validity checks of the same token.
+func WithCheckInterval(d time.Duration) RevalidatorOption {
+	return func(r *Revalidator) {
+		r.checkInterval = d
+	}
+}
+
+type token struct {
+	AccountId          uuid.UUID
+	Gw2AccountId       uuid.UUID
+	Gw2ApiToken        string
+	LastValidCheckTime time.Time
+}
+
+type tokenKey struct {
+	lastValidCheckTime time.Time
+	accountId          uuid.UUID
+	gw2AccountId       uuid.UUID
+}
+
+// Revalidator periodically checks stored api tokens against the gw2 api, starting with the
+// tokens which were checked the longest time ago.
+type Revalidator struct {
+	pool          *pgxpool.Pool
+	client        *gw2.ApiClient
+	requestBudget int
+	checkInterval time.Duration
+}
+
+func NewRevalidator(pool *pgxpool.Pool, client *gw2.ApiClient, options ...RevalidatorOption) *Revalidator {
+	r := &Revalidator{
+		pool:          pool,
+		client:        client,
+		requestBudget: defaultRequestBudget,
+		checkInterval: defaultCheckInterval,
+	}
+
+	for _, opt := range options {
+		opt(r)
+	}
+
+	return r
+}
+
+// RunOnce revalidates tokens until either the request budget is used up or no token is due for a check.
+func (r *Revalidator) RunOnce(ctx context.Context) error {
+	remaining := r.requestBudget
+	var after tokenKey
+
+	for remaining >= requestsPerToken {
+		limit := min(batchSize, remaining/requestsPerToken)
+		n, used, err := r.processBatch(ctx, &after, limit)
+		remaining -= used
+
+		if err != nil {
+			return err
+		} else if n < limit {
+			break
+		}
+	}
+
+	slog.InfoContext(
+		ctx,
+		"finished api token revalidation",
+		slog.Int("gw2.api.requests", r.requestBudget-remaining),
+		slog.Int("gw2.api.budget", r.requestBudget),
+	)
+
+	return nil
+}
+
+// revalidation is the result of checking a single token against the gw2 api
+type revalidation struct {
+	token
+	checkTime   time.Time
+	valid       bool
+	permissions []gw2.Permission
+	accountName string
+}
+
+// processBatch calls the gw2 api outside any transaction and only writes the results afterward,
+// so no locks are held while waiting for the gw2 api and transaction retries do not spend the request budget again.
+func (r *Revalidator) processBatch(ctx context.Context, after *tokenKey, limit int) (int, int, error) {
+	tokens, err := r.selectBatch(ctx, *after, limit)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	var used int
+	results := make([]revalidation, 0, len(tokens))
+	for _, tk := range tokens {
+		rv, requests, ok := r.revalidate(ctx, tk)
+		used += requests
+
+		if ok {
+			results = append(results, rv)
+		}
+	}
+
+	if len(results) > 0 {
+		err = crdbpgx.ExecuteTx(ctx, r.pool, pgx.TxOptions{}, func(tx pgx.Tx) error {
+			for _, rv := range results {
+				if err := updateRevalidated(ctx, tx, rv); err != nil {
+					return err
+				}
+			}
+
+			return nil
+		})
+
+		if err != nil {
+			return len(tokens), used, err
+		}
+	}
+
+	if len(tokens) > 0 {
+		last := tokens[len(tokens)-1]
+		*after = tokenKey{last.LastValidCheckTime, last.AccountId, last.Gw2AccountId}
+	}
+
+	return len(tokens), used, nil
+}
+
+func (r *Revalidator) selectBatch(ctx context.Context, after tokenKey, limit int) ([]token, error) {
+	var tokens []token
+	err := crdbpgx.ExecuteTx(ctx, r.pool, pgx.TxOptions{AccessMode: pgx.ReadOnly}, func(tx pgx.Tx) error {
+		const sql = `
+SELECT account_id, gw2_account_id, gw2_api_token, last_valid_check_time
+FROM gw2_account_api_tokens
+WHERE last_valid_check_time <= $1
+AND (last_valid_check_time, account_id, gw2_account_id) > ($2, $3, $4)
+ORDER BY last_valid_check_time, account_id, gw2_account_id
+LIMIT $5
+`
+		rows, err := tx.Query(ctx, sql, time.Now().Add(-r.checkInterval), after.lastValidCheckTime, after.accountId, after.gw2AccountId, limit)
+		if err != nil {
+			return err
+		}
+
+		tokens, err = pgx.CollectRows(rows, func(row pgx.CollectableRow) (token, error) {
+			var tk token
+			return tk, row.Scan(
+				&tk.AccountId,
+				&tk.Gw2AccountId,
+				&tk.Gw2ApiToken,
+				&tk.LastValidCheckTime,
+			)
+		})
+
+		return err
+	})
+
+	return tokens, err
+}
+
+// revalidate checks a single token and returns the number of gw2 api requests made.
+// ok is false if the check was inconclusive and the token should be left as is.
+func (r *Revalidator) revalidate(ctx context.Context, tk token) (revalidation, int, bool) {
+	logAttrs := []any{
+		slog.String("account.id", tk.AccountId.String()),
+		slog.String("gw2account.id", tk.Gw2AccountId.String()),
+	}
+
+	rv := revalidation{token: tk, checkTime: time.Now()}
+	tokenInfo, err := r.client.TokenInfo(ctx, tk.Gw2ApiToken)
+	if err != nil {
+		return rv, 1, handleError(ctx, err, logAttrs)
+	}
+
+	acc, err := r.client.Account(ctx, tk.Gw2ApiToken)
+	if err != nil {
+		return rv, 2, handleError(ctx, err, logAttrs)
+	}
+
+	if acc.Id != tk.Gw2AccountId {
+		slog.WarnContext(ctx, "api token belongs to a different gw2account than stored", logAttrs...)
+		return rv, 2, true
+	}
+
+	rv.valid = true
+	rv.permissions = tokenInfo.Permissions
+	rv.accountName = acc.Name
+
+	return rv, 2, true
+}
+
+// handleError reports whether the token should still be marked as checked
+func handleError(ctx context.Context, err error, logAttrs []any) bool {
+	if errors.Is(err, gw2.ErrInvalidApiToken) {
+		slog.InfoContext(ctx, "api token is no longer valid", logAttrs...)
+		return true
+	}
+
+	// other errors are most likely caused by the gw2 api itself; leave the token to be checked again on the next run
+	slog.WarnContext(ctx, "failed to revalidate api token", append(logAttrs, slog.String("error", err.Error()))...)
+	return false
+}
+
+// updateRevalidated writes the result of the check unless the token was replaced or checked by someone else in the meantime
+func updateRevalidated(ctx context.Context, tx pgx.Tx, rv revalidation) error {
+	if !rv.valid {
+		const sql = `
+UPDATE gw2_account_api_tokens
+SET last_valid_check_time = $4
+WHERE account_id = $1
+AND gw2_account_id = $2
+AND last_valid_check_time = $3
+AND gw2_api_token = $5
+`
+		_, err := tx.Exec(ctx, sql, rv.AccountId, rv.Gw2AccountId, rv.LastValidCheckTime, rv.checkTime, rv.Gw2ApiToken)
+		return err
+	}
+
+	const sqlToken = `
+UPDATE gw2_account_api_tokens
+SET gw2_api_permissions_bit_set = $4, last_valid_time = $5, last_valid_check_time = $5
+WHERE account_id = $1
+AND gw2_account_id = $2
+AND last_valid_check_time = $3
+AND gw2_api_token = $6
+`
+	tag, err := tx.Exec(ctx, sqlToken, rv.AccountId, rv.Gw2AccountId, rv.LastValidCheckTime, gw2.PermissionsToBitSet(rv.permissions), rv.checkTime, rv.Gw2ApiToken)
+	if err != nil || tag.RowsAffected() < 1 {
+		return err
+	}
+
+	const sqlAccount = `
+UPDATE gw2_accounts
+SET gw2_account_name = $3, last_name_check_time = $4
+WHERE account_id = $1
+AND gw2_account_id = $2
+`
+	_, err = tx.Exec(ctx, sqlAccount, rv.AccountId, rv.Gw2AccountId, rv.accountName, rv.checkTime)
+	return err
+}
@@ -0,0 +1,185 @@
+package apitoken
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/gofrs/uuid/v5"
+	"github.com/gw2auth/gw2auth.com-api/internal/test"
+	"github.com/gw2auth/gw2auth.com-api/service"
+	"github.com/gw2auth/gw2auth.com-api/service/gw2"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestRevalidatorAll(t *testing.T) {
+	test.RunAll(t, dbScope, map[string]map[string]test.Fn{
+		"RunOnce": {
+			"valid":          testRevalidatorRunOnceValid,
+			"invalid":        testRevalidatorRunOnceInvalid,
+			"request budget": testRevalidatorRunOnceRequestBudget,
+			"check interval": testRevalidatorRunOnceCheckInterval,
+			"replaced token": testRevalidatorRunOnceReplacedToken,
+		},
+	})
+}
+
+func testRevalidatorRunOnceValid(t *testing.T, pool *pgxpool.Pool, conv *service.SessionJwtConverter, truncateTablesFn func() error) {
+	accountId, gw2AccountId := test.NewUUID(t), test.NewUUID(t)
+	test.CreateAccount(t, pool, accountId, time.Now())
+	test.CreateGw2Account(t, pool, accountId, gw2AccountId, "Felix.9127", "Felix")
+	test.CreateGw2ApiToken(t, pool, accountId, gw2AccountId, "testApiToken", []gw2.Permission{gw2.PermissionAccount})
+	setLastValid(t, pool, accountId, gw2AccountId, time.Now().Add(-48*time.Hour))
+
+	mux := http.NewServeMux()
+	prepareMux(mux, "testApiToken", gw2AccountId, "Felix.1234", gw2.PermissionAccount, gw2.PermissionWallet)
+
+	assert.NoError(t, test.WithGw2ApiClient(mux, func(gw2ApiClient *gw2.ApiClient) error {
+		return NewRevalidator(pool, gw2ApiClient).RunOnce(context.Background())
+	}))
+
+	test.MustExist(
+		t,
+		pool,
+		`SELECT TRUE FROM gw2_account_api_tokens WHERE account_id = $1 AND gw2_account_id = $2 AND last_valid_time = last_valid_check_time AND last_valid_time > NOW() - INTERVAL '1 minute' AND gw2_api_permissions_bit_set = $3`,
+		accountId,
+		gw2AccountId,
+		gw2.PermissionsToBitSet([]gw2.Permission{gw2.PermissionAccount, gw2.PermissionWallet}),
+	)
+	test.MustExist(t, pool, `SELECT TRUE FROM gw2_accounts WHERE account_id = $1 AND gw2_account_id = $2 AND gw2_account_name = 'Felix.1234'`, accountId, gw2AccountId)
+}
+
+func testRevalidatorRunOnceInvalid(t *testing.T, pool *pgxpool.Pool, conv *service.SessionJwtConverter, truncateTablesFn func() error) {
+	accountId, gw2AccountId := test.NewUUID(t), test.NewUUID(t)
+	test.CreateAccount(t, pool, accountId, time.Now())
+	test.CreateGw2Account(t, pool, accountId, gw2AccountId, "Felix.9127", "Felix")
+	test.CreateGw2ApiToken(t, pool, accountId, gw2AccountId, "testApiToken", []gw2.Permission{gw2.PermissionAccount})
+	setLastValid(t, pool, accountId, gw2AccountId, time.Now().Add(-48*time.Hour))
+
+	mux := http.NewServeMux()
+	prepareMux(mux, "otherApiToken", gw2AccountId, "Felix.1234", gw2.PermissionAccount)
+
+	assert.NoError(t, test.WithGw2ApiClient(mux, func(gw2ApiClient *gw2.ApiClient) error {
+		return NewRevalidator(pool, gw2ApiClient).RunOnce(context.Background())
+	}))
+
+	test.MustExist(
+		t,
+		pool,
+		`SELECT TRUE FROM gw2_account_api_tokens WHERE account_id = $1 AND gw2_account_id = $2 AND last_valid_time < NOW() - INTERVAL '1 day' AND last_valid_check_time > NOW() - INTERVAL '1 minute'`,
+		accountId,
+		gw2AccountId,
+	)
+	test.MustExist(t, pool, `SELECT TRUE FROM gw2_accounts WHERE account_id = $1 AND gw2_account_id = $2 AND gw2_account_name = 'Felix.9127'`, accountId, gw2AccountId)
+}
+
+func testRevalidatorRunOnceRequestBudget(t *testing.T, pool *pgxpool.Pool, conv *service.SessionJwtConverter, truncateTablesFn func() error) {
+	accountId := test.NewUUID(t)
+	test.CreateAccount(t, pool, accountId, time.Now())
+
+	for i := 0; i < 3; i++ {
+		gw2AccountId := test.NewUUID(t)
+		test.CreateGw2Account(t, pool, accountId, gw2AccountId, "Felix.9127", "Felix")
+		test.CreateGw2ApiToken(t, pool, accountId, gw2AccountId, "testApiToken", []gw2.Permission{gw2.PermissionAccount})
+		setLastValid(t, pool, accountId, gw2AccountId, time.Now().Add(-48*time.Hour))
+	}
+
+	var requests atomic.Int32
+	mux := http.NewServeMux()
+	prepareMux(mux, "testApiToken", test.NewUUID(t), "Felix.9127", gw2.PermissionAccount)
+	h := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		requests.Add(1)
+		mux.ServeHTTP(w, req)
+	})
+
+	assert.NoError(t, test.WithGw2ApiClient(h, func(gw2ApiClient *gw2.ApiClient) error {
+		return NewRevalidator(pool, gw2ApiClient, WithRequestBudget(5)).RunOnce(context.Background())
+	}))
+
+	assert.Equal(t, int32(4), requests.Load())
+	test.MustExist(t, pool, `SELECT TRUE FROM gw2_account_api_tokens WHERE account_id = $1 AND last_valid_check_time < NOW() - INTERVAL '1 day'`, accountId)
+}
+
+func testRevalidatorRunOnceCheckInterval(t *testing.T, pool *pgxpool.Pool, conv *service.SessionJwtConverter, truncateTablesFn func() error) {
+	accountId, gw2AccountId := test.NewUUID(t), test.NewUUID(t)
+	test.CreateAccount(t, pool, accountId, time.Now())
+	test.CreateGw2Account(t, pool, accountId, gw2AccountId, "Felix.9127", "Felix")
+	test.CreateGw2ApiToken(t, pool, accountId, gw2AccountId, "testApiToken", []gw2.Permission{gw2.PermissionAccount})
+
+	var requests atomic.Int32
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", func(w http.ResponseWriter, req *http.Request) {
+		requests.Add(1)
+		w.WriteHeader(http.StatusUnauthorized)
+	})
+
+	assert.NoError(t, test.WithGw2ApiClient(mux, func(gw2ApiClient *gw2.ApiClient) error {
+		return NewRevalidator(pool, gw2ApiClient, WithCheckInterval(time.Hour)).RunOnce(context.Background())
+	}))
+
+	assert.Equal(t, int32(0), requests.Load())
+}
+
+func testRevalidatorRunOnceReplacedToken(t *testing.T, pool *pgxpool.Pool, conv *service.SessionJwtConverter, truncateTablesFn func() error) {
+	accountId, gw2AccountId := test.NewUUID(t), test.NewUUID(t)
+	test.CreateAccount(t, pool, accountId, time.Now())
+	test.CreateGw2Account(t, pool, accountId, gw2AccountId, "Felix.9127", "Felix")
+	test.CreateGw2ApiToken(t, pool, accountId, gw2AccountId, "testApiToken", []gw2.Permission{gw2.PermissionAccount})
+	setLastValid(t, pool, accountId, gw2AccountId, time.Now().Add(-48*time.Hour))
+
+	mux := http.NewServeMux()
+	prepareMux(mux, "testApiToken", gw2AccountId, "Felix.1234", gw2.PermissionAccount, gw2.PermissionWallet)
+
+	// the token is replaced while it is being checked
+	handler := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Path == "/v2/account" {
+			test.MustExec(t, pool, `UPDATE gw2_account_api_tokens SET gw2_api_token = 'otherApiToken' WHERE account_id = $1 AND gw2_account_id = $2`, accountId, gw2AccountId)
+		}
+
+		mux.ServeHTTP(w, req)
+	})
+
+	assert.NoError(t, test.WithGw2ApiClient(handler, func(gw2ApiClient *gw2.ApiClient) error {
+		return NewRevalidator(pool, gw2ApiClient).RunOnce(context.Background())
+	}))
+
+	test.MustExist(t, pool, `SELECT TRUE FROM gw2_account_api_tokens WHERE account_id = $1 AND gw2_account_id = $2 AND last_valid_check_time < NOW() - INTERVAL '1 hour'`, accountId, gw2AccountId)
+	test.MustExist(t, pool, `SELECT TRUE FROM gw2_accounts WHERE account_id = $1 AND gw2_account_id = $2 AND gw2_account_name = 'Felix.9127'`, accountId, gw2AccountId)
+}
+
+func setLastValid(t testing.TB, pool *pgxpool.Pool, accountId, gw2AccountId uuid.UUID, v time.Time) {
+	test.MustExec(
+		t,
+		pool,
+		`UPDATE gw2_account_api_tokens SET last_valid_time = $3, last_valid_check_time = $3 WHERE account_id = $1 AND gw2_account_id = $2`,
+		accountId,
+		gw2AccountId,
+		v,
+	)
+}
+
+func prepareMux(mux *http.ServeMux, token string, gw2AccountId uuid.UUID, name string, perms ...gw2.Permission) {
+	authorized := func(w http.ResponseWriter, req *http.Request) bool {
+		if req.URL.Query().Get("access_token") != token {
+			w.WriteHeader(http.StatusUnauthorized)
+			return false
+		}
+
+		return true
+	}
+
+	mux.HandleFunc("/v2/tokeninfo", func(w http.ResponseWriter, req *http.Request) {
+		if authorized(w, req) {
+			_ = json.NewEncoder(w).Encode(gw2.TokenInfo{Name: "TokenName", Permissions: perms})
+		}
+	})
+
+	mux.HandleFunc("/v2/account", func(w http.ResponseWriter, req *http.Request) {
+		if authorized(w, req) {
+			_ = json.NewEncoder(w).Encode(gw2.Account{Id: gw2AccountId, Name: name})
+		}
+	})
+}
@@ -1,8 +1,8 @@
 package service
 
 import (
-	"bytes"
 	"crypto/rand"
+	"crypto/subtle"
 	"encoding/base64"
 	"errors"
 	"fmt"
@@ -16,6 +16,83 @@ var (
 	defaultArgon2IdKey = Argon2IdKey{}
 )
 
+// Argon2Policy holds the parameters used for new hashes.
+// Hashes created using other parameters still verify, but should be replaced (see VerifyAndNeedsRehash).
+type Argon2Policy struct {
+	SaltLength  int
+	KeyLength   uint32
+	Memory      uint32 // KiB
+	Iterations  uint32
+	Parallelism uint8
+}
+
+// SpringArgon2Policy matches Argon2PasswordEncoder.defaultsForSpringSecurity_v5_8
+var SpringArgon2Policy = Argon2Policy{
+	SaltLength:  16,
+	KeyLength:   32,
+	Memory:      16384,
+	Iterations:  2,
+	Parallelism: 1,
+}
+
+// DefaultArgon2Policy is used for new hashes and to decide whether stored hashes are outdated.
+// It matches the parameters of the existing hashes; changing it upgrades every stored secret on its next successful verification.
+var DefaultArgon2Policy = SpringArgon2Policy
+
+func (p Argon2Policy) NewKey(secret []byte) (Argon2IdKey, error) {
+	salt, err := GenerateRandomBytes(p.SaltLength)
+	if err != nil {
+		return defaultArgon2IdKey, err
+	}
+
+	k := Argon2IdKey{
+		p: Argon2Params{
+			version: argon2.Version,
+			salt:    salt,
+			time:    p.Iterations,
+			memory:  p.Memory,
+			threads: p.Parallelism,
+			keyLen:  p.KeyLength,
+		},
+	}
+
+	if k.hash, err = k.p.IDKey(secret); err != nil {
+		return defaultArgon2IdKey, err
+	}
+
+	return k, nil
+}
+
+func (p Argon2Policy) Encode(secret []byte) (string, error) {
+	k, err := p.NewKey(secret)
+	if err != nil {
+		return "", err
+	}
+
+	return k.String(), nil
+}
+
+// NeedsRehash reports whether k was created using parameters other than the ones of this policy
+func (p Argon2Policy) NeedsRehash(k Argon2IdKey) bool {
+	return k.p.version != argon2.Version ||
+		len(k.p.salt) != p.SaltLength ||
+		k.p.keyLen != p.KeyLength ||
+		k.p.memory != p.Memory ||
+		k.p.time != p.Iterations ||
+		k.p.threads != p.Parallelism
+}
+
+// VerifyAndNeedsRehash verifies secret against the encoded hash.
+// needsRehash is only true for valid secrets; the caller should then replace the stored hash using Encode.
+func (p Argon2Policy) VerifyAndNeedsRehash(encoded string, secret []byte) (valid bool, needsRehash bool) {
+	k, err := NewArgon2IdKeyFromString(encoded)
+	if err != nil || !k.Verify(secret) {
+		return false, false
+	}
+
+	return true, p.NeedsRehash(k)
+}
+
 type Argon2Params struct {
 	version int
 	salt    []byte
@@ -39,30 +116,7 @@ type Argon2IdKey struct {
 }
 
 func NewArgon2IdKeyFromSecret(secret []byte) (Argon2IdKey, error) {
-	// Argon2PasswordEncoder defaultsForSpringSecurity_v5_8
-	const saltLength, hashLength, parallelism, memoryKB, iterations = 16, 32, 1, 16384, 2
-
-	salt, err := GenerateRandomBytes(saltLength)
-	if err != nil {
-		return defaultArgon2IdKey, err
-	}
-
-	k := Argon2IdKey{
-		p: Argon2Params{
-			version: argon2.Version,
-			salt:    salt,
-			time:    iterations,
-			memory:  memoryKB,
-			threads: parallelism,
-			keyLen:  hashLength,
-		},
-	}
-
-	if k.hash, err = k.p.IDKey(secret); err != nil {
-		return defaultArgon2IdKey, err
-	}
-
-	return k, nil
+	return DefaultArgon2Policy.NewKey(secret)
 }
 
 func NewArgon2IdKeyFromString(encoded string) (Argon2IdKey, error) {
@@ -144,25 +198,16 @@ func (k Argon2IdKey) Verify(secret []byte) bool {
 		return false
 	}
 
-	return bytes.Equal(k.hash, hash)
+	return subtle.ConstantTimeCompare(k.hash, hash) == 1
 }
 
 func EncodeArgon2id(secret []byte) (string, error) {
-	k, err := NewArgon2IdKeyFromSecret(secret)
-	if err != nil {
-		return "", err
-	}
-
-	return k.String(), err
+	return DefaultArgon2Policy.Encode(secret)
 }
 
 func VerifyArgon2id(encoded string, secret []byte) bool {
-	k, err := NewArgon2IdKeyFromString(encoded)
-	if err != nil {
-		return false
-	}
-
-	return k.Verify(secret)
+	valid, _ := DefaultArgon2Policy.VerifyAndNeedsRehash(encoded, secret)
+	return valid
 }
 
 func GenerateRandomBytes(len int) ([]byte, error) {
@@ -36,3 +36,65 @@ func TestArgon2IdKey_Verify(t *testing.T) {
 		t.FailNow()
 	}
 }
+
+func TestArgon2Policy_VerifyAndNeedsRehash(t *testing.T) {
+	outdated := DefaultArgon2Policy
+	outdated.Memory /= 2
+
+	encoded, err := outdated.Encode([]byte("hello world"))
+	if err != nil {
+		t.Log("err must be nil", err)
+		t.FailNow()
+	}
+
+	if valid, needsRehash := outdated.VerifyAndNeedsRehash(encoded, []byte("hello world")); !valid || needsRehash {
+		t.Logf("expected valid=true needsRehash=false, got valid=%v needsRehash=%v", valid, needsRehash)
+		t.FailNow()
+	}
+
+	if valid, needsRehash := DefaultArgon2Policy.VerifyAndNeedsRehash(encoded, []byte("hello world")); !valid || !needsRehash {
+		t.Logf("expected valid=true needsRehash=true, got valid=%v needsRehash=%v", valid, needsRehash)
+		t.FailNow()
+	}
+
+	if valid, needsRehash := DefaultArgon2Policy.VerifyAndNeedsRehash(encoded, []byte("hello world!")); valid || needsRehash {
+		t.Logf("expected valid=false needsRehash=false, got valid=%v needsRehash=%v", valid, needsRehash)
+		t.FailNow()
+	}
+
+	if valid, _ := DefaultArgon2Policy.VerifyAndNeedsRehash("$argon2id$invalid", []byte("hello world")); valid {
+		t.Log("invalid encoded hash must not verify")
+		t.FailNow()
+	}
+}
+
+func TestArgon2Policy_NeedsRehash(t *testing.T) {
+	k, err := DefaultArgon2Policy.NewKey([]byte("hello world"))
+	if err != nil {
+		t.Log("err must be nil", err)
+		t.FailNow()
+	}
+
+	if DefaultArgon2Policy.NeedsRehash(k) {
+		t.Log("key created by the policy must not need a rehash")
+		t.FailNow()
+	}
+
+	for name, fn := range map[string]func(p *Argon2Policy){
+		"memory":      func(p *Argon2Policy) { p.Memory *= 2 },
+		"iterations":  func(p *Argon2Policy) { p.Iterations++ },
+		"parallelism": func(p *Argon2Policy) { p.Parallelism++ },
+		"key length":  func(p *Argon2Policy) { p.KeyLength = 64 },
+		"salt length": func(p *Argon2Policy) { p.SaltLength = 32 },
+	} {
+		t.Run(name, func(t *testing.T) {
+			p := DefaultArgon2Policy
+			fn(&p)
+
+			if !p.NeedsRehash(k) {
+				t.Log("changed policy must require a rehash")
+				t.FailNow()
+			}
+		})
+	}
+}
@@ -0,0 +1,91 @@
+package auth
+
+import (
+	"context"
+	"fmt"
+	"github.com/gofrs/uuid/v5"
+	"github.com/jackc/pgx/v5"
+	"time"
+)
+
+type AnomalyOutcome int
+
+const (
+	AnomalyOutcomePlausible AnomalyOutcome = iota
+	// AnomalyOutcomeSuspicious keeps the session, but requires re-authentication for sensitive operations
+	AnomalyOutcomeSuspicious
+)
+
+// AnomalyPolicy decides whether the location of a request is plausible compared to the previous request of the same session.
+type AnomalyPolicy struct {
+	// MaxDistanceKm is the maximum distance between two requests, regardless of the time passed
+	MaxDistanceKm float64
+	// AlwaysAllowedKm is the distance which is allowed at any time
+	AlwaysAllowedKm float64
+	// MaxKmPerDay is the travel speed allowed for distances between AlwaysAllowedKm and MaxDistanceKm
+	MaxKmPerDay float64
+}
+
+func DefaultAnomalyPolicy() AnomalyPolicy {
+	return AnomalyPolicy{
+		MaxDistanceKm:   1000,
+		AlwaysAllowedKm: 30,
+		MaxKmPerDay:     333.3,
+	}
+}
+
+// TrustedRegion is a circle in which requests of an account are always plausible
+type TrustedRegion struct {
+	Lat      float64
+	Lng      float64
+	RadiusKm float64
+}
+
+func (r TrustedRegion) Contains(m SessionMetadata) bool {
+	return distance(r.Lat, r.Lng, m.Lat, m.Lng) <= r.RadiusKm
+}
+
+func (p AnomalyPolicy) Evaluate(orig, current SessionMetadata, passed time.Duration, trustedRegions []TrustedRegion) AnomalyOutcome {
+	if orig.IsZero() {
+		return AnomalyOutcomePlausible
+	}
+
+	for _, r := range trustedRegions {
+		if r.Contains(current) {
+			return AnomalyOutcomePlausible
+		}
+	}
+
+	travelledKm := distance(orig.Lat, orig.Lng, current.Lat, current.Lng)
+	if travelledKm > p.MaxDistanceKm {
+		return AnomalyOutcomeSuspicious
+	}
+
+	if travelledKm <= p.AlwaysAllowedKm {
+		return AnomalyOutcomePlausible
+	}
+
+	if travelledKm <= (p.MaxKmPerDay * (passed.Seconds() / 86400.0)) {
+		return AnomalyOutcomePlausible
+	}
+
+	return AnomalyOutcomeSuspicious
+}
+
+func LoadTrustedRegions(ctx context.Context, conn PgxConn, accountId uuid.UUID) ([]TrustedRegion, error) {
+	const sql = `
+SELECT lat, lng, radius_km
+FROM account_trusted_regions
+WHERE account_id = $1
+`
+
+	rows, err := conn.Query(ctx, sql, accountId)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load trusted regions: %w", err)
+	}
+
+	return pgx.CollectRows(rows, func(row pgx.CollectableRow) (TrustedRegion, error) {
+		var r TrustedRegion
+		return r, row.Scan(&r.Lat, &r.Lng, &r.RadiusKm)
+	})
+}
@@ -0,0 +1,82 @@
+package auth
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestAnomalyPolicy_Evaluate(t *testing.T) {
+	berlin := SessionMetadata{Lat: 52.5162778, Lng: 13.3755154}
+	potsdam := SessionMetadata{Lat: 52.3906, Lng: 13.0645}
+	hamburg := SessionMetadata{Lat: 53.5511, Lng: 9.9937}
+	newYork := SessionMetadata{Lat: 40.7128, Lng: -74.0060}
+
+	tests := map[string]struct {
+		policy  AnomalyPolicy
+		orig    SessionMetadata
+		current SessionMetadata
+		passed  time.Duration
+		trusted []TrustedRegion
+		outcome AnomalyOutcome
+	}{
+		"no previous location": {
+			policy:  DefaultAnomalyPolicy(),
+			current: newYork,
+			outcome: AnomalyOutcomePlausible,
+		},
+		"always allowed distance": {
+			policy:  DefaultAnomalyPolicy(),
+			orig:    berlin,
+			current: potsdam,
+			outcome: AnomalyOutcomePlausible,
+		},
+		"too fast": {
+			policy:  DefaultAnomalyPolicy(),
+			orig:    berlin,
+			current: hamburg,
+			passed:  time.Hour,
+			outcome: AnomalyOutcomeSuspicious,
+		},
+		"fast enough": {
+			policy:  DefaultAnomalyPolicy(),
+			orig:    berlin,
+			current: hamburg,
+			passed:  24 * time.Hour,
+			outcome: AnomalyOutcomePlausible,
+		},
+		"too far": {
+			policy:  DefaultAnomalyPolicy(),
+			orig:    berlin,
+			current: newYork,
+			passed:  365 * 24 * time.Hour,
+			outcome: AnomalyOutcomeSuspicious,
+		},
+		"configured distance": {
+			policy:  AnomalyPolicy{MaxDistanceKm: 10000, AlwaysAllowedKm: 10000},
+			orig:    berlin,
+			current: newYork,
+			outcome: AnomalyOutcomePlausible,
+		},
+		"trusted region": {
+			policy:  DefaultAnomalyPolicy(),
+			orig:    berlin,
+			current: newYork,
+			trusted: []TrustedRegion{{Lat: 40.7, Lng: -74, RadiusKm: 50}},
+			outcome: AnomalyOutcomePlausible,
+		},
+		"outside of trusted region": {
+			policy:  DefaultAnomalyPolicy(),
+			orig:    berlin,
+			current: newYork,
+			trusted: []TrustedRegion{{Lat: 52.52, Lng: 13.405, RadiusKm: 50}},
+			outcome: AnomalyOutcomeSuspicious,
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			assert.Equal(t, tc.outcome, tc.policy.Evaluate(tc.orig, tc.current, tc.passed, tc.trusted))
+		})
+	}
+}
@@ -12,6 +12,10 @@ const (
 	PermissionRead         Permission = "read"
 	PermissionClientCreate Permission = "client:create"
 	PermissionClientModify Permission = "client:modify"
+	PermissionClientSecret Permission = "client:secret"
+	PermissionClientDelete Permission = "client:delete"
+	PermissionUserApprove  Permission = "user:approve"
+	PermissionAPIKeyRotate Permission = "apikey:rotate"
 )
 
 type ApiKey struct {
@@ -24,7 +28,15 @@ type ApiKey struct {
 }
 
 func FilterPermissions(perms []Permission) []Permission {
-	s := util.NewSet(PermissionRead, PermissionClientCreate, PermissionClientModify)
+	s := util.NewSet(
+		PermissionRead,
+		PermissionClientCreate,
+		PermissionClientModify,
+		PermissionClientSecret,
+		PermissionClientDelete,
+		PermissionUserApprove,
+		PermissionAPIKeyRotate,
+	)
 	r := make([]Permission, 0, len(perms))
 
 	for _, v := range perms {
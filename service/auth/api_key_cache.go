@@ -0,0 +1,257 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"github.com/gofrs/uuid/v5"
+	"sync"
+	"time"
+)
+
+type apiKeyCacheEntry struct {
+	apiKey     ApiKey
+	secretHash []byte
+	expiresAt  time.Time
+}
+
+// APIKeyCache keeps recently verified api keys in memory for a short time so that subsequent requests
+// using the same key do not need to run the (deliberately slow) argon2 verification again.
+//
+// Entries are keyed by the key id and only match if the presented secret has the same HMAC-SHA256 as the verified one.
+// The HMAC key is generated per process, so the cache never holds a value which could be used to verify secrets offline.
+//
+// Keys invalidated through this cache are rejected immediately. Keys deleted by another process are only noticed
+// once the cached entry expires, so the ttl should be kept short.
+//
+// A nil *APIKeyCache is valid and caches nothing.
+type APIKeyCache struct {
+	ttl        time.Duration
+	maxEntries int
+	hmacKey    []byte
+	mu         sync.Mutex
+	entries    map[uuid.UUID]apiKeyCacheEntry
+	lastPrune  time.Time
+}
+
+func NewAPIKeyCache(ttl time.Duration, maxEntries int) *APIKeyCache {
+	hmacKey := make([]byte, sha256.Size)
+	// crypto/rand.Read never returns an error since go 1.24
+	_, _ = rand.Read(hmacKey)
+
+	return &APIKeyCache{
+		ttl:        ttl,
+		maxEntries: maxEntries,
+		hmacKey:    hmacKey,
+		entries:    make(map[uuid.UUID]apiKeyCacheEntry),
+	}
+}
+
+func (c *APIKeyCache) Get(keyId uuid.UUID, secret string) (ApiKey, bool) {
+	if c == nil {
+		return ApiKey{}, false
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	e, ok := c.entries[keyId]
+	if !ok {
+		return ApiKey{}, false
+	}
+
+	now := time.Now()
+	if !now.Before(e.expiresAt) || now.After(e.apiKey.ExpiresAt) {
+		delete(c.entries, keyId)
+		return ApiKey{}, false
+	}
+
+	if !hmac.Equal(e.secretHash, c.hash(secret)) {
+		return ApiKey{}, false
+	}
+
+	return e.apiKey, true
+}
+
+func (c *APIKeyCache) Put(apiKey ApiKey, secret string) {
+	if c == nil {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	if now.Sub(c.lastPrune) >= c.ttl {
+		c.prune(now)
+		c.lastPrune = now
+	}
+
+	if _, ok := c.entries[apiKey.Id]; !ok && len(c.entries) >= c.maxEntries {
+		c.evictOldest()
+	}
+
+	c.entries[apiKey.Id] = apiKeyCacheEntry{
+		apiKey:     apiKey,
+		secretHash: c.hash(secret),
+		expiresAt:  now.Add(c.ttl),
+	}
+}
+
+// Invalidate removes the keys from the cache, e.g. because they were deleted.
+func (c *APIKeyCache) Invalidate(keyIds ...uuid.UUID) {
+	if c == nil {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, keyId := range keyIds {
+		delete(c.entries, keyId)
+	}
+}
+
+// InvalidateApplication removes all keys of the application from the cache.
+func (c *APIKeyCache) InvalidateApplication(applicationId uuid.UUID) {
+	if c == nil {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for keyId, e := range c.entries {
+		if e.apiKey.ApplicationId == applicationId {
+			delete(c.entries, keyId)
+		}
+	}
+}
+
+func (c *APIKeyCache) hash(secret string) []byte {
+	mac := hmac.New(sha256.New, c.hmacKey)
+	mac.Write([]byte(secret))
+	return mac.Sum(nil)
+}
+
+func (c *APIKeyCache) prune(now time.Time) {
+	for keyId, e := range c.entries {
+		if !now.Before(e.expiresAt) {
+			delete(c.entries, keyId)
+		}
+	}
+}
+
+func (c *APIKeyCache) evictOldest() {
+	var oldestId uuid.UUID
+	var oldest time.Time
+	for keyId, e := range c.entries {
+		if oldest.IsZero() || e.expiresAt.Before(oldest) {
+			oldestId, oldest = keyId, e.expiresAt
+		}
+	}
+
+	delete(c.entries, oldestId)
+}
+
+// apiKeyFailuresKey identifies the failures of a key id from a client ip; an empty clientIp counts the failures from all clients
+type apiKeyFailuresKey struct {
+	keyId    uuid.UUID
+	clientIp string
+}
+
+type apiKeyFailures struct {
+	count int
+	since time.Time
+}
+
+func (f apiKeyFailures) exceeds(max int, window time.Duration, now time.Time) bool {
+	return max > 0 && f.count >= max && now.Sub(f.since) < window
+}
+
+// APIKeyFailureLimiter blocks a key id for the rest of the window once maxFailures invalid secrets were presented from any client,
+// or once maxFailuresPerClient invalid secrets were presented from a single client ip.
+// Blocked requests are rejected before the argon2 verification, so guessing secrets does not cost a derivation per attempt.
+// The per client limit blocks a single client well before the per key id ceiling locks out every client using the key;
+// a maxFailuresPerClient of 0 disables it.
+//
+// A nil *APIKeyFailureLimiter is valid and blocks nothing.
+type APIKeyFailureLimiter struct {
+	maxFailures          int
+	maxFailuresPerClient int
+	window               time.Duration
+	mu                   sync.Mutex
+	failures             map[apiKeyFailuresKey]apiKeyFailures
+	lastPrune            time.Time
+}
+
+func NewAPIKeyFailureLimiter(maxFailures, maxFailuresPerClient int, window time.Duration) *APIKeyFailureLimiter {
+	return &APIKeyFailureLimiter{
+		maxFailures:          maxFailures,
+		maxFailuresPerClient: maxFailuresPerClient,
+		window:               window,
+		failures:             make(map[apiKeyFailuresKey]apiKeyFailures),
+	}
+}
+
+func (l *APIKeyFailureLimiter) Blocked(keyId uuid.UUID, clientIp string) bool {
+	if l == nil {
+		return false
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	return l.failures[apiKeyFailuresKey{keyId: keyId}].exceeds(l.maxFailures, l.window, now) ||
+		(clientIp != "" && l.failures[apiKeyFailuresKey{keyId, clientIp}].exceeds(l.maxFailuresPerClient, l.window, now))
+}
+
+func (l *APIKeyFailureLimiter) Fail(keyId uuid.UUID, clientIp string) {
+	if l == nil {
+		return
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	if now.Sub(l.lastPrune) >= l.window {
+		for id, f := range l.failures {
+			if now.Sub(f.since) >= l.window {
+				delete(l.failures, id)
+			}
+		}
+
+		l.lastPrune = now
+	}
+
+	l.fail(apiKeyFailuresKey{keyId: keyId}, now)
+	if clientIp != "" && l.maxFailuresPerClient > 0 {
+		l.fail(apiKeyFailuresKey{keyId, clientIp}, now)
+	}
+}
+
+func (l *APIKeyFailureLimiter) fail(k apiKeyFailuresKey, now time.Time) {
+	f, ok := l.failures[k]
+	if !ok || now.Sub(f.since) >= l.window {
+		f = apiKeyFailures{since: now}
+	}
+
+	f.count++
+	l.failures[k] = f
+}
+
+// Reset forgets the failures of the key id for the client ip after it was used successfully.
+// The failures counted for the key id across all clients are kept until the window passes,
+// so a successful request does not grant a fresh budget to other clients.
+func (l *APIKeyFailureLimiter) Reset(keyId uuid.UUID, clientIp string) {
+	if l == nil || clientIp == "" {
+		return
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	delete(l.failures, apiKeyFailuresKey{keyId, clientIp})
+}
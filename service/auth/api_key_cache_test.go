@@ -0,0 +1,144 @@
+package auth
+
+import (
+	"github.com/gofrs/uuid/v5"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestAPIKeyCache(t *testing.T) {
+	apiKey := ApiKey{
+		Id:            uuid.Must(uuid.NewV4()),
+		ApplicationId: uuid.Must(uuid.NewV4()),
+		ExpiresAt:     time.Now().Add(time.Hour),
+	}
+
+	t.Run("get", func(t *testing.T) {
+		c := NewAPIKeyCache(time.Minute, 10)
+		_, ok := c.Get(apiKey.Id, "secret")
+		assert.False(t, ok)
+
+		c.Put(apiKey, "secret")
+		cached, ok := c.Get(apiKey.Id, "secret")
+		assert.True(t, ok)
+		assert.Equal(t, apiKey, cached)
+
+		_, ok = c.Get(apiKey.Id, "other")
+		assert.False(t, ok)
+	})
+
+	t.Run("ttl", func(t *testing.T) {
+		c := NewAPIKeyCache(time.Millisecond, 10)
+		c.Put(apiKey, "secret")
+		time.Sleep(5 * time.Millisecond)
+
+		_, ok := c.Get(apiKey.Id, "secret")
+		assert.False(t, ok)
+	})
+
+	t.Run("key expired", func(t *testing.T) {
+		expired := apiKey
+		expired.ExpiresAt = time.Now().Add(-time.Second)
+
+		c := NewAPIKeyCache(time.Minute, 10)
+		c.Put(expired, "secret")
+
+		_, ok := c.Get(expired.Id, "secret")
+		assert.False(t, ok)
+	})
+
+	t.Run("bounded", func(t *testing.T) {
+		c := NewAPIKeyCache(time.Minute, 1)
+		c.Put(apiKey, "secret")
+
+		other := apiKey
+		other.Id = uuid.Must(uuid.NewV4())
+		c.Put(other, "secret")
+
+		_, ok := c.Get(apiKey.Id, "secret")
+		assert.False(t, ok)
+		_, ok = c.Get(other.Id, "secret")
+		assert.True(t, ok)
+	})
+
+	t.Run("invalidate", func(t *testing.T) {
+		c := NewAPIKeyCache(time.Minute, 10)
+		c.Put(apiKey, "secret")
+		c.Invalidate(apiKey.Id)
+
+		_, ok := c.Get(apiKey.Id, "secret")
+		assert.False(t, ok)
+
+		c.Put(apiKey, "secret")
+		c.InvalidateApplication(apiKey.ApplicationId)
+
+		_, ok = c.Get(apiKey.Id, "secret")
+		assert.False(t, ok)
+	})
+
+	t.Run("nil", func(t *testing.T) {
+		var c *APIKeyCache
+		c.Put(apiKey, "secret")
+		c.Invalidate(apiKey.Id)
+
+		_, ok := c.Get(apiKey.Id, "secret")
+		assert.False(t, ok)
+	})
+}
+
+func TestAPIKeyFailureLimiter(t *testing.T) {
+	keyId := uuid.Must(uuid.NewV4())
+
+	t.Run("blocks client after max failures per client", func(t *testing.T) {
+		l := NewAPIKeyFailureLimiter(10, 2, time.Minute)
+		l.Fail(keyId, "192.0.2.1")
+		assert.False(t, l.Blocked(keyId, "192.0.2.1"))
+
+		l.Fail(keyId, "192.0.2.1")
+		assert.True(t, l.Blocked(keyId, "192.0.2.1"))
+		assert.False(t, l.Blocked(keyId, "192.0.2.2"))
+		assert.False(t, l.Blocked(uuid.Must(uuid.NewV4()), "192.0.2.1"))
+	})
+
+	t.Run("blocks key id after max failures from any client", func(t *testing.T) {
+		l := NewAPIKeyFailureLimiter(3, 2, time.Minute)
+		l.Fail(keyId, "192.0.2.1")
+		l.Fail(keyId, "192.0.2.2")
+		assert.False(t, l.Blocked(keyId, "192.0.2.3"))
+
+		l.Fail(keyId, "192.0.2.3")
+		assert.True(t, l.Blocked(keyId, "192.0.2.4"))
+		assert.True(t, l.Blocked(keyId, ""))
+		assert.False(t, l.Blocked(uuid.Must(uuid.NewV4()), "192.0.2.4"))
+	})
+
+	t.Run("per client disabled", func(t *testing.T) {
+		l := NewAPIKeyFailureLimiter(2, 0, time.Minute)
+		l.Fail(keyId, "192.0.2.1")
+		assert.False(t, l.Blocked(keyId, "192.0.2.1"))
+
+		l.Fail(keyId, "192.0.2.1")
+		assert.True(t, l.Blocked(keyId, "192.0.2.2"))
+	})
+
+	t.Run("window", func(t *testing.T) {
+		l := NewAPIKeyFailureLimiter(1, 1, time.Millisecond)
+		l.Fail(keyId, "192.0.2.1")
+		time.Sleep(5 * time.Millisecond)
+
+		assert.False(t, l.Blocked(keyId, "192.0.2.1"))
+	})
+
+	t.Run("reset", func(t *testing.T) {
+		l := NewAPIKeyFailureLimiter(3, 2, time.Minute)
+		l.Fail(keyId, "192.0.2.1")
+		l.Reset(keyId, "192.0.2.1")
+		l.Fail(keyId, "192.0.2.1")
+		assert.False(t, l.Blocked(keyId, "192.0.2.1"))
+
+		// the failures across all clients are kept
+		l.Fail(keyId, "192.0.2.2")
+		assert.True(t, l.Blocked(keyId, "192.0.2.1"))
+	})
+}
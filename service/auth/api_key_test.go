@@ -0,0 +1,11 @@
+package auth
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestFilterPermissions(t *testing.T) {
+	perms := FilterPermissions([]Permission{PermissionUserApprove, "unknown", PermissionClientSecret, PermissionUserApprove, PermissionClientDelete, PermissionAPIKeyRotate})
+	assert.Equal(t, []Permission{PermissionUserApprove, PermissionClientSecret, PermissionClientDelete, PermissionAPIKeyRotate}, perms)
+}
@@ -0,0 +1,163 @@
+package auth
+
+import (
+	"context"
+	"github.com/gofrs/uuid/v5"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+type apiKeyUsage struct {
+	lastUsedTime time.Time
+	location     SessionMetadata
+	requestCount int64
+}
+
+func (u apiKeyUsage) merge(other apiKeyUsage) apiKeyUsage {
+	u.requestCount += other.requestCount
+	if other.lastUsedTime.After(u.lastUsedTime) {
+		u.lastUsedTime = other.lastUsedTime
+		if !other.location.IsZero() {
+			u.location = other.location
+		}
+	}
+
+	return u
+}
+
+// APIKeyUsageRecorder collects the usage of api keys in memory and writes it in batches,
+// so that authenticating with an api key does not require a write per request.
+// Once threshold requests were recorded or the oldest pending usage is older than maxAge,
+// FlushEvery is asked to flush early; Record itself never writes.
+// A nil *APIKeyUsageRecorder records nothing.
+type APIKeyUsageRecorder struct {
+	pool      *pgxpool.Pool
+	threshold int64
+	maxAge    time.Duration
+	flushCh   chan struct{}
+	mux       sync.Mutex
+	pending   map[uuid.UUID]apiKeyUsage
+	count     int64
+	since     time.Time
+}
+
+func NewAPIKeyUsageRecorder(pool *pgxpool.Pool, threshold int, maxAge time.Duration) *APIKeyUsageRecorder {
+	return &APIKeyUsageRecorder{
+		pool:      pool,
+		threshold: int64(threshold),
+		maxAge:    maxAge,
+		flushCh:   make(chan struct{}, 1),
+		pending:   make(map[uuid.UUID]apiKeyUsage),
+	}
+}
+
+func (r *APIKeyUsageRecorder) Record(keyId uuid.UUID, t time.Time, location SessionMetadata) {
+	if r == nil {
+		return
+	}
+
+	r.mux.Lock()
+	defer r.mux.Unlock()
+
+	if r.count < 1 {
+		r.since = t
+	}
+
+	r.pending[keyId] = r.pending[keyId].merge(apiKeyUsage{
+		lastUsedTime: t,
+		location:     location.Approximate(),
+		requestCount: 1,
+	})
+	r.count++
+
+	if r.count >= r.threshold || t.Sub(r.since) >= r.maxAge {
+		select {
+		case r.flushCh <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// Flush writes all usage recorded since the last flush. Usage which could not be written is kept for the next flush.
+func (r *APIKeyUsageRecorder) Flush(ctx context.Context) error {
+	if r == nil {
+		return nil
+	}
+
+	r.mux.Lock()
+	pending := r.pending
+	r.pending = make(map[uuid.UUID]apiKeyUsage)
+	r.count = 0
+	r.mux.Unlock()
+
+	if len(pending) < 1 {
+		return nil
+	}
+
+	const sql = `
+UPDATE application_api_keys
+SET
+	last_used_time = GREATEST(COALESCE(last_used_time, $2), $2),
+	last_used_approx_lat = COALESCE($3, last_used_approx_lat),
+	last_used_approx_lng = COALESCE($4, last_used_approx_lng),
+	request_count = request_count + $5
+WHERE id = $1
+`
+	var batch pgx.Batch
+	for keyId, usage := range pending {
+		var lat, lng *float64
+		if !usage.location.IsZero() {
+			lat, lng = &usage.location.Lat, &usage.location.Lng
+		}
+
+		batch.Queue(sql, keyId, usage.lastUsedTime, lat, lng, usage.requestCount)
+	}
+
+	if err := r.pool.SendBatch(ctx, &batch).Close(); err != nil {
+		r.mux.Lock()
+		defer r.mux.Unlock()
+
+		if r.count < 1 {
+			r.since = time.Now()
+		}
+
+		for keyId, usage := range pending {
+			r.pending[keyId] = r.pending[keyId].merge(usage)
+			r.count += usage.requestCount
+		}
+
+		return err
+	}
+
+	return nil
+}
+
+// FlushEvery calls Flush every interval and whenever Record reached the threshold or maxAge, until the context is done.
+// The remaining usage is flushed once more before returning.
+func (r *APIKeyUsageRecorder) FlushEvery(ctx context.Context, interval time.Duration) error {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			flushCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+
+			if err := r.Flush(flushCtx); err != nil {
+				slog.Error("api key usage flush failed", slog.String("error", err.Error()))
+			}
+
+			return ctx.Err()
+		case <-ticker.C:
+		case <-r.flushCh:
+		}
+
+		if err := r.Flush(ctx); err != nil && ctx.Err() == nil {
+			slog.ErrorContext(ctx, "api key usage flush failed", slog.String("error", err.Error()))
+		}
+	}
+}
@@ -0,0 +1,50 @@
+package auth
+
+import (
+	"github.com/gofrs/uuid/v5"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestAPIKeyUsageRecorder(t *testing.T) {
+	keyId := uuid.Must(uuid.NewV4())
+	now := time.Now()
+
+	t.Run("record", func(t *testing.T) {
+		r := NewAPIKeyUsageRecorder(nil, 100, time.Minute)
+		r.Record(keyId, now, SessionMetadata{Lat: 52.5163, Lng: 13.3777})
+		r.Record(keyId, now.Add(time.Second), SessionMetadata{})
+		r.Record(keyId, now.Add(-time.Second), SessionMetadata{Lat: 40.7128, Lng: -74.0060})
+
+		assert.Equal(t, apiKeyUsage{
+			lastUsedTime: now.Add(time.Second),
+			location:     SessionMetadata{Lat: 52.5, Lng: 13.4},
+			requestCount: 3,
+		}, r.pending[keyId])
+	})
+
+	t.Run("threshold", func(t *testing.T) {
+		r := NewAPIKeyUsageRecorder(nil, 2, time.Minute)
+		r.Record(keyId, now, SessionMetadata{})
+		assert.Len(t, r.flushCh, 0)
+
+		r.Record(keyId, now, SessionMetadata{})
+		assert.Len(t, r.flushCh, 1)
+	})
+
+	t.Run("max age", func(t *testing.T) {
+		r := NewAPIKeyUsageRecorder(nil, 100, time.Minute)
+		r.Record(keyId, now, SessionMetadata{})
+		assert.Len(t, r.flushCh, 0)
+
+		r.Record(uuid.Must(uuid.NewV4()), now.Add(time.Minute), SessionMetadata{})
+		assert.Len(t, r.flushCh, 1)
+	})
+
+	t.Run("nil", func(t *testing.T) {
+		var r *APIKeyUsageRecorder
+		r.Record(keyId, now, SessionMetadata{})
+		assert.NoError(t, r.Flush(t.Context()))
+	})
+}
@@ -3,12 +3,12 @@ package auth
 import (
 	"context"
 	"encoding/json"
-	"errors"
 	"fmt"
 	"github.com/gofrs/uuid/v5"
 	"github.com/gw2auth/gw2auth.com-api/service"
 	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgconn"
+	"log/slog"
 	"math"
 	"time"
 )
@@ -22,6 +22,9 @@ type Session struct {
 	CreationTime        time.Time
 	ExpirationTime      time.Time
 	Metadata            SessionMetadata
+	// StepUpRequired is set once the session showed suspicious activity; it stays set for the lifetime of the session
+	StepUpRequired bool
+	LastSeenTime   time.Time
 }
 
 type SessionMetadata struct {
@@ -34,12 +37,87 @@ func (sm SessionMetadata) IsZero() bool {
 	return sm == zero
 }
 
+// Approximate rounds the location to one decimal place (roughly 10km) for display.
+func (sm SessionMetadata) Approximate() SessionMetadata {
+	return SessionMetadata{
+		Lat: math.Round(sm.Lat*10) / 10,
+		Lng: math.Round(sm.Lng*10) / 10,
+	}
+}
+
 type PgxConn interface {
 	Exec(ctx context.Context, sql string, arguments ...any) (pgconn.CommandTag, error)
+	Query(ctx context.Context, sql string, args ...any) (pgx.Rows, error)
 	QueryRow(ctx context.Context, sql string, args ...any) pgx.Row
 }
 
-func LoadAndUpdateSession(ctx context.Context, conn PgxConn, id string, encryptionKey []byte, issuedAt time.Time, newMetadata SessionMetadata, sess *Session) error {
+// LoadSession reads the session without modifying it.
+func LoadSession(ctx context.Context, conn PgxConn, id string, encryptionKey []byte, sess *Session) error {
+	_, err := loadSession(ctx, conn, id, encryptionKey, false, sess)
+	return err
+}
+
+func LoadAndUpdateSession(ctx context.Context, conn PgxConn, policy AnomalyPolicy, id string, encryptionKey []byte, issuedAt time.Time, newMetadata SessionMetadata, sess *Session) error {
+	k, err := loadSession(ctx, conn, id, encryptionKey, true, sess)
+	if err != nil {
+		return err
+	}
+
+	trustedRegions, err := LoadTrustedRegions(ctx, conn, sess.AccountId)
+	if err != nil {
+		return err
+	}
+
+	if policy.Evaluate(sess.Metadata, newMetadata, time.Now().Sub(issuedAt), trustedRegions) == AnomalyOutcomeSuspicious {
+		if !sess.StepUpRequired {
+			slog.WarnContext(ctx, "suspicious session activity, requiring re-authentication for sensitive operations", slog.String("session.id", id))
+		}
+
+		sess.StepUpRequired = true
+	}
+
+	rawMetadata, err := json.Marshal(newMetadata)
+	if err != nil {
+		return fmt.Errorf("failed to marshal new metadata: %w", err)
+	}
+
+	// always writes the current format, which also upgrades metadata stored using AES-CBC
+	if rawMetadata, err = k.Encrypt(rawMetadata); err != nil {
+		return fmt.Errorf("failed to encrypt new metadata: %w", err)
+	}
+
+	const sql = `
+UPDATE account_federation_sessions
+SET expiration_time = $2, metadata = $3, step_up_required = $4, last_seen_time = $5
+WHERE id = $1
+`
+	now := time.Now()
+	newExpTime := now.Add(time.Hour * 24 * 30)
+	_, err = conn.Exec(ctx, sql, id, newExpTime, rawMetadata, sess.StepUpRequired, now)
+	if err != nil {
+		return fmt.Errorf("failed to update session: %w", err)
+	}
+
+	sess.ExpirationTime = newExpTime
+	sess.Metadata = newMetadata
+	sess.LastSeenTime = now
+
+	return nil
+}
+
+// TouchSession advances the last seen time of the session without renewing it.
+func TouchSession(ctx context.Context, conn PgxConn, id string, t time.Time) error {
+	const sql = `
+UPDATE account_federation_sessions
+SET last_seen_time = $2
+WHERE id = $1
+AND (last_seen_time IS NULL OR last_seen_time < $2)
+`
+	_, err := conn.Exec(ctx, sql, id, t)
+	return err
+}
+
+func loadSession(ctx context.Context, conn PgxConn, id string, encryptionKey []byte, forUpdate bool, sess *Session) (service.KeyAndIv, error) {
 	sql := `
 SELECT
 	acc.id,
@@ -48,15 +126,19 @@ SELECT
 	acc_fed.id_at_issuer,
 	acc_fed_sess.creation_time,
 	acc_fed_sess.expiration_time,
-	acc_fed_sess.metadata
+	acc_fed_sess.metadata,
+	acc_fed_sess.step_up_required,
+	COALESCE(acc_fed_sess.last_seen_time, acc_fed_sess.creation_time)
 FROM account_federation_sessions acc_fed_sess
 INNER JOIN account_federations acc_fed
 ON acc_fed_sess.issuer = acc_fed.issuer AND acc_fed_sess.id_at_issuer = acc_fed.id_at_issuer
 INNER JOIN accounts acc
 ON acc_fed.account_id = acc.id
 WHERE acc_fed_sess.id = $1
-FOR UPDATE OF acc_fed_sess
 `
+	if forUpdate {
+		sql += "FOR UPDATE OF acc_fed_sess\n"
+	}
 
 	var rawMetadata []byte
 	err := conn.QueryRow(ctx, sql, id).Scan(
@@ -67,54 +149,30 @@ FOR UPDATE OF acc_fed_sess
 		&sess.CreationTime,
 		&sess.ExpirationTime,
 		&rawMetadata,
+		&sess.StepUpRequired,
+		&sess.LastSeenTime,
 	)
 
 	if err != nil {
-		return fmt.Errorf("failed to load session: %w", err)
+		return service.KeyAndIv{}, fmt.Errorf("failed to load session: %w", err)
 	}
 
 	k, err := service.NewKeyAndIvFromBytes(encryptionKey)
 	if err != nil {
-		return fmt.Errorf("could not load encryption key from bytes: %w", err)
+		return service.KeyAndIv{}, fmt.Errorf("could not load encryption key from bytes: %w", err)
 	}
 
 	rawMetadata, err = k.Decrypt(rawMetadata)
 	if err != nil {
-		return fmt.Errorf("failed to decrypt stored metadata: %w", err)
+		return service.KeyAndIv{}, fmt.Errorf("failed to decrypt stored metadata: %w", err)
 	}
 
 	if err = json.Unmarshal(rawMetadata, &sess.Metadata); err != nil {
-		return fmt.Errorf("failed to parse stored metadata: %w", err)
-	}
-
-	if !isMetadataPlausible(sess.Metadata, newMetadata, time.Now().Sub(issuedAt)) {
-		return errors.New("metadata not plausible")
-	}
-
-	if rawMetadata, err = json.Marshal(newMetadata); err != nil {
-		return fmt.Errorf("failed to marshal new metadata: %w", err)
-	}
-
-	if rawMetadata, err = k.Encrypt(rawMetadata); err != nil {
-		return fmt.Errorf("failed to encrypt new metadata: %w", err)
-	}
-
-	sql = `
-UPDATE account_federation_sessions
-SET expiration_time = $2, metadata = $3
-WHERE id = $1
-`
-	newExpTime := time.Now().Add(time.Hour * 24 * 30)
-	_, err = conn.Exec(ctx, sql, id, newExpTime, rawMetadata)
-	if err != nil {
-		return fmt.Errorf("failed to update session: %w", err)
+		return service.KeyAndIv{}, fmt.Errorf("failed to parse stored metadata: %w", err)
 	}
 
 	sess.Id = id
-	sess.ExpirationTime = newExpTime
-	sess.Metadata = newMetadata
-
-	return nil
+	return k, nil
 }
 
 func DeleteSession(ctx context.Context, conn PgxConn, id string) error {
@@ -122,25 +180,6 @@ func DeleteSession(ctx context.Context, conn PgxConn, id string) error {
 	return err
 }
 
-func isMetadataPlausible(orig, current SessionMetadata, passed time.Duration) bool {
-	if orig.IsZero() {
-		return true
-	}
-
-	travelledKm := distance(orig.Lat, orig.Lng, current.Lat, current.Lng)
-	if travelledKm > 1000 {
-		// never allow to travel more than 1000km
-		return false
-	}
-
-	if travelledKm <= 30 {
-		// always allow to travel 30km
-		return true
-	}
-
-	return travelledKm <= (333.3 * (passed.Seconds() / 86400.0))
-}
-
 // https://gist.github.com/hotdang-ca/6c1ee75c48e515aec5bc6db6e3265e49
 // value returned is distance in kilometers
 func distance(lat1 float64, lng1 float64, lat2 float64, lng2 float64) float64 {
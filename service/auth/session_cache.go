@@ -0,0 +1,168 @@
+package auth
+
+import (
+	"sync"
+	"time"
+)
+
+// revokedGrace is how long a revoked session id is remembered in addition to the ttl.
+// Revoked sessions are deleted from the database as well, so the id only needs to be remembered
+// as long as a cached entry or a request which read the session before it was revoked might still add it.
+const revokedGrace = time.Minute
+
+type sessionCacheEntry struct {
+	session   Session
+	expiresAt time.Time
+}
+
+// SessionCache keeps recently validated sessions in memory for a short time so that
+// subsequent requests of the same session do not need to read it from the database.
+//
+// Sessions revoked through this cache are rejected immediately. Sessions deleted by another
+// process are only noticed once the cached entry expires, so the ttl should be kept short.
+//
+// Both the cached sessions and the revoked session ids are limited to maxEntries each; the oldest are evicted first.
+//
+// A nil *SessionCache is valid and caches nothing.
+type SessionCache struct {
+	ttl        time.Duration
+	maxEntries int
+	mu         sync.Mutex
+	entries    map[string]sessionCacheEntry
+	revoked    map[string]time.Time
+	lastPrune  time.Time
+}
+
+func NewSessionCache(ttl time.Duration, maxEntries int) *SessionCache {
+	return &SessionCache{
+		ttl:        ttl,
+		maxEntries: maxEntries,
+		entries:    make(map[string]sessionCacheEntry),
+		revoked:    make(map[string]time.Time),
+	}
+}
+
+func (c *SessionCache) Get(id string) (Session, bool) {
+	if c == nil {
+		return Session{}, false
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	e, ok := c.entries[id]
+	if !ok {
+		return Session{}, false
+	}
+
+	now := time.Now()
+	if !now.Before(e.expiresAt) || !now.Before(e.session.ExpirationTime) {
+		delete(c.entries, id)
+		return Session{}, false
+	}
+
+	return e.session, true
+}
+
+func (c *SessionCache) Put(sess Session) {
+	if c == nil {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	if now.Sub(c.lastPrune) >= c.ttl {
+		c.prune(now)
+		c.lastPrune = now
+	}
+
+	if _, ok := c.revoked[sess.Id]; ok {
+		return
+	}
+
+	if _, ok := c.entries[sess.Id]; !ok && len(c.entries) >= c.maxEntries {
+		c.evictOldest()
+	}
+
+	c.entries[sess.Id] = sessionCacheEntry{
+		session:   sess,
+		expiresAt: now.Add(c.ttl),
+	}
+}
+
+// Revoke removes the sessions from the cache and remembers them as revoked.
+func (c *SessionCache) Revoke(ids ...string) {
+	if c == nil {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	until := now.Add(c.ttl + revokedGrace)
+	for _, id := range ids {
+		delete(c.entries, id)
+
+		if _, ok := c.revoked[id]; !ok && len(c.revoked) >= c.maxEntries {
+			c.prune(now)
+			if len(c.revoked) >= c.maxEntries {
+				c.evictOldestRevoked()
+			}
+		}
+
+		c.revoked[id] = until
+	}
+}
+
+func (c *SessionCache) IsRevoked(id string) bool {
+	if c == nil {
+		return false
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	until, ok := c.revoked[id]
+	return ok && time.Now().Before(until)
+}
+
+func (c *SessionCache) prune(now time.Time) {
+	for id, e := range c.entries {
+		if !now.Before(e.expiresAt) {
+			delete(c.entries, id)
+		}
+	}
+
+	for id, until := range c.revoked {
+		if !now.Before(until) {
+			delete(c.revoked, id)
+		}
+	}
+}
+
+func (c *SessionCache) evictOldest() {
+	var oldestId string
+	var oldest time.Time
+	for id, e := range c.entries {
+		if oldest.IsZero() || e.expiresAt.Before(oldest) {
+			oldestId, oldest = id, e.expiresAt
+		}
+	}
+
+	delete(c.entries, oldestId)
+}
+
+func (c *SessionCache) evictOldestRevoked() {
+	var oldestId string
+	var oldest time.Time
+	for id, until := range c.revoked {
+		if oldest.IsZero() || until.Before(oldest) {
+			oldestId, oldest = id, until
+		}
+	}
+
+	delete(c.revoked, oldestId)
+}
@@ -0,0 +1,92 @@
+package auth
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestSessionCache(t *testing.T) {
+	sess := Session{Id: "a", ExpirationTime: time.Now().Add(time.Hour)}
+
+	t.Run("get", func(t *testing.T) {
+		c := NewSessionCache(time.Minute, 10)
+		_, ok := c.Get(sess.Id)
+		assert.False(t, ok)
+
+		c.Put(sess)
+		cached, ok := c.Get(sess.Id)
+		assert.True(t, ok)
+		assert.Equal(t, sess, cached)
+	})
+
+	t.Run("ttl", func(t *testing.T) {
+		c := NewSessionCache(time.Millisecond, 10)
+		c.Put(sess)
+		time.Sleep(5 * time.Millisecond)
+
+		_, ok := c.Get(sess.Id)
+		assert.False(t, ok)
+	})
+
+	t.Run("expired session", func(t *testing.T) {
+		c := NewSessionCache(time.Minute, 10)
+		c.Put(Session{Id: "b", ExpirationTime: time.Now().Add(-time.Second)})
+
+		_, ok := c.Get("b")
+		assert.False(t, ok)
+	})
+
+	t.Run("revoke", func(t *testing.T) {
+		c := NewSessionCache(time.Minute, 10)
+		c.Put(sess)
+		c.Revoke(sess.Id)
+
+		_, ok := c.Get(sess.Id)
+		assert.False(t, ok)
+		assert.True(t, c.IsRevoked(sess.Id))
+
+		// a request which loaded the session before it was revoked must not add it again
+		c.Put(sess)
+		_, ok = c.Get(sess.Id)
+		assert.False(t, ok)
+	})
+
+	t.Run("max entries", func(t *testing.T) {
+		c := NewSessionCache(time.Minute, 1)
+		c.Put(sess)
+		c.Put(Session{Id: "b", ExpirationTime: time.Now().Add(time.Hour)})
+
+		_, ok := c.Get(sess.Id)
+		assert.False(t, ok)
+
+		_, ok = c.Get("b")
+		assert.True(t, ok)
+
+		c.Revoke("c")
+		c.Revoke("d")
+		assert.False(t, c.IsRevoked("c"))
+		assert.True(t, c.IsRevoked("d"))
+	})
+
+	t.Run("revoked retention", func(t *testing.T) {
+		c := NewSessionCache(time.Millisecond, 10)
+		c.Revoke(sess.Id)
+		assert.True(t, c.IsRevoked(sess.Id))
+
+		c.mu.Lock()
+		until := c.revoked[sess.Id]
+		c.mu.Unlock()
+		assert.WithinDuration(t, time.Now().Add(time.Millisecond+revokedGrace), until, time.Second)
+	})
+
+	t.Run("nil", func(t *testing.T) {
+		var c *SessionCache
+		c.Put(sess)
+		c.Revoke(sess.Id)
+
+		_, ok := c.Get(sess.Id)
+		assert.False(t, ok)
+		assert.False(t, c.IsRevoked(sess.Id))
+	})
+}
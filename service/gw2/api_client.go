@@ -5,14 +5,24 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"golang.org/x/time/rate"
 	"io"
+	"math/rand/v2"
 	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
 )
 
+// apiVersion is the schema version requested for every call; the models in model.go follow this version
 const apiVersion = "2023-11-19"
 
 var (
 	ErrInvalidApiToken = errors.New("invalid api token")
+	ErrCircuitOpen     = errors.New("gw2api is unavailable")
 )
 
 type ApiError struct {
@@ -26,19 +36,58 @@ func (e ApiError) Error() string {
 
 func IsApiError(err error) bool {
 	var x ApiError
-	return errors.Is(err, &x)
+	return errors.As(err, &x)
+}
+
+type ApiClientOption func(c *ApiClient)
+
+// WithRetries retries requests failing with 429, 5xx or a transport error up to maxRetries times.
+// The delay between attempts is chosen randomly up to baseDelay*2^attempt (capped at maxDelay),
+// unless the response carries a Retry-After header. Responses asking to wait longer than maxDelay are not retried.
+func WithRetries(maxRetries int, baseDelay, maxDelay time.Duration) ApiClientOption {
+	return func(c *ApiClient) {
+		c.maxRetries = maxRetries
+		c.baseDelay = baseDelay
+		c.maxDelay = maxDelay
+	}
+}
+
+// WithRateLimit limits outgoing requests (including retries) using a token bucket.
+func WithRateLimit(limit rate.Limit, burst int) ApiClientOption {
+	return func(c *ApiClient) {
+		c.limiter = rate.NewLimiter(limit, burst)
+	}
+}
+
+// WithCircuitBreaker makes the client fail fast with ErrCircuitOpen for openDuration
+// after threshold consecutive attempts failed with 429, 5xx or a transport error.
+func WithCircuitBreaker(threshold int, openDuration time.Duration) ApiClientOption {
+	return func(c *ApiClient) {
+		c.breaker = newCircuitBreaker(threshold, openDuration)
+	}
 }
 
 type ApiClient struct {
 	httpClient *http.Client
 	url        string
+	maxRetries int
+	baseDelay  time.Duration
+	maxDelay   time.Duration
+	limiter    *rate.Limiter
+	breaker    *circuitBreaker
 }
 
-func NewApiClient(httpClient *http.Client, url string) *ApiClient {
-	return &ApiClient{
+func NewApiClient(httpClient *http.Client, url string, options ...ApiClientOption) *ApiClient {
+	c := &ApiClient{
 		httpClient: httpClient,
 		url:        url,
 	}
+
+	for _, opt := range options {
+		opt(c)
+	}
+
+	return c
 }
 
 func (c *ApiClient) Account(ctx context.Context, token string) (Account, error) {
@@ -51,15 +100,93 @@ func (c *ApiClient) TokenInfo(ctx context.Context, token string) (TokenInfo, err
 	return tokenInfo, c.do(ctx, "/v2/tokeninfo", token, &tokenInfo)
 }
 
+func (c *ApiClient) Characters(ctx context.Context, token string) ([]string, error) {
+	var names []string
+	return names, c.do(ctx, "/v2/characters", token, &names)
+}
+
+func (c *ApiClient) CommerceTransactionsCurrentBuys(ctx context.Context, token string) ([]CommerceTransaction, error) {
+	var transactions []CommerceTransaction
+	return transactions, c.do(ctx, "/v2/commerce/transactions/current/buys", token, &transactions)
+}
+
+// CreateSubToken creates a subtoken of token limited to the given permissions and urls (if any).
+func (c *ApiClient) CreateSubToken(ctx context.Context, token string, perms []Permission, expire time.Time, urls []string) (string, error) {
+	permStrs := make([]string, 0, len(perms))
+	for _, perm := range perms {
+		permStrs = append(permStrs, string(perm))
+	}
+
+	q := make(url.Values)
+	q.Set("permissions", strings.Join(permStrs, ","))
+	q.Set("expire", expire.UTC().Format(time.RFC3339))
+
+	if len(urls) > 0 {
+		q.Set("urls", strings.Join(urls, ","))
+	}
+
+	var res createSubTokenResponse
+	return res.SubToken, c.doWithQuery(ctx, "/v2/createsubtoken", token, q, &res)
+}
+
 func (c *ApiClient) do(ctx context.Context, endpoint string, token string, out any) error {
-	req, err := c.newRequest(ctx, endpoint, token)
+	return c.doWithQuery(ctx, endpoint, token, nil, out)
+}
+
+func (c *ApiClient) doWithQuery(ctx context.Context, endpoint string, token string, query url.Values, out any) error {
+	span := trace.SpanFromContext(ctx)
+
+	for attempt := 0; ; attempt++ {
+		if !c.breaker.allow(ctx) {
+			return ErrCircuitOpen
+		}
+
+		if err := c.wait(ctx); err != nil {
+			c.breaker.release()
+			return err
+		}
+
+		statusCode, retryAfter, err := c.doOnce(ctx, endpoint, token, query, out)
+		if ctx.Err() != nil {
+			c.breaker.release()
+			return err
+		}
+
+		retryable := isRetryable(statusCode, err)
+		c.breaker.record(ctx, !retryable)
+
+		if !retryable || attempt >= c.maxRetries {
+			return err
+		}
+
+		delay, ok := c.retryDelay(attempt, retryAfter)
+		if !ok {
+			return err
+		}
+
+		span.AddEvent("gw2api.retry", trace.WithAttributes(
+			attribute.String("gw2api.endpoint", endpoint),
+			attribute.Int("gw2api.attempt", attempt+1),
+			attribute.Int("http.response.status_code", statusCode),
+			attribute.Int64("gw2api.retry.delay_ms", delay.Milliseconds()),
+		))
+
+		if err := sleep(ctx, delay); err != nil {
+			return err
+		}
+	}
+}
+
+// doOnce performs a single request; a statusCode of 0 indicates that no response was received
+func (c *ApiClient) doOnce(ctx context.Context, endpoint string, token string, query url.Values, out any) (int, time.Duration, error) {
+	req, err := c.newRequest(ctx, endpoint, token, query)
 	if err != nil {
-		return fmt.Errorf("failed to construct request: %w", err)
+		return 0, 0, fmt.Errorf("failed to construct request: %w", err)
 	}
 
 	res, err := c.httpClient.Do(req)
 	if err != nil {
-		return fmt.Errorf("gw2api request failed: %w", err)
+		return 0, 0, fmt.Errorf("gw2api request failed: %w", err)
 	}
 
 	defer res.Body.Close()
@@ -79,22 +206,100 @@ func (c *ApiClient) do(ctx context.Context, endpoint string, token string, out a
 		}
 
 		if res.StatusCode == http.StatusUnauthorized || res.StatusCode == http.StatusForbidden {
-			return errors.Join(ErrInvalidApiToken, err)
+			return res.StatusCode, 0, errors.Join(ErrInvalidApiToken, err)
 		} else {
+			return res.StatusCode, parseRetryAfter(res.Header.Get("Retry-After")), err
+		}
+	}
+
+	return res.StatusCode, 0, json.NewDecoder(res.Body).Decode(out)
+}
+
+func (c *ApiClient) wait(ctx context.Context) error {
+	if c.limiter == nil {
+		return nil
+	}
+
+	r := c.limiter.Reserve()
+	if !r.OK() {
+		return errors.New("gw2api rate limiter burst exceeded")
+	}
+
+	if delay := r.Delay(); delay > 0 {
+		trace.SpanFromContext(ctx).AddEvent("gw2api.rate_limited", trace.WithAttributes(
+			attribute.Int64("gw2api.rate_limited.delay_ms", delay.Milliseconds()),
+		))
+
+		if err := sleep(ctx, delay); err != nil {
+			r.Cancel()
 			return err
 		}
 	}
 
-	return json.NewDecoder(res.Body).Decode(out)
+	return nil
 }
 
-func (c *ApiClient) newRequest(ctx context.Context, endpoint string, token string) (*http.Request, error) {
+func (c *ApiClient) retryDelay(attempt int, retryAfter time.Duration) (time.Duration, bool) {
+	if retryAfter > 0 {
+		return retryAfter, retryAfter <= c.maxDelay
+	}
+
+	upper := min(c.baseDelay<<attempt, c.maxDelay)
+	if upper <= 0 {
+		return 0, true
+	}
+
+	return rand.N(upper + 1), true
+}
+
+func isRetryable(statusCode int, err error) bool {
+	if err == nil {
+		return false
+	}
+
+	// statusCode 0 means no response was received at all
+	return statusCode == 0 || statusCode == http.StatusTooManyRequests || statusCode >= 500
+}
+
+func parseRetryAfter(v string) time.Duration {
+	if v == "" {
+		return 0
+	}
+
+	if seconds, err := strconv.Atoi(v); err == nil {
+		return time.Duration(max(seconds, 0)) * time.Second
+	}
+
+	if t, err := http.ParseTime(v); err == nil {
+		return max(time.Until(t), 0)
+	}
+
+	return 0
+}
+
+func sleep(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-t.C:
+		return nil
+	}
+}
+
+func (c *ApiClient) newRequest(ctx context.Context, endpoint string, token string, query url.Values) (*http.Request, error) {
 	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.url+endpoint, nil)
 	if err != nil {
 		return nil, err
 	}
 
 	q := req.URL.Query()
+	for k, v := range query {
+		q[k] = v
+	}
+
 	q.Set("v", apiVersion)
 
 	if token != "" {
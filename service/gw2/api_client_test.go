@@ -0,0 +1,153 @@
+package gw2
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestIsApiError(t *testing.T) {
+	assert.True(t, IsApiError(ApiError{StatusCode: http.StatusBadGateway}))
+	assert.True(t, IsApiError(errors.Join(ErrInvalidApiToken, ApiError{StatusCode: http.StatusUnauthorized})))
+	assert.False(t, IsApiError(errors.New("some error")))
+}
+
+func TestApiClient_Retries(t *testing.T) {
+	var requests atomic.Int32
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if requests.Add(1) < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+
+		_ = json.NewEncoder(w).Encode(TokenInfo{Name: "TokenName"})
+	}))
+	defer s.Close()
+
+	client := NewApiClient(s.Client(), s.URL, WithRetries(2, time.Millisecond, 10*time.Millisecond))
+	tokenInfo, err := client.TokenInfo(context.Background(), "token")
+
+	assert.NoError(t, err)
+	assert.Equal(t, "TokenName", tokenInfo.Name)
+	assert.Equal(t, int32(3), requests.Load())
+}
+
+func TestApiClient_RetriesExhausted(t *testing.T) {
+	var requests atomic.Int32
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		requests.Add(1)
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer s.Close()
+
+	client := NewApiClient(s.Client(), s.URL, WithRetries(2, time.Millisecond, 10*time.Millisecond))
+	_, err := client.TokenInfo(context.Background(), "token")
+
+	assert.True(t, IsApiError(err))
+	assert.Equal(t, int32(3), requests.Load())
+}
+
+func TestApiClient_NoRetryOnInvalidToken(t *testing.T) {
+	var requests atomic.Int32
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		requests.Add(1)
+		w.WriteHeader(http.StatusUnauthorized)
+	}))
+	defer s.Close()
+
+	client := NewApiClient(s.Client(), s.URL, WithRetries(2, time.Millisecond, 10*time.Millisecond))
+	_, err := client.TokenInfo(context.Background(), "token")
+
+	assert.ErrorIs(t, err, ErrInvalidApiToken)
+	assert.Equal(t, int32(1), requests.Load())
+}
+
+func TestApiClient_RetryAfter(t *testing.T) {
+	var requests atomic.Int32
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		requests.Add(1)
+		w.Header().Set("Retry-After", "60")
+		w.WriteHeader(http.StatusTooManyRequests)
+	}))
+	defer s.Close()
+
+	// the server asks to wait longer than the maximum delay, so the client must give up immediately
+	client := NewApiClient(s.Client(), s.URL, WithRetries(2, time.Millisecond, time.Second))
+	_, err := client.TokenInfo(context.Background(), "token")
+
+	assert.True(t, IsApiError(err))
+	assert.Equal(t, int32(1), requests.Load())
+}
+
+func TestApiClient_CircuitBreaker(t *testing.T) {
+	var requests atomic.Int32
+	var healthy atomic.Bool
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		requests.Add(1)
+		if !healthy.Load() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+
+		_ = json.NewEncoder(w).Encode(TokenInfo{Name: "TokenName"})
+	}))
+	defer s.Close()
+
+	client := NewApiClient(s.Client(), s.URL, WithCircuitBreaker(2, 50*time.Millisecond))
+
+	for i := 0; i < 2; i++ {
+		_, err := client.TokenInfo(context.Background(), "token")
+		assert.True(t, IsApiError(err))
+	}
+
+	_, err := client.TokenInfo(context.Background(), "token")
+	assert.ErrorIs(t, err, ErrCircuitOpen)
+	assert.Equal(t, int32(2), requests.Load())
+
+	healthy.Store(true)
+	time.Sleep(60 * time.Millisecond)
+
+	_, err = client.TokenInfo(context.Background(), "token")
+	assert.NoError(t, err)
+
+	_, err = client.TokenInfo(context.Background(), "token")
+	assert.NoError(t, err)
+	assert.Equal(t, int32(4), requests.Load())
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	assert.Equal(t, time.Duration(0), parseRetryAfter(""))
+	assert.Equal(t, 5*time.Second, parseRetryAfter("5"))
+	assert.Equal(t, time.Duration(0), parseRetryAfter("invalid"))
+	assert.InDelta(t, float64(10*time.Second), float64(parseRetryAfter(time.Now().Add(10*time.Second).UTC().Format(http.TimeFormat))), float64(time.Second))
+}
+
+func TestApiClient_CreateSubToken(t *testing.T) {
+	expire := time.Date(2025, 1, 2, 3, 4, 5, 0, time.UTC)
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		q := req.URL.Query()
+		if req.URL.Path != "/v2/createsubtoken" || q.Get("access_token") != "token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		assert.Equal(t, "account,wallet", q.Get("permissions"))
+		assert.Equal(t, "2025-01-02T03:04:05Z", q.Get("expire"))
+		assert.Equal(t, "/v2/account,/v2/account/wallet", q.Get("urls"))
+
+		_ = json.NewEncoder(w).Encode(map[string]string{"subtoken": "SubToken"})
+	}))
+	defer s.Close()
+
+	client := NewApiClient(s.Client(), s.URL)
+	subToken, err := client.CreateSubToken(context.Background(), "token", []Permission{PermissionAccount, PermissionWallet}, expire, []string{"/v2/account", "/v2/account/wallet"})
+
+	assert.NoError(t, err)
+	assert.Equal(t, "SubToken", subToken)
+}
@@ -0,0 +1,97 @@
+package gw2
+
+import (
+	"context"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"sync"
+	"time"
+)
+
+// circuitBreaker opens after threshold consecutive upstream failures and rejects all requests for openDuration.
+// Once openDuration has passed, a single probe request is let through: if it succeeds the breaker closes again,
+// otherwise it stays open for another openDuration.
+type circuitBreaker struct {
+	mutex         sync.Mutex
+	threshold     int
+	openDuration  time.Duration
+	failures      int
+	openUntil     time.Time
+	probeInFlight bool
+}
+
+func newCircuitBreaker(threshold int, openDuration time.Duration) *circuitBreaker {
+	return &circuitBreaker{
+		threshold:    threshold,
+		openDuration: openDuration,
+	}
+}
+
+func (b *circuitBreaker) allow(ctx context.Context) bool {
+	if b == nil {
+		return true
+	}
+
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if b.openUntil.IsZero() {
+		return true
+	}
+
+	if time.Now().Before(b.openUntil) || b.probeInFlight {
+		trace.SpanFromContext(ctx).AddEvent("gw2api.circuit_breaker.rejected", trace.WithAttributes(
+			attribute.String("gw2api.circuit_breaker.open_until", b.openUntil.Format(time.RFC3339)),
+		))
+		return false
+	}
+
+	b.probeInFlight = true
+	trace.SpanFromContext(ctx).AddEvent("gw2api.circuit_breaker.probe")
+
+	return true
+}
+
+func (b *circuitBreaker) record(ctx context.Context, success bool) {
+	if b == nil {
+		return
+	}
+
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	wasOpen := !b.openUntil.IsZero()
+	b.probeInFlight = false
+
+	if success {
+		b.failures = 0
+		b.openUntil = time.Time{}
+
+		if wasOpen {
+			trace.SpanFromContext(ctx).AddEvent("gw2api.circuit_breaker.closed")
+		}
+
+		return
+	}
+
+	b.failures++
+	if wasOpen || b.failures >= b.threshold {
+		b.openUntil = time.Now().Add(b.openDuration)
+		trace.SpanFromContext(ctx).AddEvent("gw2api.circuit_breaker.opened", trace.WithAttributes(
+			attribute.Int("gw2api.circuit_breaker.failures", b.failures),
+			attribute.String("gw2api.circuit_breaker.open_until", b.openUntil.Format(time.RFC3339)),
+		))
+	}
+}
+
+// release gives up an allowed request without an outcome (e.g. because the context was canceled)
+func (b *circuitBreaker) release() {
+	if b == nil {
+		return
+	}
+
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	b.probeInFlight = false
+}
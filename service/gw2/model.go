@@ -1,6 +1,9 @@
 package gw2
 
-import "github.com/gofrs/uuid/v5"
+import (
+	"github.com/gofrs/uuid/v5"
+	"time"
+)
 
 type Permission string
 
@@ -18,12 +21,67 @@ const (
 	PermissionWallet      Permission = "wallet"
 )
 
+type TokenType string
+
+const (
+	TokenTypeApiKey   TokenType = "APIKey"
+	TokenTypeSubToken TokenType = "Subtoken"
+)
+
+type ProductAccess string
+
+const (
+	ProductAccessNone                ProductAccess = "None"
+	ProductAccessPlayForFree         ProductAccess = "PlayForFree"
+	ProductAccessGuildWars2          ProductAccess = "GuildWars2"
+	ProductAccessHeartOfThorns       ProductAccess = "HeartOfThorns"
+	ProductAccessPathOfFire          ProductAccess = "PathOfFire"
+	ProductAccessEndOfDragons        ProductAccess = "EndOfDragons"
+	ProductAccessSecretsOfTheObscure ProductAccess = "SecretsOfTheObscure"
+	ProductAccessJanthirWilds        ProductAccess = "JanthirWilds"
+)
+
+// Account is the response of /v2/account. The fields follow the schema version sent with every request (apiVersion).
 type Account struct {
-	Id   uuid.UUID `json:"id"`
-	Name string    `json:"name"`
+	Id           uuid.UUID       `json:"id"`
+	Name         string          `json:"name"`
+	Age          int             `json:"age"`
+	World        int             `json:"world"`
+	Guilds       []uuid.UUID     `json:"guilds"`
+	GuildLeader  []uuid.UUID     `json:"guild_leader,omitempty"`
+	Created      time.Time       `json:"created"`
+	Access       []ProductAccess `json:"access"`
+	Commander    bool            `json:"commander"`
+	FractalLevel int             `json:"fractal_level,omitempty"`
+	// LastModified is only present with schema versions >= 2019-02-21
+	LastModified time.Time `json:"last_modified"`
 }
 
+// TokenInfo is the response of /v2/tokeninfo.
+// Type, ExpiresAt, IssuedAt and Urls are only present with schema versions >= 2019-05-22;
+// ExpiresAt, IssuedAt and Urls are only set for subtokens.
 type TokenInfo struct {
+	Id          string       `json:"id"`
 	Name        string       `json:"name"`
 	Permissions []Permission `json:"permissions"`
+	Type        TokenType    `json:"type"`
+	ExpiresAt   *time.Time   `json:"expires_at,omitempty"`
+	IssuedAt    *time.Time   `json:"issued_at,omitempty"`
+	Urls        []string     `json:"urls,omitempty"`
+}
+
+func (t TokenInfo) IsSubToken() bool {
+	return t.Type == TokenTypeSubToken
+}
+
+type createSubTokenResponse struct {
+	SubToken string `json:"subtoken"`
+}
+
+type CommerceTransaction struct {
+	Id       int64     `json:"id"`
+	ItemId   int       `json:"item_id"`
+	Price    int       `json:"price"`
+	Quantity int       `json:"quantity"`
+	Created  time.Time `json:"created"`
 }
@@ -0,0 +1,79 @@
+package gw2
+
+import (
+	"encoding/json"
+	"github.com/gofrs/uuid/v5"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestAccount_Unmarshal(t *testing.T) {
+	const raw = `{
+  "id": "A9A6C2A1-6B5E-E211-B2E1-78E7D1936222",
+  "name": "Felix.9127",
+  "age": 3600,
+  "world": 2202,
+  "guilds": ["5E6D6F3D-8F58-E811-81A8-D6D5FA4C5E72"],
+  "guild_leader": [],
+  "created": "2012-08-28T00:00:00Z",
+  "access": ["GuildWars2", "HeartOfThorns", "PathOfFire"],
+  "commander": true,
+  "fractal_level": 100,
+  "last_modified": "2024-01-02T03:04:05Z"
+}`
+
+	var acc Account
+	if !assert.NoError(t, json.Unmarshal([]byte(raw), &acc)) {
+		return
+	}
+
+	assert.Equal(t, uuid.FromStringOrNil("a9a6c2a1-6b5e-e211-b2e1-78e7d1936222"), acc.Id)
+	assert.Equal(t, "Felix.9127", acc.Name)
+	assert.Equal(t, 2202, acc.World)
+	assert.Equal(t, []uuid.UUID{uuid.FromStringOrNil("5e6d6f3d-8f58-e811-81a8-d6d5fa4c5e72")}, acc.Guilds)
+	assert.Equal(t, time.Date(2012, 8, 28, 0, 0, 0, 0, time.UTC), acc.Created)
+	assert.Equal(t, []ProductAccess{ProductAccessGuildWars2, ProductAccessHeartOfThorns, ProductAccessPathOfFire}, acc.Access)
+	assert.True(t, acc.Commander)
+	assert.Equal(t, time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC), acc.LastModified)
+}
+
+func TestTokenInfo_Unmarshal(t *testing.T) {
+	t.Run("api key", func(t *testing.T) {
+		const raw = `{"id": "017A2B0C-A6C5-CE4D-A1E6-8A93B5F1A2C5", "name": "GW2Auth", "permissions": ["account", "wallet"], "type": "APIKey"}`
+
+		var tokenInfo TokenInfo
+		if !assert.NoError(t, json.Unmarshal([]byte(raw), &tokenInfo)) {
+			return
+		}
+
+		assert.False(t, tokenInfo.IsSubToken())
+		assert.Equal(t, []Permission{PermissionAccount, PermissionWallet}, tokenInfo.Permissions)
+		assert.Nil(t, tokenInfo.ExpiresAt)
+		assert.Nil(t, tokenInfo.IssuedAt)
+	})
+
+	t.Run("subtoken", func(t *testing.T) {
+		const raw = `{
+  "id": "017A2B0C-A6C5-CE4D-A1E6-8A93B5F1A2C5",
+  "name": "GW2Auth",
+  "permissions": ["account"],
+  "type": "Subtoken",
+  "expires_at": "2024-01-02T03:04:05Z",
+  "issued_at": "2024-01-01T03:04:05Z",
+  "urls": ["/v2/account"]
+}`
+
+		var tokenInfo TokenInfo
+		if !assert.NoError(t, json.Unmarshal([]byte(raw), &tokenInfo)) {
+			return
+		}
+
+		assert.True(t, tokenInfo.IsSubToken())
+		if assert.NotNil(t, tokenInfo.ExpiresAt) && assert.NotNil(t, tokenInfo.IssuedAt) {
+			assert.Equal(t, time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC), *tokenInfo.ExpiresAt)
+			assert.Equal(t, time.Date(2024, 1, 1, 3, 4, 5, 0, time.UTC), *tokenInfo.IssuedAt)
+		}
+		assert.Equal(t, []string{"/v2/account"}, tokenInfo.Urls)
+	})
+}
@@ -1,11 +1,17 @@
 package service
 
 import (
+	"context"
 	"crypto/rsa"
 	"encoding/base64"
 	"errors"
 	"fmt"
 	"github.com/golang-jwt/jwt/v5"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+	"log/slog"
+	"sync/atomic"
 	"time"
 )
 
@@ -25,31 +31,126 @@ type SessionJwtClaims struct {
 }
 
 type SessionJwtConverter struct {
-	kid    string
-	priv   *rsa.PrivateKey
-	pub    map[string]*rsa.PublicKey
-	parser *jwt.Parser
+	keys      atomic.Pointer[KeySet]
+	parser    *jwt.Parser
+	keyUsages metric.Int64Counter
 }
 
+// NewSessionJwtConverter creates a converter signing with priv; its public key is accepted even if kid is not part of pub
 func NewSessionJwtConverter(kid string, priv *rsa.PrivateKey, pub map[string]*rsa.PublicKey) *SessionJwtConverter {
-	return &SessionJwtConverter{
-		kid:  kid,
-		priv: priv,
-		pub:  pub,
+	ks := &KeySet{
+		activeKid: kid,
+		keys:      make(map[string]SessionKey, len(pub)+1),
+	}
+
+	for k, v := range pub {
+		ks.keys[k] = SessionKey{Kid: k, Public: v, method: jwt.SigningMethodRS256}
+	}
+
+	active := ks.keys[kid]
+	active.Kid = kid
+	active.Private = priv
+	active.method = jwt.SigningMethodRS256
+	if active.Public == nil {
+		active.Public = &priv.PublicKey
+	}
+
+	ks.keys[kid] = active
+
+	return NewSessionJwtConverterFromKeySet(ks)
+}
+
+func NewSessionJwtConverterFromKeySet(ks *KeySet) *SessionJwtConverter {
+	meter := otel.Meter("github.com/gw2auth/gw2auth.com-api::SessionJwtConverter", metric.WithInstrumentationVersion("v0.0.1"))
+	keyUsages, err := meter.Int64Counter(
+		"gw2auth.session.jwt.key_usages",
+		metric.WithDescription("Number of session jwts read or written, by kid"),
+	)
+	if err != nil {
+		otel.Handle(err)
+	}
+
+	c := &SessionJwtConverter{
 		parser: jwt.NewParser(
 			jwt.WithIssuer(issuer),
 			jwt.WithLeeway(time.Second*5),
-			jwt.WithValidMethods([]string{jwt.SigningMethodRS256.Alg()}),
+			jwt.WithValidMethods([]string{
+				jwt.SigningMethodRS256.Alg(),
+				jwt.SigningMethodEdDSA.Alg(),
+				jwt.SigningMethodES256.Alg(),
+				jwt.SigningMethodES384.Alg(),
+				jwt.SigningMethodES512.Alg(),
+			}),
 		),
+		keyUsages: keyUsages,
 	}
+	c.keys.Store(ks)
+
+	return c
 }
 
-func (c *SessionJwtConverter) ReadJWT(jwtStr string) (SessionJwtClaims, time.Time, error) {
-	tk, err := c.parser.Parse(jwtStr, func(tk *jwt.Token) (interface{}, error) {
-		if m, ok := tk.Method.(*jwt.SigningMethodRSA); !ok || m != jwt.SigningMethodRS256 {
-			return nil, fmt.Errorf("unexpected signing method: %v", tk.Header["alg"])
+// SetKeySet replaces the keys used by the converter; jwts which are currently being read or written are not affected
+func (c *SessionJwtConverter) SetKeySet(ks *KeySet) {
+	c.keys.Store(ks)
+}
+
+func (c *SessionJwtConverter) KeySet() *KeySet {
+	return c.keys.Load()
+}
+
+// Refresh loads the KeySet from src and uses it for all following calls
+func (c *SessionJwtConverter) Refresh(ctx context.Context, src KeySetSource) error {
+	ks, err := src(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to load session key set: %w", err)
+	}
+
+	prev := c.keys.Swap(ks)
+	if prev == nil || prev.activeKid != ks.activeKid {
+		slog.InfoContext(ctx, "session signing key changed", slog.String("session.jwt.kid", ks.activeKid))
+	}
+
+	return nil
+}
+
+// RefreshEvery calls Refresh every interval until the context is done.
+// Failures are logged; the previous KeySet stays in use until a refresh succeeds.
+func (c *SessionJwtConverter) RefreshEvery(ctx context.Context, src KeySetSource, interval time.Duration) error {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+
+		if err := c.Refresh(ctx, src); err != nil && ctx.Err() == nil {
+			slog.ErrorContext(ctx, "session key set refresh failed", slog.String("error", err.Error()))
 		}
+	}
+}
+
+func (c *SessionJwtConverter) recordKeyUsage(kid, operation string, active bool) {
+	if c.keyUsages == nil {
+		return
+	}
+
+	c.keyUsages.Add(
+		context.Background(),
+		1,
+		metric.WithAttributes(
+			attribute.String("session.jwt.kid", kid),
+			attribute.String("session.jwt.operation", operation),
+			attribute.Bool("session.jwt.kid_active", active),
+		),
+	)
+}
 
+func (c *SessionJwtConverter) ReadJWT(jwtStr string) (SessionJwtClaims, time.Time, error) {
+	ks := c.keys.Load()
+	tk, err := c.parser.Parse(jwtStr, func(tk *jwt.Token) (interface{}, error) {
 		claims, ok := tk.Claims.(jwt.MapClaims)
 		if !ok {
 			return nil, errors.New("expected MapClaims")
@@ -74,12 +175,17 @@ func (c *SessionJwtConverter) ReadJWT(jwtStr string) (SessionJwtClaims, time.Tim
 			return nil, errors.New("kid header not found")
 		}
 
-		pub, ok := c.pub[kid]
+		key, ok := ks.verificationKey(kid, time.Now())
 		if !ok {
 			return nil, errors.New("unknown kid")
 		}
 
-		return pub, nil
+		// the algorithm is bound to the key; a token must never choose how it is verified
+		if tk.Method.Alg() != key.method.Alg() {
+			return nil, fmt.Errorf("unexpected signing method: %v", tk.Header["alg"])
+		}
+
+		return key.Public, nil
 	})
 
 	if err != nil {
@@ -97,6 +203,9 @@ func (c *SessionJwtConverter) ReadJWT(jwtStr string) (SessionJwtClaims, time.Tim
 		return SessionJwtClaims{}, time.Time{}, fmt.Errorf("iat claim could not be read: %w", err)
 	}
 
+	kid := tk.Header["kid"].(string)
+	c.recordKeyUsage(kid, "read", kid == ks.activeKid)
+
 	return SessionJwtClaims{
 		SessionId:     claims[sessionClaim].(string),
 		EncryptionKey: k,
@@ -104,8 +213,9 @@ func (c *SessionJwtConverter) ReadJWT(jwtStr string) (SessionJwtClaims, time.Tim
 }
 
 func (c *SessionJwtConverter) WriteJWT(claims SessionJwtClaims, exp time.Time) (string, error) {
+	key := c.keys.Load().active()
 	now := time.Now()
-	tk := jwt.NewWithClaims(jwt.SigningMethodRS256, sessionJwtClaims{
+	tk := jwt.NewWithClaims(key.method, sessionJwtClaims{
 		Session: claims.SessionId,
 		K:       base64.RawStdEncoding.EncodeToString(claims.EncryptionKey),
 		RegisteredClaims: jwt.RegisteredClaims{
@@ -117,7 +227,13 @@ func (c *SessionJwtConverter) WriteJWT(claims SessionJwtClaims, exp time.Time) (
 			ExpiresAt: jwt.NewNumericDate(exp),
 		},
 	})
-	tk.Header["kid"] = c.kid
+	tk.Header["kid"] = key.Kid
+
+	jwtStr, err := tk.SignedString(key.Private)
+	if err != nil {
+		return "", err
+	}
 
-	return tk.SignedString(c.priv)
+	c.recordKeyUsage(key.Kid, "write", true)
+	return jwtStr, nil
 }
@@ -0,0 +1,430 @@
+package service
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/golang-jwt/jwt/v5"
+	"io"
+	"math/big"
+	"net/http"
+	"os"
+	"time"
+)
+
+// SessionKey is a single key of a KeySet.
+// Supported keys are *rsa.PublicKey (RS256), ed25519.PublicKey (EdDSA) and *ecdsa.PublicKey (ES256, ES384, ES512 depending on the curve).
+// Keys without a private key are only used to verify sessions which were issued before a rotation.
+type SessionKey struct {
+	Kid     string
+	Public  crypto.PublicKey
+	Private crypto.Signer
+	// ExpiresAt ends the grace period of a rotated key; the zero value never expires
+	ExpiresAt time.Time
+	method    jwt.SigningMethod
+}
+
+func (k SessionKey) validAt(t time.Time) bool {
+	return k.ExpiresAt.IsZero() || t.Before(k.ExpiresAt)
+}
+
+// KeySet holds all keys accepted for session verification and the kid of the key used for signing.
+type KeySet struct {
+	activeKid string
+	keys      map[string]SessionKey
+}
+
+func NewKeySet(activeKid string, keys ...SessionKey) (*KeySet, error) {
+	ks := &KeySet{
+		activeKid: activeKid,
+		keys:      make(map[string]SessionKey, len(keys)),
+	}
+
+	for _, k := range keys {
+		if k.Kid == "" {
+			return nil, errors.New("key without kid")
+		} else if _, ok := ks.keys[k.Kid]; ok {
+			return nil, fmt.Errorf("duplicate kid %q", k.Kid)
+		}
+
+		if k.Public == nil && k.Private != nil {
+			k.Public = k.Private.Public()
+		}
+
+		if k.Public == nil {
+			return nil, fmt.Errorf("key %q has no public key", k.Kid)
+		}
+
+		var err error
+		if k.method, err = signingMethod(k.Public); err != nil {
+			return nil, fmt.Errorf("key %q: %w", k.Kid, err)
+		}
+
+		ks.keys[k.Kid] = k
+	}
+
+	if active, ok := ks.keys[activeKid]; !ok {
+		return nil, fmt.Errorf("active kid %q not found", activeKid)
+	} else if active.Private == nil {
+		return nil, fmt.Errorf("active key %q has no private key", activeKid)
+	}
+
+	return ks, nil
+}
+
+func signingMethod(pub crypto.PublicKey) (jwt.SigningMethod, error) {
+	switch pub := pub.(type) {
+	case *rsa.PublicKey:
+		return jwt.SigningMethodRS256, nil
+
+	case ed25519.PublicKey:
+		return jwt.SigningMethodEdDSA, nil
+
+	case *ecdsa.PublicKey:
+		switch pub.Curve {
+		case elliptic.P256():
+			return jwt.SigningMethodES256, nil
+		case elliptic.P384():
+			return jwt.SigningMethodES384, nil
+		case elliptic.P521():
+			return jwt.SigningMethodES512, nil
+		}
+
+		return nil, errors.New("unsupported ecdsa curve")
+	}
+
+	return nil, fmt.Errorf("unsupported key type %T", pub)
+}
+
+func (ks *KeySet) ActiveKid() string {
+	return ks.activeKid
+}
+
+func (ks *KeySet) active() SessionKey {
+	return ks.keys[ks.activeKid]
+}
+
+func (ks *KeySet) verificationKey(kid string, now time.Time) (SessionKey, bool) {
+	k, ok := ks.keys[kid]
+	if !ok || !k.validAt(now) {
+		return SessionKey{}, false
+	}
+
+	return k, true
+}
+
+type jwks struct {
+	Active string `json:"active"`
+	Keys   []jwk  `json:"keys"`
+}
+
+// jwk is a JSON Web Key as defined in RFC 7517/7518.
+// Exp is not part of the RFC: it is the unix time at which the grace period of a rotated key ends.
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use,omitempty"`
+	Alg string `json:"alg,omitempty"`
+	Crv string `json:"crv,omitempty"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+	X   string `json:"x,omitempty"`
+	Y   string `json:"y,omitempty"`
+	D   string `json:"d,omitempty"`
+	P   string `json:"p,omitempty"`
+	Q   string `json:"q,omitempty"`
+	Exp int64  `json:"exp,omitempty"`
+}
+
+// ParseJWKS reads a JWKS document containing RSA, EC (P-256, P-384, P-521) and OKP (Ed25519) keys.
+// In addition to the keys, the document must name the signing key in its "active" member:
+//
+//	{"active": "kid-2", "keys": [{"kty": "RSA", "kid": "kid-1", "n": "...", "e": "AQAB", "exp": 1767225600}, {"kty": "RSA", "kid": "kid-2", "n": "...", "e": "AQAB", "d": "...", "p": "...", "q": "..."}]}
+//
+// Keys with a "use" other than "sig" are ignored.
+func ParseJWKS(b []byte) (*KeySet, error) {
+	active, keys, err := parseJWKSKeys(b)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewKeySet(active, keys...)
+}
+
+func parseJWKSKeys(b []byte) (string, []SessionKey, error) {
+	var doc jwks
+	if err := json.Unmarshal(b, &doc); err != nil {
+		return "", nil, fmt.Errorf("invalid jwks document: %w", err)
+	}
+
+	keys := make([]SessionKey, 0, len(doc.Keys))
+	for _, k := range doc.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+
+		key, err := k.sessionKey()
+		if err != nil {
+			return "", nil, fmt.Errorf("invalid key %q: %w", k.Kid, err)
+		}
+
+		keys = append(keys, key)
+	}
+
+	return doc.Active, keys, nil
+}
+
+func (k jwk) sessionKey() (SessionKey, error) {
+	key := SessionKey{Kid: k.Kid}
+	if k.Exp != 0 {
+		key.ExpiresAt = time.Unix(k.Exp, 0)
+	}
+
+	var err error
+	switch k.Kty {
+	case "RSA":
+		key.Public, key.Private, err = k.rsaKey()
+
+	case "EC":
+		key.Public, key.Private, err = k.ecdsaKey()
+
+	case "OKP":
+		key.Public, key.Private, err = k.ed25519Key()
+
+	default:
+		err = fmt.Errorf("unsupported kty %q", k.Kty)
+	}
+
+	if err != nil {
+		return SessionKey{}, err
+	}
+
+	if key.method, err = signingMethod(key.Public); err != nil {
+		return SessionKey{}, err
+	} else if k.Alg != "" && k.Alg != key.method.Alg() {
+		return SessionKey{}, fmt.Errorf("alg %q does not match the key, expected %q", k.Alg, key.method.Alg())
+	}
+
+	return key, nil
+}
+
+func (k jwk) rsaKey() (crypto.PublicKey, crypto.Signer, error) {
+	n, err := decodeJWKInt(k.N)
+	if err != nil {
+		return nil, nil, fmt.Errorf("n: %w", err)
+	}
+
+	e, err := decodeJWKInt(k.E)
+	if err != nil {
+		return nil, nil, fmt.Errorf("e: %w", err)
+	} else if !e.IsInt64() || e.Int64() > 1<<31-1 {
+		return nil, nil, errors.New("e: value too large")
+	}
+
+	pub := &rsa.PublicKey{N: n, E: int(e.Int64())}
+	if k.D == "" {
+		return pub, nil, nil
+	}
+
+	d, err := decodeJWKInt(k.D)
+	if err != nil {
+		return nil, nil, fmt.Errorf("d: %w", err)
+	}
+
+	p, err := decodeJWKInt(k.P)
+	if err != nil {
+		return nil, nil, fmt.Errorf("p: %w", err)
+	}
+
+	q, err := decodeJWKInt(k.Q)
+	if err != nil {
+		return nil, nil, fmt.Errorf("q: %w", err)
+	}
+
+	priv := &rsa.PrivateKey{
+		PublicKey: *pub,
+		D:         d,
+		Primes:    []*big.Int{p, q},
+	}
+
+	if err = priv.Validate(); err != nil {
+		return nil, nil, err
+	}
+
+	priv.Precompute()
+	return pub, priv, nil
+}
+
+func (k jwk) ecdsaKey() (crypto.PublicKey, crypto.Signer, error) {
+	var curve elliptic.Curve
+	switch k.Crv {
+	case "P-256":
+		curve = elliptic.P256()
+	case "P-384":
+		curve = elliptic.P384()
+	case "P-521":
+		curve = elliptic.P521()
+	default:
+		return nil, nil, fmt.Errorf("unsupported crv %q", k.Crv)
+	}
+
+	x, err := decodeJWKInt(k.X)
+	if err != nil {
+		return nil, nil, fmt.Errorf("x: %w", err)
+	}
+
+	y, err := decodeJWKInt(k.Y)
+	if err != nil {
+		return nil, nil, fmt.Errorf("y: %w", err)
+	}
+
+	pub := &ecdsa.PublicKey{Curve: curve, X: x, Y: y}
+	pubECDH, err := pub.ECDH()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if k.D == "" {
+		return pub, nil, nil
+	}
+
+	d, err := decodeJWKInt(k.D)
+	if err != nil {
+		return nil, nil, fmt.Errorf("d: %w", err)
+	}
+
+	priv := &ecdsa.PrivateKey{PublicKey: *pub, D: d}
+	privECDH, err := priv.ECDH()
+	if err != nil {
+		return nil, nil, err
+	} else if !privECDH.PublicKey().Equal(pubECDH) {
+		return nil, nil, errors.New("private key does not match the public key")
+	}
+
+	return pub, priv, nil
+}
+
+func (k jwk) ed25519Key() (crypto.PublicKey, crypto.Signer, error) {
+	if k.Crv != "Ed25519" {
+		return nil, nil, fmt.Errorf("unsupported crv %q", k.Crv)
+	}
+
+	x, err := base64.RawURLEncoding.DecodeString(k.X)
+	if err != nil {
+		return nil, nil, fmt.Errorf("x: %w", err)
+	} else if len(x) != ed25519.PublicKeySize {
+		return nil, nil, errors.New("x: invalid length")
+	}
+
+	pub := ed25519.PublicKey(x)
+	if k.D == "" {
+		return pub, nil, nil
+	}
+
+	d, err := base64.RawURLEncoding.DecodeString(k.D)
+	if err != nil {
+		return nil, nil, fmt.Errorf("d: %w", err)
+	} else if len(d) != ed25519.SeedSize {
+		return nil, nil, errors.New("d: invalid length")
+	}
+
+	priv := ed25519.NewKeyFromSeed(d)
+	if !pub.Equal(priv.Public()) {
+		return nil, nil, errors.New("private key does not match the public key")
+	}
+
+	return pub, priv, nil
+}
+
+func decodeJWKInt(s string) (*big.Int, error) {
+	if s == "" {
+		return nil, errors.New("missing value")
+	}
+
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+
+	return new(big.Int).SetBytes(b), nil
+}
+
+// KeySetSource loads the current KeySet, e.g. from a JWKS document stored in a file.
+// A KeySet contains the private signing key, so documents must only be loaded from private storage,
+// except for the public verification keys loaded by KeySetFromURL.
+type KeySetSource func(ctx context.Context) (*KeySet, error)
+
+func KeySetFromFile(path string) KeySetSource {
+	return func(ctx context.Context) (*KeySet, error) {
+		b, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+
+		return ParseJWKS(b)
+	}
+}
+
+// KeySetFromURL loads the public verification keys from the JWKS document served at url and adds them to the keys of signing.
+// The signing key and the active kid are always taken from signing; the "active" member of the public document is ignored.
+// The public document must not contain any private key.
+func KeySetFromURL(httpClient *http.Client, url string, signing KeySetSource) KeySetSource {
+	return func(ctx context.Context) (*KeySet, error) {
+		signingKs, err := signing(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load signing key: %w", err)
+		}
+
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+		if err != nil {
+			return nil, err
+		}
+
+		req.Header.Set("Accept", "application/json")
+
+		res, err := httpClient.Do(req)
+		if err != nil {
+			return nil, err
+		}
+
+		defer res.Body.Close()
+
+		if res.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("unexpected status code loading jwks: %d", res.StatusCode)
+		}
+
+		b, err := io.ReadAll(res.Body)
+		if err != nil {
+			return nil, err
+		}
+
+		_, public, err := parseJWKSKeys(b)
+		if err != nil {
+			return nil, err
+		}
+
+		keys := make([]SessionKey, 0, len(signingKs.keys)+len(public))
+		for _, k := range signingKs.keys {
+			keys = append(keys, k)
+		}
+
+		for _, k := range public {
+			if k.Private != nil {
+				return nil, fmt.Errorf("public jwks document contains the private key %q", k.Kid)
+			}
+
+			if _, ok := signingKs.keys[k.Kid]; !ok {
+				keys = append(keys, k)
+			}
+		}
+
+		return NewKeySet(signingKs.activeKid, keys...)
+	}
+}
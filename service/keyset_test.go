@@ -0,0 +1,418 @@
+package service
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/assert"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestParseJWKS(t *testing.T) {
+	kidA, privA, _ := newRandomKeys(t)
+	kidB, privB, _ := newRandomKeys(t)
+	exp := time.Now().Add(time.Hour).Truncate(time.Second)
+
+	t.Run("valid", func(t *testing.T) {
+		ks, err := ParseJWKS(newJWKS(t, kidB, rsaJWK(kidA, privA, false, exp), rsaJWK(kidB, privB, true, time.Time{})))
+		if !assert.NoError(t, err) {
+			return
+		}
+
+		assert.Equal(t, kidB, ks.ActiveKid())
+		assert.True(t, privB.Equal(ks.active().Private))
+
+		k, ok := ks.verificationKey(kidA, time.Now())
+		if assert.True(t, ok) {
+			assert.True(t, privA.PublicKey.Equal(k.Public))
+			assert.Nil(t, k.Private)
+			assert.Equal(t, exp, k.ExpiresAt)
+		}
+
+		_, ok = ks.verificationKey(kidA, exp)
+		assert.False(t, ok)
+	})
+
+	t.Run("encryption keys are ignored", func(t *testing.T) {
+		enc := rsaJWK(kidA, privA, false, time.Time{})
+		enc["use"] = "enc"
+
+		ks, err := ParseJWKS(newJWKS(t, kidB, enc, rsaJWK(kidB, privB, true, time.Time{})))
+		if assert.NoError(t, err) {
+			_, ok := ks.verificationKey(kidA, time.Now())
+			assert.False(t, ok)
+		}
+	})
+
+	t.Run("unknown active kid", func(t *testing.T) {
+		_, err := ParseJWKS(newJWKS(t, "other", rsaJWK(kidA, privA, true, time.Time{})))
+		assert.ErrorContains(t, err, "active kid")
+	})
+
+	t.Run("active key without private key", func(t *testing.T) {
+		_, err := ParseJWKS(newJWKS(t, kidA, rsaJWK(kidA, privA, false, time.Time{})))
+		assert.ErrorContains(t, err, "has no private key")
+	})
+
+	t.Run("unsupported kty", func(t *testing.T) {
+		k := rsaJWK(kidA, privA, true, time.Time{})
+		k["kty"] = "oct"
+
+		_, err := ParseJWKS(newJWKS(t, kidA, k))
+		assert.ErrorContains(t, err, "unsupported kty")
+	})
+
+	t.Run("mismatching private key", func(t *testing.T) {
+		k := rsaJWK(kidA, privA, true, time.Time{})
+		k["d"] = base64.RawURLEncoding.EncodeToString(privB.D.Bytes())
+
+		_, err := ParseJWKS(newJWKS(t, kidA, k))
+		assert.Error(t, err)
+	})
+}
+
+func TestParseJWKS_MixedKeyTypes(t *testing.T) {
+	kidRSA, privRSA, _ := newRandomKeys(t)
+	privEd := newEd25519Key(t)
+	privEC := newECDSAKey(t, elliptic.P384())
+
+	ks, err := ParseJWKS(newJWKS(
+		t,
+		"ed",
+		rsaJWK(kidRSA, privRSA, false, time.Time{}),
+		ed25519JWK("ed", privEd, true),
+		ecdsaJWK("ec", privEC, false),
+	))
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	assert.Equal(t, jwt.SigningMethodEdDSA, ks.active().method)
+	assert.True(t, privEd.Equal(ks.active().Private))
+
+	k, ok := ks.verificationKey("ec", time.Now())
+	if assert.True(t, ok) {
+		assert.Equal(t, jwt.SigningMethodES384, k.method)
+		assert.True(t, privEC.PublicKey.Equal(k.Public))
+	}
+
+	t.Run("alg does not match key", func(t *testing.T) {
+		k := ecdsaJWK("ec", privEC, true)
+		k["alg"] = "ES256"
+
+		_, err := ParseJWKS(newJWKS(t, "ec", k))
+		assert.ErrorContains(t, err, "does not match the key")
+	})
+
+	t.Run("mismatching ed25519 private key", func(t *testing.T) {
+		k := ed25519JWK("ed", privEd, true)
+		k["d"] = base64.RawURLEncoding.EncodeToString(newEd25519Key(t).Seed())
+
+		_, err := ParseJWKS(newJWKS(t, "ed", k))
+		assert.ErrorContains(t, err, "does not match the public key")
+	})
+
+	t.Run("ecdsa point not on curve", func(t *testing.T) {
+		k := ecdsaJWK("ec", privEC, false)
+		k["y"] = base64.RawURLEncoding.EncodeToString(privEC.X.Bytes())
+
+		_, err := ParseJWKS(newJWKS(t, kidRSA, k, rsaJWK(kidRSA, privRSA, true, time.Time{})))
+		assert.Error(t, err)
+	})
+}
+
+func TestSessionJwtConverter_MixedKeyTypes(t *testing.T) {
+	kidRSA, privRSA, _ := newRandomKeys(t)
+	privEd := newEd25519Key(t)
+	privES256 := newECDSAKey(t, elliptic.P256())
+	privES512 := newECDSAKey(t, elliptic.P521())
+
+	keys := []SessionKey{
+		{Kid: kidRSA, Private: privRSA},
+		{Kid: "ed", Private: privEd},
+		{Kid: "es256", Private: privES256},
+		{Kid: "es512", Private: privES512},
+	}
+
+	convs := make(map[string]*SessionJwtConverter, len(keys))
+	for _, k := range keys {
+		ks, err := NewKeySet(k.Kid, keys...)
+		if !assert.NoError(t, err) {
+			return
+		}
+
+		convs[k.Kid] = NewSessionJwtConverterFromKeySet(ks)
+	}
+
+	for writer, convA := range convs {
+		for reader, convB := range convs {
+			t.Run(fmt.Sprintf("write %s, read %s", writer, reader), func(t *testing.T) {
+				testReadWrite(t, convA, convB)
+			})
+		}
+	}
+
+	t.Run("algorithm confusion", func(t *testing.T) {
+		// a token which claims to be signed by the ed25519 key using another algorithm
+		tk := jwt.NewWithClaims(jwt.SigningMethodES256, sessionJwtClaims{
+			Session: "test",
+			K:       base64.RawStdEncoding.EncodeToString([]byte{1, 2, 3}),
+			RegisteredClaims: jwt.RegisteredClaims{
+				Issuer:    issuer,
+				IssuedAt:  jwt.NewNumericDate(time.Now()),
+				ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Minute)),
+			},
+		})
+		tk.Header["kid"] = "ed"
+
+		jwtStr, err := tk.SignedString(privES256)
+		if !assert.NoError(t, err) {
+			return
+		}
+
+		_, _, err = convs["ed"].ReadJWT(jwtStr)
+		assert.ErrorContains(t, err, "unexpected signing method")
+	})
+}
+
+func TestSessionJwtConverter_Rotation(t *testing.T) {
+	kidA, privA, _ := newRandomKeys(t)
+	kidB, privB, _ := newRandomKeys(t)
+
+	before, err := NewKeySet(kidA, SessionKey{Kid: kidA, Private: privA})
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	conv := NewSessionJwtConverterFromKeySet(before)
+	claims := SessionJwtClaims{SessionId: "test", EncryptionKey: []byte{1, 2, 3}}
+
+	jwtA, err := conv.WriteJWT(claims, time.Now().Add(time.Minute))
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	t.Run("grace period", func(t *testing.T) {
+		rotated, err := NewKeySet(kidB, SessionKey{Kid: kidA, Public: &privA.PublicKey, ExpiresAt: time.Now().Add(time.Hour)}, SessionKey{Kid: kidB, Private: privB})
+		if !assert.NoError(t, err) {
+			return
+		}
+
+		conv.SetKeySet(rotated)
+
+		_, _, err = conv.ReadJWT(jwtA)
+		assert.NoError(t, err)
+
+		jwtB, err := conv.WriteJWT(claims, time.Now().Add(time.Minute))
+		if assert.NoError(t, err) {
+			_, _, err = conv.ReadJWT(jwtB)
+			assert.NoError(t, err)
+
+			// sessions signed with the new key are not accepted by instances which did not refresh yet
+			_, _, err = NewSessionJwtConverterFromKeySet(before).ReadJWT(jwtB)
+			assert.Error(t, err)
+		}
+	})
+
+	t.Run("grace period ended", func(t *testing.T) {
+		rotated, err := NewKeySet(kidB, SessionKey{Kid: kidA, Public: &privA.PublicKey, ExpiresAt: time.Now().Add(-time.Second)}, SessionKey{Kid: kidB, Private: privB})
+		if !assert.NoError(t, err) {
+			return
+		}
+
+		conv.SetKeySet(rotated)
+
+		_, _, err = conv.ReadJWT(jwtA)
+		assert.ErrorContains(t, err, "unknown kid")
+	})
+}
+
+func TestSessionJwtConverter_Refresh(t *testing.T) {
+	kidA, privA, _ := newRandomKeys(t)
+	kidB, privB, _ := newRandomKeys(t)
+
+	path := filepath.Join(t.TempDir(), "jwks.json")
+	if !assert.NoError(t, os.WriteFile(path, newJWKS(t, kidA, rsaJWK(kidA, privA, true, time.Time{})), 0o600)) {
+		return
+	}
+
+	ctx := context.Background()
+	conv := newRandomConverter(t)
+
+	if assert.NoError(t, conv.Refresh(ctx, KeySetFromFile(path))) {
+		assert.Equal(t, kidA, conv.KeySet().ActiveKid())
+	}
+
+	if !assert.NoError(t, os.WriteFile(path, newJWKS(t, kidB, rsaJWK(kidA, privA, false, time.Time{}), rsaJWK(kidB, privB, true, time.Time{})), 0o600)) {
+		return
+	}
+
+	if assert.NoError(t, conv.Refresh(ctx, KeySetFromFile(path))) {
+		assert.Equal(t, kidB, conv.KeySet().ActiveKid())
+	}
+
+	// a failed refresh keeps the previous keys
+	if !assert.NoError(t, os.Remove(path)) {
+		return
+	}
+
+	assert.Error(t, conv.Refresh(ctx, KeySetFromFile(path)))
+	assert.Equal(t, kidB, conv.KeySet().ActiveKid())
+}
+
+func TestKeySetFromURL(t *testing.T) {
+	kidA, privA, _ := newRandomKeys(t)
+	kidB, privB, _ := newRandomKeys(t)
+	kidC, privC, _ := newRandomKeys(t)
+
+	path := filepath.Join(t.TempDir(), "signing.json")
+	if !assert.NoError(t, os.WriteFile(path, newJWKS(t, kidB, rsaJWK(kidB, privB, true, time.Time{})), 0o600)) {
+		return
+	}
+
+	var public []byte
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if public == nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		_, _ = w.Write(public)
+	}))
+	defer srv.Close()
+
+	ctx := context.Background()
+	src := KeySetFromURL(srv.Client(), srv.URL, KeySetFromFile(path))
+
+	t.Run("valid", func(t *testing.T) {
+		public = newJWKS(t, kidA, rsaJWK(kidA, privA, false, time.Time{}), rsaJWK(kidB, privB, false, time.Time{}))
+
+		ks, err := src(ctx)
+		if !assert.NoError(t, err) {
+			return
+		}
+
+		assert.Equal(t, kidB, ks.ActiveKid())
+		assert.NotNil(t, ks.active().Private)
+
+		_, ok := ks.verificationKey(kidA, time.Now())
+		assert.True(t, ok)
+	})
+
+	t.Run("private key in public document", func(t *testing.T) {
+		public = newJWKS(t, kidC, rsaJWK(kidC, privC, true, time.Time{}))
+
+		_, err := src(ctx)
+		assert.ErrorContains(t, err, "contains the private key")
+	})
+
+	t.Run("unavailable", func(t *testing.T) {
+		public = nil
+
+		_, err := src(ctx)
+		assert.ErrorContains(t, err, "unexpected status code")
+	})
+}
+
+func rsaJWK(kid string, priv *rsa.PrivateKey, withPrivate bool, exp time.Time) map[string]any {
+	enc := func(v *big.Int) string {
+		return base64.RawURLEncoding.EncodeToString(v.Bytes())
+	}
+
+	k := map[string]any{
+		"kty": "RSA",
+		"kid": kid,
+		"use": "sig",
+		"alg": "RS256",
+		"n":   enc(priv.N),
+		"e":   enc(big.NewInt(int64(priv.E))),
+	}
+
+	if withPrivate {
+		k["d"] = enc(priv.D)
+		k["p"] = enc(priv.Primes[0])
+		k["q"] = enc(priv.Primes[1])
+	}
+
+	if !exp.IsZero() {
+		k["exp"] = exp.Unix()
+	}
+
+	return k
+}
+
+func ed25519JWK(kid string, priv ed25519.PrivateKey, withPrivate bool) map[string]any {
+	k := map[string]any{
+		"kty": "OKP",
+		"kid": kid,
+		"crv": "Ed25519",
+		"x":   base64.RawURLEncoding.EncodeToString(priv.Public().(ed25519.PublicKey)),
+	}
+
+	if withPrivate {
+		k["d"] = base64.RawURLEncoding.EncodeToString(priv.Seed())
+	}
+
+	return k
+}
+
+func ecdsaJWK(kid string, priv *ecdsa.PrivateKey, withPrivate bool) map[string]any {
+	size := (priv.Curve.Params().BitSize + 7) / 8
+	enc := func(v *big.Int) string {
+		return base64.RawURLEncoding.EncodeToString(v.FillBytes(make([]byte, size)))
+	}
+
+	k := map[string]any{
+		"kty": "EC",
+		"kid": kid,
+		"crv": priv.Curve.Params().Name,
+		"x":   enc(priv.X),
+		"y":   enc(priv.Y),
+	}
+
+	if withPrivate {
+		k["d"] = enc(priv.D)
+	}
+
+	return k
+}
+
+func newEd25519Key(t *testing.T) ed25519.PrivateKey {
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate ed25519 key: %v", err)
+	}
+
+	return priv
+}
+
+func newECDSAKey(t *testing.T, curve elliptic.Curve) *ecdsa.PrivateKey {
+	priv, err := ecdsa.GenerateKey(curve, rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate ecdsa key: %v", err)
+	}
+
+	return priv
+}
+
+func newJWKS(t *testing.T, active string, keys ...map[string]any) []byte {
+	b, err := json.Marshal(map[string]any{"active": active, "keys": keys})
+	if err != nil {
+		t.Fatalf("failed to marshal jwks: %v", err)
+	}
+
+	return b
+}
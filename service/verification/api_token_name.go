@@ -0,0 +1,44 @@
+package verification
+
+import (
+	"context"
+	"encoding/base64"
+	"github.com/gw2auth/gw2auth.com-api/service"
+	"github.com/gw2auth/gw2auth.com-api/service/gw2"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// apiTokenNameChallenge requires the user to create an api token with a given name
+type apiTokenNameChallenge struct{}
+
+func (apiTokenNameChallenge) Id() int {
+	return 1
+}
+
+func (apiTokenNameChallenge) RequiredPermissions() []gw2.Permission {
+	return []gw2.Permission{gw2.PermissionAccount}
+}
+
+func (apiTokenNameChallenge) NewState() (string, error) {
+	b, err := service.GenerateRandomBytes(12)
+	if err != nil {
+		return "", err
+	}
+
+	return "GW2Auth-" + base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+func (apiTokenNameChallenge) Evaluate(ctx context.Context, client *gw2.ApiClient, token, state string, startTime time.Time) (bool, error) {
+	tokenInfo, err := client.TokenInfo(ctx, token)
+	if err != nil {
+		return false, err
+	}
+
+	return strings.TrimRightFunc(tokenInfo.Name, unicode.IsSpace) == state, nil
+}
+
+func (apiTokenNameChallenge) Timeout() time.Duration {
+	return 0
+}
@@ -0,0 +1,58 @@
+package verification
+
+import (
+	"context"
+	"fmt"
+	"github.com/gw2auth/gw2auth.com-api/service/gw2"
+	"maps"
+	"slices"
+	"time"
+)
+
+// Challenge describes a way for a user to prove ownership of a gw2account.
+// The state of a challenge is generated (and later parsed) by the challenge itself
+// and stored as-is in the state column of the challenge tables.
+type Challenge interface {
+	// Id is the stable identifier stored as challenge_id. Ids must never be re-used.
+	Id() int
+	RequiredPermissions() []gw2.Permission
+	NewState() (string, error)
+	// Evaluate reports whether the challenge has been fulfilled for the given api token.
+	// startTime is the time at which the user started the challenge.
+	Evaluate(ctx context.Context, client *gw2.ApiClient, token, state string, startTime time.Time) (bool, error)
+	// Timeout is the duration a submitted challenge may stay pending before it is considered failed.
+	// A zero timeout means the challenge must be fulfilled on submission.
+	Timeout() time.Duration
+}
+
+var challenges = make(map[int]Challenge)
+
+func init() {
+	Register(apiTokenNameChallenge{})
+	Register(tpBuyOrderChallenge{})
+	Register(characterNameChallenge{})
+}
+
+// Register adds a challenge to the set of known challenges; it panics if the id is already taken.
+func Register(c Challenge) {
+	if _, ok := challenges[c.Id()]; ok {
+		panic(fmt.Sprintf("verification challenge with id %d registered twice", c.Id()))
+	}
+
+	challenges[c.Id()] = c
+}
+
+func Get(id int) (Challenge, bool) {
+	c, ok := challenges[id]
+	return c, ok
+}
+
+func All() []Challenge {
+	ids := slices.Sorted(maps.Keys(challenges))
+	r := make([]Challenge, 0, len(ids))
+	for _, id := range ids {
+		r = append(r, challenges[id])
+	}
+
+	return r
+}
@@ -0,0 +1,89 @@
+package verification
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/gw2auth/gw2auth.com-api/service/gw2"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestRegistry(t *testing.T) {
+	ids := make([]int, 0)
+	for _, c := range All() {
+		ids = append(ids, c.Id())
+
+		r, ok := Get(c.Id())
+		assert.True(t, ok)
+		assert.Equal(t, c, r)
+	}
+
+	assert.Equal(t, []int{1, 2, 3}, ids)
+	assert.Panics(t, func() {
+		Register(apiTokenNameChallenge{})
+	})
+}
+
+func TestChallenge_NewState(t *testing.T) {
+	for _, c := range All() {
+		state, err := c.NewState()
+		assert.NoError(t, err)
+		assert.NotEmpty(t, state)
+	}
+
+	state, err := tpBuyOrderChallenge{}.NewState()
+	if assert.NoError(t, err) {
+		var s tpBuyOrderState
+		assert.NoError(t, json.Unmarshal([]byte(state), &s))
+		assert.Contains(t, tpBuyOrderItemIds, s.ItemId)
+		assert.True(t, s.Price >= 1 && s.Price <= 300)
+	}
+}
+
+func TestChallenge_Evaluate(t *testing.T) {
+	startTime := time.Date(2025, time.January, 1, 12, 0, 0, 0, time.UTC)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v2/tokeninfo", func(w http.ResponseWriter, req *http.Request) {
+		_ = json.NewEncoder(w).Encode(gw2.TokenInfo{Name: "GW2Auth-abc ", Permissions: []gw2.Permission{gw2.PermissionAccount}})
+	})
+	mux.HandleFunc("/v2/characters", func(w http.ResponseWriter, req *http.Request) {
+		_ = json.NewEncoder(w).Encode([]string{"Some Character", "Abcdefghijkl"})
+	})
+	mux.HandleFunc("/v2/commerce/transactions/current/buys", func(w http.ResponseWriter, req *http.Request) {
+		_ = json.NewEncoder(w).Encode([]gw2.CommerceTransaction{
+			{Id: 1, ItemId: 19697, Price: 42, Quantity: 1, Created: startTime.Add(-time.Minute)},
+			{Id: 2, ItemId: 19680, Price: 17, Quantity: 1, Created: startTime.Add(time.Minute)},
+		})
+	})
+
+	s := httptest.NewServer(mux)
+	defer s.Close()
+
+	client := gw2.NewApiClient(s.Client(), s.URL)
+	tests := []struct {
+		name     string
+		c        Challenge
+		state    string
+		expected bool
+	}{
+		{"api token name matches", apiTokenNameChallenge{}, "GW2Auth-abc", true},
+		{"api token name does not match", apiTokenNameChallenge{}, "GW2Auth-xyz", false},
+		{"character name matches", characterNameChallenge{}, "Abcdefghijkl", true},
+		{"character name does not match", characterNameChallenge{}, "Mnopqrstuvwx", false},
+		{"buy order matches", tpBuyOrderChallenge{}, `{"itemId": 19680, "price": 17}`, true},
+		{"buy order created before start", tpBuyOrderChallenge{}, `{"itemId": 19697, "price": 42}`, false},
+		{"buy order with other price", tpBuyOrderChallenge{}, `{"itemId": 19680, "price": 18}`, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ok, err := tt.c.Evaluate(context.Background(), client, "token", tt.state, startTime)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.expected, ok)
+		})
+	}
+}
@@ -0,0 +1,54 @@
+package verification
+
+import (
+	"context"
+	"encoding/binary"
+	"github.com/gw2auth/gw2auth.com-api/service"
+	"github.com/gw2auth/gw2auth.com-api/service/gw2"
+	"slices"
+	"time"
+	"unicode"
+)
+
+// characterNameChallenge requires the user to create (or rename) a character to a given name
+type characterNameChallenge struct{}
+
+func (characterNameChallenge) Id() int {
+	return 3
+}
+
+func (characterNameChallenge) RequiredPermissions() []gw2.Permission {
+	return []gw2.Permission{gw2.PermissionAccount, gw2.PermissionCharacters}
+}
+
+func (characterNameChallenge) NewState() (string, error) {
+	const length = 12
+	const chars = "abcdefghijklmnopqrstuvwxyz"
+
+	b, err := service.GenerateRandomBytes(length * 4)
+	if err != nil {
+		return "", err
+	}
+
+	r := make([]rune, length)
+	for i := range r {
+		r[i] = rune(chars[binary.BigEndian.Uint32(b[i*4:])%uint32(len(chars))])
+	}
+
+	r[0] = unicode.ToUpper(r[0])
+
+	return string(r), nil
+}
+
+func (characterNameChallenge) Evaluate(ctx context.Context, client *gw2.ApiClient, token, state string, startTime time.Time) (bool, error) {
+	names, err := client.Characters(ctx, token)
+	if err != nil {
+		return false, err
+	}
+
+	return slices.Contains(names, state), nil
+}
+
+func (characterNameChallenge) Timeout() time.Duration {
+	return time.Minute * 15
+}
@@ -0,0 +1,249 @@
+package verification
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	crdbpgx "github.com/cockroachdb/cockroach-go/v2/crdb/crdbpgxv5"
+	"github.com/gofrs/uuid/v5"
+	"github.com/gw2auth/gw2auth.com-api/service/accountlog"
+	"github.com/gw2auth/gw2auth.com-api/service/gw2"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"log/slog"
+	"slices"
+	"time"
+)
+
+const (
+	defaultPendingBatchSize = 10
+	pendingClaimLease       = 5 * time.Minute
+)
+
+type pendingChallenge struct {
+	AccountId    uuid.UUID
+	Gw2AccountId uuid.UUID
+	ChallengeId  int
+	State        string
+	Gw2ApiToken  string
+	CreationTime time.Time
+	ClaimedUntil time.Time
+}
+
+type pendingOutcome int
+
+const (
+	pendingOutcomeKeep pendingOutcome = iota
+	pendingOutcomeVerified
+	pendingOutcomeDiscard
+)
+
+// PendingWorker resolves submitted challenges which could not be fulfilled on submission.
+// Each batch is claimed for pendingClaimLease before it is evaluated and results are only written while the claim is held,
+// so multiple workers may run concurrently without evaluating the same challenge twice.
+type PendingWorker struct {
+	pool      *pgxpool.Pool
+	client    *gw2.ApiClient
+	batchSize int
+}
+
+func NewPendingWorker(pool *pgxpool.Pool, client *gw2.ApiClient) *PendingWorker {
+	return &PendingWorker{
+		pool:      pool,
+		client:    client,
+		batchSize: defaultPendingBatchSize,
+	}
+}
+
+// RunOnce deletes all timed out pending challenges and evaluates each remaining one once.
+func (w *PendingWorker) RunOnce(ctx context.Context) error {
+	if err := w.deleteTimedOut(ctx); err != nil {
+		return err
+	}
+
+	var afterAccountId, afterGw2AccountId uuid.UUID
+	for {
+		n, err := w.processBatch(ctx, &afterAccountId, &afterGw2AccountId)
+		if err != nil {
+			return err
+		}
+
+		if n < w.batchSize {
+			return nil
+		}
+	}
+}
+
+func (w *PendingWorker) deleteTimedOut(ctx context.Context) error {
+	return crdbpgx.ExecuteTx(ctx, w.pool, pgx.TxOptions{}, func(tx pgx.Tx) error {
+		tag, err := tx.Exec(ctx, `DELETE FROM gw2_account_verification_pending_challenges WHERE timeout_time <= NOW()`)
+		if err != nil {
+			return err
+		}
+
+		if tag.RowsAffected() > 0 {
+			slog.InfoContext(ctx, "deleted timed out pending verification challenges", slog.Int64("verification.pending.deleted", tag.RowsAffected()))
+		}
+
+		return nil
+	})
+}
+
+// processBatch claims and evaluates up to batchSize pending challenges following the given key (exclusive)
+// and advances the key to the last row claimed.
+// The challenges are evaluated outside any transaction, so no locks are held while the gw2 api is called.
+func (w *PendingWorker) processBatch(ctx context.Context, afterAccountId, afterGw2AccountId *uuid.UUID) (int, error) {
+	pending, err := w.claimBatch(ctx, *afterAccountId, *afterGw2AccountId)
+	if err != nil {
+		return 0, err
+	}
+
+	outcomes := make([]pendingOutcome, len(pending))
+	for i, p := range pending {
+		outcomes[i] = w.evaluate(ctx, p)
+	}
+
+	err = crdbpgx.ExecuteTx(ctx, w.pool, pgx.TxOptions{}, func(tx pgx.Tx) error {
+		for i, p := range pending {
+			// the challenge might have been cancelled or replaced during the evaluation, or the claim might have expired
+			sql := `
+DELETE FROM gw2_account_verification_pending_challenges
+WHERE account_id = $1
+AND gw2_account_id = $2
+AND claimed_until = $3
+`
+			if outcomes[i] == pendingOutcomeKeep {
+				// release the claim, so the challenge is evaluated again on the next run
+				sql = `
+UPDATE gw2_account_verification_pending_challenges
+SET claimed_until = NULL
+WHERE account_id = $1
+AND gw2_account_id = $2
+AND claimed_until = $3
+`
+			}
+
+			tag, err := tx.Exec(ctx, sql, p.AccountId, p.Gw2AccountId, p.ClaimedUntil)
+			if err != nil {
+				return err
+			}
+
+			if outcomes[i] == pendingOutcomeVerified && tag.RowsAffected() > 0 {
+				if err = MarkVerified(ctx, tx, p.AccountId, p.Gw2AccountId); err != nil {
+					return err
+				}
+
+				err = accountlog.Write(ctx, tx, p.AccountId, accountlog.TypeVerificationCompleted, accountlog.Fields{
+					"challengeId":  p.ChallengeId,
+					"gw2AccountId": p.Gw2AccountId,
+				})
+
+				if err != nil {
+					return err
+				}
+			}
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		return 0, err
+	}
+
+	if len(pending) > 0 {
+		last := pending[len(pending)-1]
+		*afterAccountId, *afterGw2AccountId = last.AccountId, last.Gw2AccountId
+	}
+
+	return len(pending), nil
+}
+
+// claimBatch claims up to batchSize unclaimed pending challenges following the given key (exclusive), ordered by key.
+// Rows locked by a concurrent claim are skipped.
+func (w *PendingWorker) claimBatch(ctx context.Context, afterAccountId, afterGw2AccountId uuid.UUID) ([]pendingChallenge, error) {
+	var pending []pendingChallenge
+	err := crdbpgx.ExecuteTx(ctx, w.pool, pgx.TxOptions{}, func(tx pgx.Tx) error {
+		const sql = `
+UPDATE gw2_account_verification_pending_challenges
+SET claimed_until = NOW() + $4::INTERVAL
+WHERE (account_id, gw2_account_id) IN (
+	SELECT account_id, gw2_account_id
+	FROM gw2_account_verification_pending_challenges
+	WHERE (account_id, gw2_account_id) > ($1, $2)
+	AND timeout_time > NOW()
+	AND (claimed_until IS NULL OR claimed_until < NOW())
+	ORDER BY account_id, gw2_account_id
+	LIMIT $3
+	FOR UPDATE SKIP LOCKED
+)
+RETURNING account_id, gw2_account_id, challenge_id, state, gw2_api_token, creation_time, claimed_until
+`
+		rows, err := tx.Query(ctx, sql, afterAccountId, afterGw2AccountId, w.batchSize, pendingClaimLease)
+		if err != nil {
+			return err
+		}
+
+		pending, err = pgx.CollectRows(rows, func(row pgx.CollectableRow) (pendingChallenge, error) {
+			var p pendingChallenge
+			return p, row.Scan(
+				&p.AccountId,
+				&p.Gw2AccountId,
+				&p.ChallengeId,
+				&p.State,
+				&p.Gw2ApiToken,
+				&p.CreationTime,
+				&p.ClaimedUntil,
+			)
+		})
+
+		return err
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	// RETURNING does not preserve the order of the subquery
+	slices.SortFunc(pending, func(a, b pendingChallenge) int {
+		if c := bytes.Compare(a.AccountId.Bytes(), b.AccountId.Bytes()); c != 0 {
+			return c
+		}
+
+		return bytes.Compare(a.Gw2AccountId.Bytes(), b.Gw2AccountId.Bytes())
+	})
+
+	return pending, nil
+}
+
+func (w *PendingWorker) evaluate(ctx context.Context, p pendingChallenge) pendingOutcome {
+	logAttrs := []any{
+		slog.String("account.id", p.AccountId.String()),
+		slog.String("gw2account.id", p.Gw2AccountId.String()),
+		slog.Int("verification.challenge.id", p.ChallengeId),
+	}
+
+	challenge, ok := Get(p.ChallengeId)
+	if !ok {
+		slog.WarnContext(ctx, "discarding pending verification challenge with unknown challenge id", logAttrs...)
+		return pendingOutcomeDiscard
+	}
+
+	isSuccess, err := challenge.Evaluate(ctx, w.client, p.Gw2ApiToken, p.State, p.CreationTime)
+	if err != nil {
+		if errors.Is(err, gw2.ErrInvalidApiToken) {
+			slog.InfoContext(ctx, "discarding pending verification challenge with invalid api token", logAttrs...)
+			return pendingOutcomeDiscard
+		}
+
+		slog.WarnContext(ctx, "failed to evaluate pending verification challenge", append(logAttrs, slog.String("error", err.Error()))...)
+		return pendingOutcomeKeep
+	}
+
+	if !isSuccess {
+		return pendingOutcomeKeep
+	}
+
+	slog.InfoContext(ctx, "pending verification challenge succeeded", logAttrs...)
+	return pendingOutcomeVerified
+}
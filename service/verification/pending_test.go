@@ -0,0 +1,154 @@
+package verification
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/gw2auth/gw2auth.com-api/internal/test"
+	"github.com/gw2auth/gw2auth.com-api/service"
+	"github.com/gw2auth/gw2auth.com-api/service/gw2"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestPendingWorkerAll(t *testing.T) {
+	test.RunAll(t, dbScope, map[string]map[string]test.Fn{
+		"RunOnce": {
+			"success":       testPendingWorkerRunOnceSuccess,
+			"not fulfilled": testPendingWorkerRunOnceNotFulfilled,
+			"invalid token": testPendingWorkerRunOnceInvalidToken,
+			"timed out":     testPendingWorkerRunOnceTimedOut,
+			"cancelled":     testPendingWorkerRunOnceCancelled,
+			"claimed":       testPendingWorkerRunOnceClaimed,
+		},
+	})
+}
+
+func testPendingWorkerRunOnceSuccess(t *testing.T, pool *pgxpool.Pool, conv *service.SessionJwtConverter, truncateTablesFn func() error) {
+	accountId, gw2AccountId, otherAccountId := test.NewUUID(t), test.NewUUID(t), test.NewUUID(t)
+	test.CreateAccount(t, pool, accountId, time.Now())
+	test.CreateAccount(t, pool, otherAccountId, time.Now())
+	test.CreateGw2Account(t, pool, accountId, gw2AccountId, "Felix.9127", "Felix")
+	test.CreateGw2Account(t, pool, otherAccountId, gw2AccountId, "Felix.9127", "Felix")
+	test.CreateGw2ApiToken(t, pool, otherAccountId, gw2AccountId, "otherApiToken", []gw2.Permission{gw2.PermissionAccount})
+	test.CreateGw2AccountVerification(t, pool, otherAccountId, gw2AccountId)
+	test.CreateGw2AccountVerificationPendingChallenge(t, pool, accountId, gw2AccountId, 3, "Abcdefghijkl", "testApiToken", time.Now(), time.Now().Add(time.Minute))
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v2/characters", func(w http.ResponseWriter, req *http.Request) {
+		_ = json.NewEncoder(w).Encode([]string{"Some Character", "Abcdefghijkl"})
+	})
+
+	assert.NoError(t, test.WithGw2ApiClient(mux, func(gw2ApiClient *gw2.ApiClient) error {
+		return NewPendingWorker(pool, gw2ApiClient).RunOnce(context.Background())
+	}))
+
+	test.MustNotExist(t, pool, `SELECT TRUE FROM gw2_account_verification_pending_challenges WHERE account_id = $1`, accountId)
+	test.MustExist(t, pool, `SELECT TRUE FROM gw2_account_verifications WHERE account_id = $1 AND gw2_account_id = $2`, accountId, gw2AccountId)
+	test.MustNotExist(t, pool, `SELECT TRUE FROM gw2_account_api_tokens WHERE account_id = $1`, otherAccountId)
+	test.MustExist(t, pool, `SELECT TRUE FROM account_logs WHERE account_id = $1 AND type = 'verification_completed'`, accountId)
+}
+
+func testPendingWorkerRunOnceCancelled(t *testing.T, pool *pgxpool.Pool, conv *service.SessionJwtConverter, truncateTablesFn func() error) {
+	accountId, gw2AccountId := test.NewUUID(t), test.NewUUID(t)
+	test.CreateAccount(t, pool, accountId, time.Now())
+	test.CreateGw2Account(t, pool, accountId, gw2AccountId, "Felix.9127", "Felix")
+	test.CreateGw2AccountVerificationPendingChallenge(t, pool, accountId, gw2AccountId, 3, "Abcdefghijkl", "testApiToken", time.Now(), time.Now().Add(time.Minute))
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v2/characters", func(w http.ResponseWriter, req *http.Request) {
+		// the challenge is cancelled while it is being evaluated
+		test.MustExec(t, pool, `DELETE FROM gw2_account_verification_pending_challenges WHERE account_id = $1`, accountId)
+		_ = json.NewEncoder(w).Encode([]string{"Some Character", "Abcdefghijkl"})
+	})
+
+	assert.NoError(t, test.WithGw2ApiClient(mux, func(gw2ApiClient *gw2.ApiClient) error {
+		return NewPendingWorker(pool, gw2ApiClient).RunOnce(context.Background())
+	}))
+
+	test.MustNotExist(t, pool, `SELECT TRUE FROM gw2_account_verifications WHERE gw2_account_id = $1`, gw2AccountId)
+}
+
+func testPendingWorkerRunOnceClaimed(t *testing.T, pool *pgxpool.Pool, conv *service.SessionJwtConverter, truncateTablesFn func() error) {
+	accountId, gw2AccountId := test.NewUUID(t), test.NewUUID(t)
+	test.CreateAccount(t, pool, accountId, time.Now())
+	test.CreateGw2Account(t, pool, accountId, gw2AccountId, "Felix.9127", "Felix")
+	test.CreateGw2AccountVerificationPendingChallenge(t, pool, accountId, gw2AccountId, 3, "Abcdefghijkl", "testApiToken", time.Now(), time.Now().Add(time.Minute))
+
+	// claimed by another worker
+	test.MustExec(t, pool, `UPDATE gw2_account_verification_pending_challenges SET claimed_until = NOW() + INTERVAL '1 minute' WHERE account_id = $1`, accountId)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v2/characters", func(w http.ResponseWriter, req *http.Request) {
+		t.Error("claimed challenge must not be evaluated")
+		_ = json.NewEncoder(w).Encode([]string{"Abcdefghijkl"})
+	})
+
+	assert.NoError(t, test.WithGw2ApiClient(mux, func(gw2ApiClient *gw2.ApiClient) error {
+		return NewPendingWorker(pool, gw2ApiClient).RunOnce(context.Background())
+	}))
+
+	test.MustExist(t, pool, `SELECT TRUE FROM gw2_account_verification_pending_challenges WHERE account_id = $1`, accountId)
+	test.MustNotExist(t, pool, `SELECT TRUE FROM gw2_account_verifications WHERE gw2_account_id = $1`, gw2AccountId)
+}
+
+func testPendingWorkerRunOnceNotFulfilled(t *testing.T, pool *pgxpool.Pool, conv *service.SessionJwtConverter, truncateTablesFn func() error) {
+	accountId, gw2AccountId := test.NewUUID(t), test.NewUUID(t)
+	test.CreateAccount(t, pool, accountId, time.Now())
+	test.CreateGw2Account(t, pool, accountId, gw2AccountId, "Felix.9127", "Felix")
+	test.CreateGw2AccountVerificationPendingChallenge(t, pool, accountId, gw2AccountId, 3, "Abcdefghijkl", "testApiToken", time.Now(), time.Now().Add(time.Minute))
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v2/characters", func(w http.ResponseWriter, req *http.Request) {
+		_ = json.NewEncoder(w).Encode([]string{"Some Character"})
+	})
+
+	assert.NoError(t, test.WithGw2ApiClient(mux, func(gw2ApiClient *gw2.ApiClient) error {
+		return NewPendingWorker(pool, gw2ApiClient).RunOnce(context.Background())
+	}))
+
+	// the claim is released, so the challenge is evaluated again on the next run
+	test.MustExist(t, pool, `SELECT TRUE FROM gw2_account_verification_pending_challenges WHERE account_id = $1 AND claimed_until IS NULL`, accountId)
+	test.MustNotExist(t, pool, `SELECT TRUE FROM gw2_account_verifications WHERE gw2_account_id = $1`, gw2AccountId)
+}
+
+func testPendingWorkerRunOnceInvalidToken(t *testing.T, pool *pgxpool.Pool, conv *service.SessionJwtConverter, truncateTablesFn func() error) {
+	accountId, gw2AccountId := test.NewUUID(t), test.NewUUID(t)
+	test.CreateAccount(t, pool, accountId, time.Now())
+	test.CreateGw2Account(t, pool, accountId, gw2AccountId, "Felix.9127", "Felix")
+	test.CreateGw2AccountVerificationPendingChallenge(t, pool, accountId, gw2AccountId, 3, "Abcdefghijkl", "testApiToken", time.Now(), time.Now().Add(time.Minute))
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v2/characters", func(w http.ResponseWriter, req *http.Request) {
+		w.WriteHeader(http.StatusUnauthorized)
+		_, _ = w.Write([]byte(`{"text":"Invalid access token"}`))
+	})
+
+	assert.NoError(t, test.WithGw2ApiClient(mux, func(gw2ApiClient *gw2.ApiClient) error {
+		return NewPendingWorker(pool, gw2ApiClient).RunOnce(context.Background())
+	}))
+
+	test.MustNotExist(t, pool, `SELECT TRUE FROM gw2_account_verification_pending_challenges WHERE account_id = $1`, accountId)
+	test.MustNotExist(t, pool, `SELECT TRUE FROM gw2_account_verifications WHERE gw2_account_id = $1`, gw2AccountId)
+}
+
+func testPendingWorkerRunOnceTimedOut(t *testing.T, pool *pgxpool.Pool, conv *service.SessionJwtConverter, truncateTablesFn func() error) {
+	accountId, gw2AccountId := test.NewUUID(t), test.NewUUID(t)
+	test.CreateAccount(t, pool, accountId, time.Now())
+	test.CreateGw2Account(t, pool, accountId, gw2AccountId, "Felix.9127", "Felix")
+	test.CreateGw2AccountVerificationPendingChallenge(t, pool, accountId, gw2AccountId, 3, "Abcdefghijkl", "testApiToken", time.Now().Add(-time.Hour), time.Now().Add(-time.Minute))
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v2/characters", func(w http.ResponseWriter, req *http.Request) {
+		_ = json.NewEncoder(w).Encode([]string{"Abcdefghijkl"})
+	})
+
+	assert.NoError(t, test.WithGw2ApiClient(mux, func(gw2ApiClient *gw2.ApiClient) error {
+		return NewPendingWorker(pool, gw2ApiClient).RunOnce(context.Background())
+	}))
+
+	test.MustNotExist(t, pool, `SELECT TRUE FROM gw2_account_verification_pending_challenges WHERE account_id = $1`, accountId)
+	test.MustNotExist(t, pool, `SELECT TRUE FROM gw2_account_verifications WHERE gw2_account_id = $1`, gw2AccountId)
+}
@@ -0,0 +1,75 @@
+package verification
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"github.com/gw2auth/gw2auth.com-api/service"
+	"github.com/gw2auth/gw2auth.com-api/service/gw2"
+	"time"
+)
+
+// cheap items which are always available on the tradingpost
+var tpBuyOrderItemIds = []int{19697, 19680, 19718, 19719, 24290, 19723}
+
+type tpBuyOrderState struct {
+	ItemId int `json:"itemId"`
+	Price  int `json:"price"`
+}
+
+// tpBuyOrderChallenge requires the user to place a buy order for a given item at a given price (in copper)
+type tpBuyOrderChallenge struct{}
+
+func (tpBuyOrderChallenge) Id() int {
+	return 2
+}
+
+func (tpBuyOrderChallenge) RequiredPermissions() []gw2.Permission {
+	return []gw2.Permission{gw2.PermissionAccount, gw2.PermissionTradingpost}
+}
+
+func (tpBuyOrderChallenge) NewState() (string, error) {
+	b, err := service.GenerateRandomBytes(8)
+	if err != nil {
+		return "", err
+	}
+
+	r, err := json.Marshal(tpBuyOrderState{
+		ItemId: tpBuyOrderItemIds[binary.BigEndian.Uint32(b[:4])%uint32(len(tpBuyOrderItemIds))],
+		Price:  int(binary.BigEndian.Uint32(b[4:])%300) + 1,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return string(r), nil
+}
+
+func (tpBuyOrderChallenge) Evaluate(ctx context.Context, client *gw2.ApiClient, token, state string, startTime time.Time) (bool, error) {
+	var s tpBuyOrderState
+	if err := json.Unmarshal([]byte(state), &s); err != nil {
+		return false, err
+	}
+
+	if s.ItemId == 0 || s.Price == 0 {
+		return false, errors.New("invalid tp buy order state")
+	}
+
+	transactions, err := client.CommerceTransactionsCurrentBuys(ctx, token)
+	if err != nil {
+		return false, err
+	}
+
+	for _, tx := range transactions {
+		if tx.ItemId == s.ItemId && tx.Price == s.Price && !tx.Created.Before(startTime.Truncate(time.Second)) {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+func (tpBuyOrderChallenge) Timeout() time.Duration {
+	return time.Minute * 15
+}
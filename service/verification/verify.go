@@ -0,0 +1,24 @@
+package verification
+
+import (
+	"context"
+	"github.com/gofrs/uuid/v5"
+	"github.com/jackc/pgx/v5"
+)
+
+// MarkVerified marks the gw2account as verified for the given account and
+// removes all api tokens of this gw2account from other accounts
+func MarkVerified(ctx context.Context, tx pgx.Tx, accountId, gw2AccountId uuid.UUID) error {
+	sqls := []string{
+		"DELETE FROM gw2_account_api_tokens WHERE gw2_account_id = $1 AND account_id != $2",
+		"INSERT INTO gw2_account_verifications (gw2_account_id, account_id) VALUES ($1, $2) ON CONFLICT (gw2_account_id) DO UPDATE SET account_id = EXCLUDED.account_id",
+	}
+
+	for _, sql := range sqls {
+		if _, err := tx.Exec(ctx, sql, gw2AccountId, accountId); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -1,10 +1,7 @@
 package util
 
 import (
-	"errors"
-	"github.com/jackc/pgx/v5"
 	"github.com/labstack/echo/v4"
-	"net/http"
 )
 
 func EchoAllParams[T any](c echo.Context, fn func(string) (T, error), params ...string) ([]T, error) {
@@ -20,10 +17,8 @@ func EchoAllParams[T any](c echo.Context, fn func(string) (T, error), params ...
 	return r, nil
 }
 
+// NewEchoPgxHTTPError maps errors returned from database operations; see ErrorFrom
 func NewEchoPgxHTTPError(err error) *echo.HTTPError {
-	if errors.Is(err, pgx.ErrNoRows) {
-		return echo.NewHTTPError(http.StatusNotFound, err)
-	}
-
-	return echo.NewHTTPError(http.StatusInternalServerError, err)
+	e := ErrorFrom(err)
+	return echo.NewHTTPError(e.Status, e)
 }
@@ -0,0 +1,188 @@
+package util
+
+import (
+	"errors"
+	"fmt"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/labstack/echo/v4"
+	"net/http"
+	"strings"
+)
+
+const (
+	ErrCodeInternal            = "internal_server_error"
+	ErrCodeNotFound            = "not_found"
+	ErrCodeConstraintViolation = "constraint_violation"
+	ErrCodeConflict            = "conflict"
+)
+
+// see https://www.postgresql.org/docs/current/errcodes-appendix.html
+const (
+	pgCodeNotNullViolation    = "23502"
+	pgCodeForeignKeyViolation = "23503"
+	pgCodeUniqueViolation     = "23505"
+	pgCodeCheckViolation      = "23514"
+)
+
+// Error is the body of every error response.
+// Code is a stable identifier clients may act on, Message is meant for humans.
+type Error struct {
+	Status    int            `json:"-"`
+	Code      string         `json:"code"`
+	Message   string         `json:"message"`
+	Details   map[string]any `json:"details,omitempty"`
+	RequestId string         `json:"requestId,omitempty"`
+	TraceId   string         `json:"traceId,omitempty"`
+	cause     error
+}
+
+func NewError(status int, code, message string) *Error {
+	return &Error{
+		Status:  status,
+		Code:    code,
+		Message: message,
+	}
+}
+
+// WithDetail returns a copy of the error with the detail added
+func (e *Error) WithDetail(k string, v any) *Error {
+	r := *e
+	r.Details = make(map[string]any, len(e.Details)+1)
+	for dk, dv := range e.Details {
+		r.Details[dk] = dv
+	}
+
+	r.Details[k] = v
+	return &r
+}
+
+// WithCause returns a copy of the error with the given cause; the cause is never rendered to clients
+func (e *Error) WithCause(err error) *Error {
+	r := *e
+	r.cause = err
+	return &r
+}
+
+func (e *Error) Error() string {
+	if e.cause != nil {
+		return fmt.Sprintf("%s (%d): %s: %v", e.Code, e.Status, e.Message, e.cause)
+	}
+
+	return fmt.Sprintf("%s (%d): %s", e.Code, e.Status, e.Message)
+}
+
+func (e *Error) Unwrap() error {
+	return e.cause
+}
+
+// ErrorFrom converts any error returned by a handler into an *Error.
+// Only messages explicitly set as strings are rendered; wrapped errors are kept as the cause and a generic message is used instead.
+func ErrorFrom(err error) *Error {
+	var e *Error
+	if errors.As(err, &e) {
+		return e
+	} else if e = pgxError(err); e != nil {
+		return e
+	}
+
+	var he *echo.HTTPError
+	if !errors.As(err, &he) {
+		return NewError(http.StatusInternalServerError, ErrCodeInternal, "internal server error").WithCause(err)
+	}
+
+	e = NewError(he.Code, CodeFromStatus(he.Code), strings.ToLower(http.StatusText(he.Code)))
+	switch m := he.Message.(type) {
+	case *Error:
+		return m
+
+	case error:
+		if inner := pgxError(m); inner != nil {
+			return inner
+		}
+
+		// wrapped errors may carry parser or driver internals, only explicitly set messages are rendered
+		e = e.WithCause(m)
+
+	case string:
+		e.Message = m
+
+	case map[string]string:
+		if code, ok := m["code"]; ok {
+			e.Code = code
+		}
+
+		if msg, ok := m["message"]; ok {
+			e.Message = msg
+		}
+	}
+
+	if he.Internal != nil {
+		e = e.WithCause(he.Internal)
+	}
+
+	return e
+}
+
+// CodeFromStatus derives the default error code from the status text, e.g. 404 -> not_found
+func CodeFromStatus(status int) string {
+	text := http.StatusText(status)
+	if text == "" {
+		return ErrCodeInternal
+	}
+
+	return strings.ReplaceAll(strings.ToLower(text), " ", "_")
+}
+
+func pgxError(err error) *Error {
+	if errors.Is(err, pgx.ErrNoRows) {
+		return NewError(http.StatusNotFound, ErrCodeNotFound, "the requested resource does not exist").WithCause(err)
+	}
+
+	var pgErr *pgconn.PgError
+	if !errors.As(err, &pgErr) {
+		return nil
+	}
+
+	var e *Error
+	switch pgErr.Code {
+	case pgCodeCheckViolation, pgCodeNotNullViolation:
+		e = NewError(http.StatusBadRequest, ErrCodeConstraintViolation, "a value does not satisfy the constraints")
+
+		if field := constraintField(pgErr); field != "" {
+			e = e.WithDetail("field", field)
+			e.Message = fmt.Sprintf("the value of %s does not satisfy the constraints", field)
+		}
+
+	case pgCodeUniqueViolation:
+		e = NewError(http.StatusConflict, ErrCodeConflict, "the resource already exists")
+
+	case pgCodeForeignKeyViolation:
+		e = NewError(http.StatusConflict, ErrCodeConflict, "the resource references a resource which does not exist")
+
+	default:
+		return nil
+	}
+
+	return e.WithCause(err)
+}
+
+// IsUniqueViolation reports whether err was caused by a unique constraint violation
+func IsUniqueViolation(err error) bool {
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && pgErr.Code == pgCodeUniqueViolation
+}
+
+// constraintField returns the column a constraint violation refers to;
+// check constraints without an explicit name are named check_<column> by cockroachdb
+func constraintField(pgErr *pgconn.PgError) string {
+	if pgErr.ColumnName != "" {
+		return pgErr.ColumnName
+	}
+
+	if field, ok := strings.CutPrefix(pgErr.ConstraintName, "check_"); ok {
+		return field
+	}
+
+	return ""
+}
@@ -0,0 +1,124 @@
+package util
+
+import (
+	"errors"
+	"fmt"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"testing"
+)
+
+func TestErrorFrom(t *testing.T) {
+	checkViolation := &pgconn.PgError{Code: pgCodeCheckViolation, Message: "failed to satisfy CHECK constraint", ConstraintName: "check_display_name"}
+
+	tests := map[string]struct {
+		err     error
+		status  int
+		code    string
+		message string
+		details map[string]any
+	}{
+		"util error": {
+			err:     NewError(http.StatusBadRequest, "some_code", "some message"),
+			status:  http.StatusBadRequest,
+			code:    "some_code",
+			message: "some message",
+		},
+		"echo string": {
+			err:     echo.NewHTTPError(http.StatusNotFound, "not here"),
+			status:  http.StatusNotFound,
+			code:    "not_found",
+			message: "not here",
+		},
+		"echo client error": {
+			err:     echo.NewHTTPError(http.StatusBadRequest, errors.New("invalid uuid")),
+			status:  http.StatusBadRequest,
+			code:    "bad_request",
+			message: "bad request",
+		},
+		"echo internal error": {
+			err:     echo.NewHTTPError(http.StatusInternalServerError, errors.New("secret details")),
+			status:  http.StatusInternalServerError,
+			code:    ErrCodeInternal,
+			message: "internal server error",
+		},
+		"echo code and message": {
+			err:     echo.NewHTTPError(http.StatusBadRequest, map[string]string{"code": "some_code", "message": "some message"}),
+			status:  http.StatusBadRequest,
+			code:    "some_code",
+			message: "some message",
+		},
+		"echo empty map": {
+			err:     echo.NewHTTPError(http.StatusNotFound, map[string]string{}),
+			status:  http.StatusNotFound,
+			code:    "not_found",
+			message: "not found",
+		},
+		"plain error": {
+			err:     errors.New("secret details"),
+			status:  http.StatusInternalServerError,
+			code:    ErrCodeInternal,
+			message: "internal server error",
+		},
+		"no rows": {
+			err:     NewEchoPgxHTTPError(pgx.ErrNoRows),
+			status:  http.StatusNotFound,
+			code:    ErrCodeNotFound,
+			message: "the requested resource does not exist",
+		},
+		"check violation": {
+			err:     NewEchoPgxHTTPError(checkViolation),
+			status:  http.StatusBadRequest,
+			code:    ErrCodeConstraintViolation,
+			message: "the value of display_name does not satisfy the constraints",
+			details: map[string]any{"field": "display_name"},
+		},
+		"wrapped check violation": {
+			err:     echo.NewHTTPError(http.StatusInternalServerError, errors.Join(errors.New("tx failed"), checkViolation)),
+			status:  http.StatusBadRequest,
+			code:    ErrCodeConstraintViolation,
+			message: "the value of display_name does not satisfy the constraints",
+			details: map[string]any{"field": "display_name"},
+		},
+		"unique violation": {
+			err:     NewEchoPgxHTTPError(&pgconn.PgError{Code: pgCodeUniqueViolation}),
+			status:  http.StatusConflict,
+			code:    ErrCodeConflict,
+			message: "the resource already exists",
+		},
+		"other pg error": {
+			err:     NewEchoPgxHTTPError(&pgconn.PgError{Code: "XX000", Message: "secret details"}),
+			status:  http.StatusInternalServerError,
+			code:    ErrCodeInternal,
+			message: "internal server error",
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			e := ErrorFrom(tc.err)
+			assert.Equal(t, tc.status, e.Status)
+			assert.Equal(t, tc.code, e.Code)
+			assert.Equal(t, tc.message, e.Message)
+			assert.Equal(t, tc.details, e.Details)
+		})
+	}
+}
+
+func TestError_WithDetail(t *testing.T) {
+	base := NewError(http.StatusBadRequest, "some_code", "some message")
+	e := base.WithDetail("a", 1).WithDetail("b", 2)
+
+	assert.Nil(t, base.Details)
+	assert.Equal(t, map[string]any{"a": 1, "b": 2}, e.Details)
+}
+
+func TestIsUniqueViolation(t *testing.T) {
+	assert.True(t, IsUniqueViolation(&pgconn.PgError{Code: pgCodeUniqueViolation}))
+	assert.True(t, IsUniqueViolation(fmt.Errorf("insert: %w", &pgconn.PgError{Code: pgCodeUniqueViolation})))
+	assert.False(t, IsUniqueViolation(&pgconn.PgError{Code: pgCodeCheckViolation}))
+	assert.False(t, IsUniqueViolation(errors.New("other")))
+}
@@ -1,7 +1,9 @@
 package web
 
 import (
-	"errors"
+	"context"
+	"github.com/gofrs/uuid/v5"
+	"github.com/gw2auth/gw2auth.com-api/service/accountlog"
 	"github.com/gw2auth/gw2auth.com-api/service/auth"
 	"github.com/gw2auth/gw2auth.com-api/util"
 	"github.com/jackc/pgx/v5"
@@ -22,10 +24,21 @@ type accountFederation struct {
 }
 
 type accountFederationSession struct {
-	Id           string    `json:"id"`
-	Issuer       string    `json:"issuer"`
-	IdAtIssuer   string    `json:"idAtIssuer"`
-	CreationTime time.Time `json:"creationTime"`
+	Id             string                            `json:"id"`
+	Issuer         string                            `json:"issuer"`
+	IdAtIssuer     string                            `json:"idAtIssuer"`
+	CreationTime   time.Time                         `json:"creationTime"`
+	LastSeenTime   *time.Time                        `json:"lastSeenTime,omitempty"`
+	ExpirationTime time.Time                         `json:"expirationTime"`
+	Location       *accountFederationSessionLocation `json:"location,omitempty"`
+	Current        bool                              `json:"current"`
+}
+
+// accountFederationSessionLocation is the approximate location of the last request of a session.
+// The location is only stored encrypted using the key of the session, so it is only known for the current session.
+type accountFederationSessionLocation struct {
+	Lat float64 `json:"lat"`
+	Lng float64 `json:"lng"`
 }
 
 func AccountEndpoint() echo.HandlerFunc {
@@ -33,39 +46,9 @@ func AccountEndpoint() echo.HandlerFunc {
 		ctx := c.Request().Context()
 		var result account
 		err := rctx.ExecuteTx(ctx, pgx.TxOptions{AccessMode: pgx.ReadOnly}, func(tx pgx.Tx) error {
-			const sql = `
-SELECT
-	(
-		SELECT COALESCE(
-			ARRAY_AGG(JSONB_BUILD_OBJECT(
-				'issuer', issuer,
-			    'idAtIssuer', id_at_issuer
-			)) FILTER ( WHERE account_id IS NOT NULL ),
-		    ARRAY[]::JSONB[]
-		)
-		FROM account_federations
-		WHERE account_id = $1
-	),
-	(
-	    SELECT COALESCE(
-	    	ARRAY_AGG(JSONB_BUILD_OBJECT(
-	    		'id', acc_fed_sess.id,
-			    'issuer', acc_fed.issuer,
-			    'idAtIssuer', acc_fed.id_at_issuer,
-	    	    'creationTime', acc_fed_sess.creation_time
-			)) FILTER ( WHERE account_id IS NOT NULL ),
-		    ARRAY[]::JSONB[]
-		)
-	    FROM account_federation_sessions acc_fed_sess
-	    INNER JOIN account_federations acc_fed
-	    USING (issuer, id_at_issuer)
-	    WHERE acc_fed.account_id = $1
-	)
-`
-			return tx.QueryRow(ctx, sql, session.AccountId).Scan(
-				&result.Federations,
-				&result.Sessions,
-			)
+			var err error
+			result, err = selectAccount(ctx, tx, session)
+			return err
 		})
 
 		if err != nil {
@@ -80,7 +63,14 @@ func DeleteAccountEndpoint() echo.HandlerFunc {
 	return wrapAuthenticatedHandlerFunc(func(c echo.Context, rctx RequestContext, session auth.Session) error {
 		ctx := c.Request().Context()
 		var found bool
+		var sessionIds []string
 		err := rctx.ExecuteTx(ctx, pgx.TxOptions{}, func(tx pgx.Tx) error {
+			var err error
+			sessionIds, err = selectAccountSessionIds(ctx, tx, session.AccountId, "", "")
+			if err != nil {
+				return err
+			}
+
 			const sql = "DELETE FROM accounts WHERE id = $1"
 			tags, err := tx.Exec(ctx, sql, session.AccountId)
 			if err != nil {
@@ -99,6 +89,8 @@ func DeleteAccountEndpoint() echo.HandlerFunc {
 			return echo.NewHTTPError(http.StatusNotFound)
 		}
 
+		revokeSessions(ctx, sessionIds...)
+
 		return c.NoContent(http.StatusOK)
 	})
 }
@@ -107,7 +99,7 @@ func DeleteAccountFederationEndpoint() echo.HandlerFunc {
 	return wrapAuthenticatedHandlerFunc(func(c echo.Context, rctx RequestContext, session auth.Session) error {
 		issuer, idAtIssuer := c.QueryParam("issuer"), c.QueryParam("idAtIssuer")
 		if issuer == session.Issuer && idAtIssuer == session.IdAtIssuer {
-			return echo.NewHTTPError(http.StatusBadRequest, errors.New("can not delete current federation"))
+			return echo.NewHTTPError(http.StatusBadRequest, "can not delete current federation")
 		}
 
 		ctx := c.Request().Context()
@@ -119,7 +111,14 @@ func DeleteAccountFederationEndpoint() echo.HandlerFunc {
 		)
 
 		var found bool
+		var sessionIds []string
 		err := rctx.ExecuteTx(ctx, pgx.TxOptions{}, func(tx pgx.Tx) error {
+			var err error
+			sessionIds, err = selectAccountSessionIds(ctx, tx, session.AccountId, issuer, idAtIssuer)
+			if err != nil {
+				return err
+			}
+
 			const sql = `
 DELETE FROM account_federations
 WHERE account_id = $1
@@ -133,7 +132,14 @@ AND id_at_issuer = $3
 			}
 
 			found = tags.RowsAffected() > 0
-			return nil
+			if !found {
+				return nil
+			}
+
+			return accountlog.Write(ctx, tx, session.AccountId, accountlog.TypeFederationDeleted, accountlog.Fields{
+				"issuer":     issuer,
+				"idAtIssuer": idAtIssuer,
+			})
 		})
 
 		if err != nil {
@@ -144,6 +150,7 @@ AND id_at_issuer = $3
 			return echo.NewHTTPError(http.StatusNotFound)
 		}
 
+		revokeSessions(ctx, sessionIds...)
 		return c.NoContent(http.StatusOK)
 	})
 }
@@ -152,7 +159,7 @@ func DeleteAccountFederationSessionEndpoint() echo.HandlerFunc {
 	return wrapAuthenticatedHandlerFunc(func(c echo.Context, rctx RequestContext, session auth.Session) error {
 		sessionId := c.QueryParam("id")
 		if sessionId == session.Id {
-			return echo.NewHTTPError(http.StatusBadRequest, errors.New("can not delete current session"))
+			return echo.NewHTTPError(http.StatusBadRequest, "can not delete current session")
 		}
 
 		ctx := c.Request().Context()
@@ -182,7 +189,13 @@ WHERE id = (
 			}
 
 			found = tags.RowsAffected() > 0
-			return nil
+			if !found {
+				return nil
+			}
+
+			return accountlog.Write(ctx, tx, session.AccountId, accountlog.TypeSessionDeleted, accountlog.Fields{
+				"sessionId": sessionId,
+			})
 		})
 
 		if err != nil {
@@ -193,6 +206,130 @@ WHERE id = (
 			return echo.NewHTTPError(http.StatusNotFound)
 		}
 
+		revokeSessions(ctx, sessionId)
 		return c.NoContent(http.StatusOK)
 	})
 }
+
+func DeleteOtherAccountFederationSessionsEndpoint() echo.HandlerFunc {
+	return wrapAuthenticatedHandlerFunc(func(c echo.Context, rctx RequestContext, session auth.Session) error {
+		ctx := c.Request().Context()
+		var deletedIds []string
+		err := rctx.ExecuteTx(ctx, pgx.TxOptions{}, func(tx pgx.Tx) error {
+			const sql = `
+DELETE FROM account_federation_sessions
+WHERE id IN (
+    SELECT acc_fed_sess.id
+    FROM account_federation_sessions acc_fed_sess
+    INNER JOIN account_federations acc_fed
+    USING (issuer, id_at_issuer)
+    WHERE acc_fed.account_id = $1
+    AND acc_fed_sess.id != $2
+)
+RETURNING id
+`
+
+			rows, err := tx.Query(ctx, sql, session.AccountId, session.Id)
+			if err != nil {
+				return err
+			}
+
+			deletedIds, err = pgx.CollectRows(rows, pgx.RowTo[string])
+			if err != nil || len(deletedIds) < 1 {
+				return err
+			}
+
+			return accountlog.Write(ctx, tx, session.AccountId, accountlog.TypeOtherSessionsDeleted, accountlog.Fields{
+				"count": len(deletedIds),
+			})
+		})
+
+		if err != nil {
+			return util.NewEchoPgxHTTPError(err)
+		}
+
+		revokeSessions(ctx, deletedIds...)
+		deleted := int64(len(deletedIds))
+
+		slog.InfoContext(
+			ctx,
+			"deleted all other account federation sessions",
+			slog.Int64("session.deleted", deleted),
+		)
+
+		return c.JSON(http.StatusOK, map[string]int64{
+			"deleted": deleted,
+		})
+	})
+}
+
+// selectAccountSessionIds returns the ids of all sessions of the account, optionally limited to a single federation
+func selectAccountSessionIds(ctx context.Context, tx pgx.Tx, accountId uuid.UUID, issuer, idAtIssuer string) ([]string, error) {
+	const sql = `
+SELECT acc_fed_sess.id
+FROM account_federation_sessions acc_fed_sess
+INNER JOIN account_federations acc_fed
+USING (issuer, id_at_issuer)
+WHERE acc_fed.account_id = $1
+AND ($2 = '' OR (acc_fed.issuer = $2 AND acc_fed.id_at_issuer = $3))
+`
+
+	rows, err := tx.Query(ctx, sql, accountId, issuer, idAtIssuer)
+	if err != nil {
+		return nil, err
+	}
+
+	return pgx.CollectRows(rows, pgx.RowTo[string])
+}
+
+func selectAccount(ctx context.Context, tx pgx.Tx, session auth.Session) (account, error) {
+	const sql = `
+SELECT
+	(
+		SELECT COALESCE(
+			ARRAY_AGG(JSONB_BUILD_OBJECT(
+				'issuer', issuer,
+			    'idAtIssuer', id_at_issuer
+			)) FILTER ( WHERE account_id IS NOT NULL ),
+		    ARRAY[]::JSONB[]
+		)
+		FROM account_federations
+		WHERE account_id = $1
+	),
+	(
+	    SELECT COALESCE(
+	    	ARRAY_AGG(JSONB_BUILD_OBJECT(
+	    		'id', acc_fed_sess.id,
+			    'issuer', acc_fed.issuer,
+			    'idAtIssuer', acc_fed.id_at_issuer,
+	    	    'creationTime', acc_fed_sess.creation_time,
+	    	    'lastSeenTime', acc_fed_sess.last_seen_time,
+	    	    'expirationTime', acc_fed_sess.expiration_time,
+	    	    'current', acc_fed_sess.id = $2
+			)) FILTER ( WHERE account_id IS NOT NULL ),
+		    ARRAY[]::JSONB[]
+		)
+	    FROM account_federation_sessions acc_fed_sess
+	    INNER JOIN account_federations acc_fed
+	    USING (issuer, id_at_issuer)
+	    WHERE acc_fed.account_id = $1
+	)
+`
+	var result account
+	err := tx.QueryRow(ctx, sql, session.AccountId, session.Id).Scan(
+		&result.Federations,
+		&result.Sessions,
+	)
+	if err != nil {
+		return result, err
+	}
+
+	for i, sess := range result.Sessions {
+		if sess.Current && !session.Metadata.IsZero() {
+			approx := session.Metadata.Approximate()
+			result.Sessions[i].Location = &accountFederationSessionLocation{Lat: approx.Lat, Lng: approx.Lng}
+		}
+	}
+
+	return result, nil
+}
@@ -0,0 +1,213 @@
+package web
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/json"
+	"github.com/gofrs/uuid/v5"
+	"github.com/gw2auth/gw2auth.com-api/service/auth"
+	"github.com/gw2auth/gw2auth.com-api/util"
+	"github.com/jackc/pgx/v5"
+	"github.com/labstack/echo/v4"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const accountExportUnmaskedApiTokenChars = 4
+
+type accountExport struct {
+	ExportTime      time.Time                     `json:"exportTime"`
+	Federations     []accountFederation           `json:"federations"`
+	Sessions        []accountFederationSession    `json:"sessions"`
+	TrustedRegions  []accountTrustedRegion        `json:"trustedRegions"`
+	Logs            []accountLog                  `json:"logs"`
+	Gw2Accounts     []accountExportGw2Account     `json:"gw2Accounts"`
+	Applications    []accountExportApplication    `json:"applications"`
+	DevApplications []accountExportDevApplication `json:"devApplications"`
+}
+
+type accountExportGw2Account struct {
+	Id uuid.UUID `json:"id"`
+	gw2Account
+}
+
+type accountExportApplication struct {
+	Id uuid.UUID `json:"id"`
+	application
+}
+
+type accountExportDevApplication struct {
+	Id uuid.UUID `json:"id"`
+	devApplication
+}
+
+// AccountExportEndpoint returns all data stored for the account. API tokens are masked.
+// Using ?format=zip, the export is returned as a zip archive containing a single account.json
+func AccountExportEndpoint() echo.HandlerFunc {
+	return wrapAuthenticatedHandlerFunc(func(c echo.Context, rctx RequestContext, session auth.Session) error {
+		format := c.QueryParam("format")
+		if format != "" && format != "json" && format != "zip" {
+			return echo.NewHTTPError(http.StatusBadRequest, "format must be one of json, zip")
+		}
+
+		ctx := c.Request().Context()
+		slog.InfoContext(ctx, "exporting account", slog.String("format", format))
+
+		result := accountExport{ExportTime: time.Now()}
+		err := rctx.ExecuteTx(ctx, pgx.TxOptions{AccessMode: pgx.ReadOnly}, func(tx pgx.Tx) error {
+			acc, err := selectAccount(ctx, tx, session)
+			if err != nil {
+				return err
+			}
+
+			result.Federations = acc.Federations
+			result.Sessions = acc.Sessions
+
+			if result.TrustedRegions, err = selectAccountTrustedRegions(ctx, tx, session.AccountId); err != nil {
+				return err
+			}
+
+			if result.Logs, err = selectAccountExportLogs(ctx, tx, session.AccountId); err != nil {
+				return err
+			}
+
+			if result.Gw2Accounts, err = selectAccountExportGw2Accounts(ctx, tx, session.AccountId); err != nil {
+				return err
+			}
+
+			if result.Applications, err = selectAccountExportApplications(ctx, tx, session.AccountId); err != nil {
+				return err
+			}
+
+			result.DevApplications, err = selectAccountExportDevApplications(ctx, tx, session.AccountId)
+			return err
+		})
+
+		if err != nil {
+			return util.NewEchoPgxHTTPError(err)
+		}
+
+		if format != "zip" {
+			c.Response().Header().Set(echo.HeaderContentDisposition, `attachment; filename="gw2auth-account.json"`)
+			return c.JSON(http.StatusOK, result)
+		}
+
+		b, err := zipAccountExport(result)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, err)
+		}
+
+		c.Response().Header().Set(echo.HeaderContentDisposition, `attachment; filename="gw2auth-account.zip"`)
+		return c.Blob(http.StatusOK, "application/zip", b)
+	})
+}
+
+func selectAccountExportLogs(ctx context.Context, tx pgx.Tx, accountId uuid.UUID) ([]accountLog, error) {
+	const sql = `
+SELECT id, timestamp, type, fields
+FROM account_logs
+WHERE account_id = $1
+ORDER BY timestamp DESC, id
+`
+	rows, err := tx.Query(ctx, sql, accountId)
+	if err != nil {
+		return nil, err
+	}
+
+	return pgx.CollectRows(rows, func(row pgx.CollectableRow) (accountLog, error) {
+		var log accountLog
+		return log, row.Scan(
+			&log.Id,
+			&log.Timestamp,
+			&log.Type,
+			&log.Fields,
+		)
+	})
+}
+
+func selectAccountExportGw2Accounts(ctx context.Context, tx pgx.Tx, accountId uuid.UUID) ([]accountExportGw2Account, error) {
+	rows, err := tx.Query(ctx, selectGw2AccountSql, accountId, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return pgx.CollectRows(rows, func(row pgx.CollectableRow) (accountExportGw2Account, error) {
+		var v accountExportGw2Account
+		var err error
+		v.Id, v.gw2Account, err = scanGw2Account(row)
+		if err != nil {
+			return v, err
+		}
+
+		if v.ApiToken != nil {
+			v.ApiToken.Value = maskApiToken(v.ApiToken.Value)
+		}
+
+		return v, nil
+	})
+}
+
+func selectAccountExportApplications(ctx context.Context, tx pgx.Tx, accountId uuid.UUID) ([]accountExportApplication, error) {
+	rows, err := tx.Query(ctx, selectUserApplicationSql, accountId, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return pgx.CollectRows(rows, func(row pgx.CollectableRow) (accountExportApplication, error) {
+		var v accountExportApplication
+		var err error
+		v.Id, v.application, err = scanUserApplication(row)
+		return v, err
+	})
+}
+
+func selectAccountExportDevApplications(ctx context.Context, tx pgx.Tx, accountId uuid.UUID) ([]accountExportDevApplication, error) {
+	rows, err := tx.Query(ctx, selectDevApplicationSql, accountId, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return pgx.CollectRows(rows, func(row pgx.CollectableRow) (accountExportDevApplication, error) {
+		var v accountExportDevApplication
+		var err error
+		v.Id, v.devApplication, err = scanDevApplication(row)
+		return v, err
+	})
+}
+
+func zipAccountExport(v accountExport) ([]byte, error) {
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+
+	w, err := zw.CreateHeader(&zip.FileHeader{
+		Name:     "account.json",
+		Method:   zip.Deflate,
+		Modified: v.ExportTime,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	if err = enc.Encode(v); err != nil {
+		return nil, err
+	}
+
+	if err = zw.Close(); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+func maskApiToken(v string) string {
+	if len(v) <= accountExportUnmaskedApiTokenChars {
+		return strings.Repeat("*", len(v))
+	}
+
+	return strings.Repeat("*", len(v)-accountExportUnmaskedApiTokenChars) + v[len(v)-accountExportUnmaskedApiTokenChars:]
+}
@@ -0,0 +1,184 @@
+package web
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"github.com/gw2auth/gw2auth.com-api/internal/test"
+	"github.com/gw2auth/gw2auth.com-api/service"
+	"github.com/gw2auth/gw2auth.com-api/service/gw2"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestAccountExportAll(t *testing.T) {
+	test.RunAll(t, dbScope, map[string]map[string]test.Fn{
+		"AccountExportEndpoint": {
+			"unauthorized":   testAccountExportEndpointUnauthorized,
+			"json":           testAccountExportEndpointJSON,
+			"zip":            testAccountExportEndpointZip,
+			"invalid format": testAccountExportEndpointInvalidFormat,
+		},
+	})
+}
+
+func testAccountExportEndpointUnauthorized(t *testing.T, pool *pgxpool.Pool, conv *service.SessionJwtConverter, truncateTablesFn func() error) {
+	e := newEchoWithMiddleware(pool, conv)
+	e.GET("/", AccountExportEndpoint())
+	test.MustUnauthorized(t, e, httptest.NewRequest(http.MethodGet, "/", nil))
+}
+
+func testAccountExportEndpointJSON(t *testing.T, pool *pgxpool.Pool, conv *service.SessionJwtConverter, truncateTablesFn func() error) {
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	accountId, sessionId, _, _ := test.Authenticated(t, req, pool, conv, "google", "GoogleA")
+
+	gw2AccountId := test.NewUUID(t)
+	test.CreateGw2Account(t, pool, accountId, gw2AccountId, "Felix.1234", "Main")
+	test.CreateGw2ApiToken(t, pool, accountId, gw2AccountId, "00000000-1111-2222-3333-444444444444", []gw2.Permission{gw2.PermissionAccount})
+
+	applicationId := test.NewUUID(t)
+	test.CreateApplication(t, pool, accountId, applicationId, "App")
+
+	clientId := test.NewUUID(t)
+	test.CreateApplicationClient(t, pool, applicationId, clientId, "Client", []string{"https://gw2auth.com/callback"})
+
+	apiKeyId := test.NewUUID(t)
+	test.CreateApplicationAPIKey(t, pool, applicationId, apiKeyId, "key", []string{"read"}, time.Now().Add(-time.Hour), time.Now().Add(time.Hour))
+	test.MustExec(t, pool, `UPDATE application_api_keys SET request_count = 5 WHERE id = $1`, apiKeyId)
+
+	regionId := test.NewUUID(t)
+	test.MustExec(
+		t,
+		pool,
+		`INSERT INTO account_trusted_regions (id, account_id, creation_time, display_name, lat, lng, radius_km) VALUES ($1, $2, NOW(), 'New York', 40.7, -74, 50)`,
+		regionId,
+		accountId,
+	)
+
+	logId := test.NewUUID(t)
+	test.MustExec(
+		t,
+		pool,
+		`INSERT INTO account_logs (id, account_id, timestamp, type, fields) VALUES ($1, $2, NOW(), 'other_sessions_deleted', '{"count": 2}'::JSONB)`,
+		logId,
+		accountId,
+	)
+
+	e := newEchoWithMiddleware(pool, conv)
+	e.GET("/", AccountExportEndpoint())
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+
+	if !assert.Equal(t, http.StatusOK, rec.Code) {
+		return
+	}
+
+	assert.Contains(t, rec.Header().Get("Content-Disposition"), "attachment")
+
+	var res accountExport
+	if !assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &res)) {
+		return
+	}
+
+	assert.Equal(t, []accountFederation{{Issuer: "google", IdAtIssuer: "GoogleA"}}, res.Federations)
+	if assert.Len(t, res.Sessions, 1) {
+		assert.Equal(t, sessionId, res.Sessions[0].Id)
+	}
+
+	if assert.Len(t, res.Gw2Accounts, 1) {
+		acc := res.Gw2Accounts[0]
+		assert.Equal(t, gw2AccountId, acc.Id)
+		assert.Equal(t, "Main", acc.DisplayName)
+		if assert.NotNil(t, acc.ApiToken) {
+			assert.Equal(t, "********************************4444", acc.ApiToken.Value)
+			assert.Equal(t, []gw2.Permission{gw2.PermissionAccount}, acc.ApiToken.Permissions)
+		}
+	}
+
+	if assert.Len(t, res.TrustedRegions, 1) {
+		assert.Equal(t, regionId, res.TrustedRegions[0].Id)
+		assert.Equal(t, "New York", res.TrustedRegions[0].DisplayName)
+	}
+
+	if assert.Len(t, res.Logs, 1) {
+		assert.Equal(t, logId, res.Logs[0].Id)
+		assert.JSONEq(t, `{"count": 2}`, string(res.Logs[0].Fields))
+	}
+
+	assert.Empty(t, res.Applications)
+	if assert.Len(t, res.DevApplications, 1) {
+		devApp := res.DevApplications[0]
+		assert.Equal(t, applicationId, devApp.Id)
+		assert.Equal(t, "App", devApp.DisplayName)
+
+		if assert.Len(t, devApp.Clients, 1) {
+			assert.Equal(t, clientId, devApp.Clients[0].Id)
+			assert.Equal(t, []string{"https://gw2auth.com/callback"}, devApp.Clients[0].RedirectURIs)
+		}
+
+		if assert.Len(t, devApp.ApiKeys, 1) {
+			assert.Equal(t, apiKeyId, devApp.ApiKeys[0].Id)
+			assert.Equal(t, int64(5), devApp.ApiKeys[0].RequestCount)
+		}
+	}
+
+	assert.NotContains(t, rec.Body.String(), "00000000-1111-2222-3333-444444444444")
+}
+
+func testAccountExportEndpointZip(t *testing.T, pool *pgxpool.Pool, conv *service.SessionJwtConverter, truncateTablesFn func() error) {
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	test.Authenticated(t, req, pool, conv, "google", "GoogleA")
+	test.AddQuery(req, "format", "zip")
+
+	e := newEchoWithMiddleware(pool, conv)
+	e.GET("/", AccountExportEndpoint())
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+
+	if !assert.Equal(t, http.StatusOK, rec.Code) {
+		return
+	}
+
+	assert.Equal(t, "application/zip", rec.Header().Get("Content-Type"))
+
+	zr, err := zip.NewReader(bytes.NewReader(rec.Body.Bytes()), int64(rec.Body.Len()))
+	if !assert.NoError(t, err) || !assert.Len(t, zr.File, 1) {
+		return
+	}
+
+	assert.Equal(t, "account.json", zr.File[0].Name)
+
+	f, err := zr.File[0].Open()
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer f.Close()
+
+	b, err := io.ReadAll(f)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	var res accountExport
+	if assert.NoError(t, json.Unmarshal(b, &res)) {
+		assert.Equal(t, []accountFederation{{Issuer: "google", IdAtIssuer: "GoogleA"}}, res.Federations)
+	}
+}
+
+func testAccountExportEndpointInvalidFormat(t *testing.T, pool *pgxpool.Pool, conv *service.SessionJwtConverter, truncateTablesFn func() error) {
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	test.Authenticated(t, req, pool, conv, "google", "GoogleA")
+	test.AddQuery(req, "format", "xml")
+
+	e := newEchoWithMiddleware(pool, conv)
+	e.GET("/", AccountExportEndpoint())
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+}
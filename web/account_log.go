@@ -0,0 +1,93 @@
+package web
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/gofrs/uuid/v5"
+	"github.com/gw2auth/gw2auth.com-api/service/accountlog"
+	"github.com/gw2auth/gw2auth.com-api/service/auth"
+	"github.com/gw2auth/gw2auth.com-api/util"
+	"github.com/jackc/pgx/v5"
+	"github.com/labstack/echo/v4"
+	"net/http"
+	"time"
+)
+
+type accountLog struct {
+	Id        uuid.UUID       `json:"id"`
+	Timestamp time.Time       `json:"timestamp"`
+	Type      accountlog.Type `json:"type"`
+	Fields    json.RawMessage `json:"fields"`
+}
+
+func AccountLogsEndpoint() echo.HandlerFunc {
+	const defaultPageSize = 50
+
+	return wrapAuthenticatedHandlerFunc(func(c echo.Context, rctx RequestContext, session auth.Session) error {
+		var t time.Time
+		var pageSize uint32
+		var offset uint32
+
+		if nextToken := c.QueryParam("nextToken"); nextToken != "" {
+			if err := parseNextToken(nextToken, &t, &pageSize, &offset); err != nil {
+				return echo.NewHTTPError(http.StatusBadRequest, err)
+			}
+
+			// in case anyone messes with the nextToken
+			if pageSize < 1 || pageSize > 50 || t.Before(time.Now().Add(-time.Hour)) {
+				return echo.NewHTTPError(http.StatusBadRequest, "pageSize or timestamp out of bounds")
+			}
+		} else {
+			t = time.Now().Add(-time.Second)
+			pageSize = defaultPageSize
+			offset = 0
+		}
+
+		ctx := c.Request().Context()
+		var results []accountLog
+		err := rctx.ExecuteTx(ctx, pgx.TxOptions{AccessMode: pgx.ReadOnly}, func(tx pgx.Tx) error {
+			if _, err := tx.Exec(ctx, fmt.Sprintf("SET TRANSACTION AS OF SYSTEM TIME %d", t.UnixNano())); err != nil {
+				return err
+			}
+
+			const sql = `
+SELECT id, timestamp, type, fields
+FROM account_logs
+WHERE account_id = $1
+ORDER BY timestamp DESC, id
+OFFSET $2 LIMIT ($3 + 1)
+`
+			rows, err := tx.Query(ctx, sql, session.AccountId, offset, pageSize)
+			if err != nil {
+				return err
+			}
+
+			results, err = pgx.CollectRows(rows, func(row pgx.CollectableRow) (accountLog, error) {
+				var log accountLog
+				return log, row.Scan(
+					&log.Id,
+					&log.Timestamp,
+					&log.Type,
+					&log.Fields,
+				)
+			})
+
+			return err
+		})
+
+		if err != nil {
+			return util.NewEchoPgxHTTPError(err)
+		}
+
+		nextToken := ""
+		if len(results) > int(pageSize) {
+			results = results[:pageSize]
+			nextToken = buildNextToken(t, pageSize, offset+pageSize)
+		}
+
+		return c.JSON(http.StatusOK, pagedResult[accountLog]{
+			Items:     results,
+			NextToken: nextToken,
+		})
+	})
+}
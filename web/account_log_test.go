@@ -0,0 +1,84 @@
+package web
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/gw2auth/gw2auth.com-api/internal/test"
+	"github.com/gw2auth/gw2auth.com-api/service"
+	"github.com/gw2auth/gw2auth.com-api/service/accountlog"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestAccountLogAll(t *testing.T) {
+	test.RunAll(t, dbScope, map[string]map[string]test.Fn{
+		"AccountLogsEndpoint": {
+			"unauthorized": testAccountLogsEndpointUnauthorized,
+			"paged":        testAccountLogsEndpointPaged,
+		},
+	})
+}
+
+func testAccountLogsEndpointUnauthorized(t *testing.T, pool *pgxpool.Pool, conv *service.SessionJwtConverter, truncateTablesFn func() error) {
+	e := newEchoWithMiddleware(pool, conv)
+	e.GET("/", AccountLogsEndpoint())
+	test.MustUnauthorized(t, e, httptest.NewRequest(http.MethodGet, "/", nil))
+}
+
+func testAccountLogsEndpointPaged(t *testing.T, pool *pgxpool.Pool, conv *service.SessionJwtConverter, truncateTablesFn func() error) {
+	ctx := context.Background()
+	otherAccountId := test.NewUUID(t)
+	test.CreateAccountAndFederation(t, pool, otherAccountId, "google", "GoogleA", time.Now())
+	assert.NoError(t, accountlog.Write(ctx, pool, otherAccountId, accountlog.TypeSessionDeleted, nil))
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	accountId, _, _, _ := test.Authenticated(t, req, pool, conv, "google", "GoogleB")
+	for range 51 {
+		assert.NoError(t, accountlog.Write(ctx, pool, accountId, accountlog.TypeFederationDeleted, accountlog.Fields{"issuer": "cognito"}))
+	}
+
+	// the nextToken reads as of the time of the first request
+	time.Sleep(time.Second)
+
+	e := newEchoWithMiddleware(pool, conv)
+	e.GET("/", AccountLogsEndpoint())
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+
+	if !assert.Equal(t, http.StatusOK, rec.Code) {
+		return
+	}
+
+	var res pagedResult[accountLog]
+	if !assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &res)) {
+		return
+	}
+
+	assert.Len(t, res.Items, 50)
+	assert.NotEmpty(t, res.NextToken)
+	for _, item := range res.Items {
+		assert.Equal(t, accountlog.TypeFederationDeleted, item.Type)
+		assert.JSONEq(t, `{"issuer": "cognito"}`, string(item.Fields))
+	}
+
+	nextReq := httptest.NewRequest(http.MethodGet, "/", nil)
+	nextReq.Header = req.Header.Clone()
+	test.AddQuery(nextReq, "nextToken", res.NextToken)
+
+	rec = httptest.NewRecorder()
+	e.ServeHTTP(rec, nextReq)
+
+	if !assert.Equal(t, http.StatusOK, rec.Code) {
+		return
+	}
+
+	res = pagedResult[accountLog]{}
+	if assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &res)) {
+		assert.Len(t, res.Items, 1)
+		assert.Empty(t, res.NextToken)
+	}
+}
@@ -0,0 +1,150 @@
+package web
+
+import (
+	"crypto/sha256"
+	"errors"
+	"github.com/gofrs/uuid/v5"
+	"github.com/gw2auth/gw2auth.com-api/service/accountlog"
+	"github.com/gw2auth/gw2auth.com-api/service/accountmerge"
+	"github.com/gw2auth/gw2auth.com-api/service/auth"
+	"github.com/gw2auth/gw2auth.com-api/util"
+	"github.com/jackc/pgx/v5"
+	"github.com/labstack/echo/v4"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+const accountMergeRequestTTL = 10 * time.Minute
+
+type accountMergeRequestCreateResponse struct {
+	Token     string    `json:"token"`
+	ExpiresAt time.Time `json:"expiresAt"`
+}
+
+type accountMergeRequest struct {
+	Token string `json:"token"`
+}
+
+type accountMergeResponse struct {
+	Gw2AccountConflicts  []uuid.UUID `json:"gw2AccountConflicts"`
+	ApplicationConflicts []uuid.UUID `json:"applicationConflicts"`
+}
+
+// CreateAccountMergeRequestEndpoint is called by the account which should be merged into another one.
+// The returned token has to be submitted using a session of the other account within accountMergeRequestTTL.
+func CreateAccountMergeRequestEndpoint() echo.HandlerFunc {
+	return wrapAuthenticatedHandlerFunc(func(c echo.Context, rctx RequestContext, session auth.Session) error {
+		token, err := generateClientSecret()
+		if err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, err)
+		}
+
+		ctx := c.Request().Context()
+		slog.InfoContext(ctx, "creating account merge request")
+
+		tokenHash := sha256.Sum256([]byte(token))
+		now := time.Now()
+		expiresAt := now.Add(accountMergeRequestTTL)
+		err = rctx.ExecuteTx(ctx, pgx.TxOptions{}, func(tx pgx.Tx) error {
+			const sql = `
+INSERT INTO account_merge_requests
+(token_hash, account_id, creation_time, expiration_time)
+VALUES
+($1, $2, $3, $4)
+`
+			_, err := tx.Exec(ctx, sql, tokenHash[:], session.AccountId, now, expiresAt)
+			return err
+		})
+
+		if err != nil {
+			return util.NewEchoPgxHTTPError(err)
+		}
+
+		return c.JSON(http.StatusOK, accountMergeRequestCreateResponse{
+			Token:     token,
+			ExpiresAt: expiresAt,
+		})
+	})
+}
+
+// MergeAccountEndpoint merges the account which created the merge request into the account of the current session.
+func MergeAccountEndpoint() echo.HandlerFunc {
+	return wrapAuthenticatedHandlerFunc(func(c echo.Context, rctx RequestContext, session auth.Session) error {
+		var body accountMergeRequest
+		if err := c.Bind(&body); err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, err)
+		}
+
+		if body.Token == "" {
+			return echo.NewHTTPError(http.StatusBadRequest, "the token is invalid")
+		}
+
+		ctx := c.Request().Context()
+		tokenHash := sha256.Sum256([]byte(body.Token))
+
+		var sourceAccountId uuid.UUID
+		var sourceSessionIds []string
+		var res accountmerge.Result
+		err := rctx.ExecuteTx(ctx, pgx.TxOptions{}, func(tx pgx.Tx) error {
+			const sql = `
+DELETE FROM account_merge_requests
+WHERE token_hash = $1
+AND expiration_time > $2
+RETURNING account_id
+`
+			if err := tx.QueryRow(ctx, sql, tokenHash[:], time.Now()).Scan(&sourceAccountId); err != nil {
+				if errors.Is(err, pgx.ErrNoRows) {
+					return echo.NewHTTPError(http.StatusNotFound, "the merge request does not exist or expired")
+				}
+
+				return err
+			}
+
+			if sourceAccountId == session.AccountId {
+				return echo.NewHTTPError(http.StatusBadRequest, "can not merge an account into itself")
+			}
+
+			slog.InfoContext(
+				ctx,
+				"merging accounts",
+				slog.String("account.merge.source_account_id", sourceAccountId.String()),
+			)
+
+			var err error
+			sourceSessionIds, err = selectAccountSessionIds(ctx, tx, sourceAccountId, "", "")
+			if err != nil {
+				return err
+			}
+
+			res, err = accountmerge.Merge(ctx, tx, session.AccountId, sourceAccountId)
+			if err != nil {
+				return err
+			}
+
+			return accountlog.Write(ctx, tx, session.AccountId, accountlog.TypeAccountMerged, accountlog.Fields{
+				"sourceAccountId":      sourceAccountId,
+				"gw2AccountConflicts":  res.Gw2AccountConflicts,
+				"applicationConflicts": res.ApplicationConflicts,
+			})
+		})
+
+		if err != nil {
+			var httpError *echo.HTTPError
+			if errors.As(err, &httpError) {
+				return httpError
+			} else {
+				return util.NewEchoPgxHTTPError(err)
+			}
+		}
+
+		// the sessions of the source account were deleted by the merge; dropping them from the local cache is best-effort,
+		// other instances reject them once their cached entries expire
+		revokeSessions(ctx, sourceSessionIds...)
+
+		return c.JSON(http.StatusOK, accountMergeResponse{
+			Gw2AccountConflicts:  res.Gw2AccountConflicts,
+			ApplicationConflicts: res.ApplicationConflicts,
+		})
+	})
+}
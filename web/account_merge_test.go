@@ -0,0 +1,119 @@
+package web
+
+import (
+	"encoding/json"
+	"github.com/gw2auth/gw2auth.com-api/internal/test"
+	"github.com/gw2auth/gw2auth.com-api/service"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestAccountMergeAll(t *testing.T) {
+	test.RunAll(t, dbScope, map[string]map[string]test.Fn{
+		"MergeAccountEndpoint": {
+			"unauthorized":  testMergeAccountEndpointUnauthorized,
+			"simple":        testMergeAccountEndpointSimple,
+			"unknown token": testMergeAccountEndpointUnknownToken,
+			"same account":  testMergeAccountEndpointSameAccount,
+		},
+	})
+}
+
+func testMergeAccountEndpointUnauthorized(t *testing.T, pool *pgxpool.Pool, conv *service.SessionJwtConverter, truncateTablesFn func() error) {
+	e := newEchoWithMiddleware(pool, conv)
+	e.POST("/", MergeAccountEndpoint())
+	test.MustUnauthorized(t, e, httptest.NewRequest(http.MethodPost, "/", strings.NewReader(`{"token":"abc"}`)))
+}
+
+func testMergeAccountEndpointSimple(t *testing.T, pool *pgxpool.Pool, conv *service.SessionJwtConverter, truncateTablesFn func() error) {
+	e := newEchoWithMiddleware(pool, conv)
+	e.PUT("/", CreateAccountMergeRequestEndpoint())
+	e.POST("/", MergeAccountEndpoint())
+
+	req := httptest.NewRequest(http.MethodPut, "/", nil)
+	sourceAccountId, _, _, _ := test.Authenticated(t, req, pool, conv, "github", "GitHubA")
+	token := createAccountMergeRequest(t, e, req)
+
+	req = newMergeAccountRequest(token)
+	targetAccountId, _, _, _ := test.Authenticated(t, req, pool, conv, "google", "GoogleA")
+
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.JSONEq(t, `{"gw2AccountConflicts": [], "applicationConflicts": []}`, rec.Body.String())
+
+	test.MustNotExist(t, pool, "SELECT TRUE FROM accounts WHERE id = $1", sourceAccountId)
+	test.MustExist(t, pool, "SELECT TRUE FROM account_federations WHERE account_id = $1 AND issuer = 'github' AND id_at_issuer = 'GitHubA'", targetAccountId)
+	test.MustExist(t, pool, "SELECT TRUE FROM account_logs WHERE account_id = $1 AND type = 'account_merged'", targetAccountId)
+
+	// the token can only be used once
+	req = newMergeAccountRequest(token)
+	_, _, _, _ = test.Authenticated(t, req, pool, conv, "cognito", "CognitoA")
+
+	rec = httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+}
+
+func testMergeAccountEndpointUnknownToken(t *testing.T, pool *pgxpool.Pool, conv *service.SessionJwtConverter, truncateTablesFn func() error) {
+	e := newEchoWithMiddleware(pool, conv)
+	e.POST("/", MergeAccountEndpoint())
+
+	req := newMergeAccountRequest("unknown")
+	_, _, _, _ = test.Authenticated(t, req, pool, conv, "google", "GoogleA")
+
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+}
+
+func testMergeAccountEndpointSameAccount(t *testing.T, pool *pgxpool.Pool, conv *service.SessionJwtConverter, truncateTablesFn func() error) {
+	e := newEchoWithMiddleware(pool, conv)
+	e.PUT("/", CreateAccountMergeRequestEndpoint())
+	e.POST("/", MergeAccountEndpoint())
+
+	req := httptest.NewRequest(http.MethodPut, "/", nil)
+	accountId, _, _, _ := test.Authenticated(t, req, pool, conv, "google", "GoogleA")
+	token := createAccountMergeRequest(t, e, req)
+
+	mergeReq := newMergeAccountRequest(token)
+	mergeReq.Header.Set(echo.HeaderCookie, req.Header.Get(echo.HeaderCookie))
+	mergeReq.Header.Set(cfLatHeaderName, req.Header.Get(cfLatHeaderName))
+	mergeReq.Header.Set(cfLngHeaderName, req.Header.Get(cfLngHeaderName))
+
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, mergeReq)
+
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	test.MustExist(t, pool, "SELECT TRUE FROM accounts WHERE id = $1", accountId)
+}
+
+func createAccountMergeRequest(t *testing.T, e *echo.Echo, req *http.Request) string {
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+
+	if !assert.Equal(t, http.StatusOK, rec.Code) {
+		t.FailNow()
+	}
+
+	var res accountMergeRequestCreateResponse
+	if !assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &res)) {
+		t.FailNow()
+	}
+
+	return res.Token
+}
+
+func newMergeAccountRequest(token string) *http.Request {
+	b, _ := json.Marshal(accountMergeRequest{Token: token})
+	req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(string(b)))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+
+	return req
+}
@@ -1,7 +1,7 @@
 package web
 
 import (
-	"fmt"
+	"encoding/json"
 	"github.com/gw2auth/gw2auth.com-api/internal/test"
 	"github.com/gw2auth/gw2auth.com-api/service"
 	"github.com/jackc/pgx/v5/pgxpool"
@@ -30,6 +30,10 @@ func TestAccountAll(t *testing.T) {
 			"unauthorized":    testDeleteAccountFederationSessionEndpointUnauthorized,
 			"current session": testDeleteAccountFederationSessionEndpointCurrentSession,
 		},
+		"DeleteOtherAccountFederationSessionsEndpoint": {
+			"unauthorized": testDeleteOtherAccountFederationSessionsEndpointUnauthorized,
+			"simple":       testDeleteOtherAccountFederationSessionsEndpointSimple,
+		},
 	})
 }
 
@@ -45,24 +49,44 @@ func testAccountEndpointSimple(t *testing.T, pool *pgxpool.Pool, conv *service.S
 	req := httptest.NewRequest(http.MethodGet, "/", nil)
 	_, sessionId, creationTime, _ := test.Authenticated(t, req, pool, conv, "google", "GoogleB")
 
+	otherSessionId := test.NewUUID(t).String()
+	test.CreateSession(t, pool, otherSessionId, "google", "GoogleB", time.Now(), time.Now().Add(time.Hour), []byte{1})
+
 	e := newEchoWithMiddleware(pool, conv)
 	e.GET("/", AccountEndpoint())
 	rec := httptest.NewRecorder()
 	e.ServeHTTP(rec, req)
 
-	assert.Equal(t, http.StatusOK, rec.Code)
-	assert.JSONEq(
-		t,
-		fmt.Sprintf(`{
-	"federations": [
-		{"issuer": "google", "idAtIssuer": "GoogleB"}
-	],
-	"sessions":[
-		{"issuer": "google", "idAtIssuer": "GoogleB", "id": "%s", "creationTime": "%s"}
-	]
-}`, sessionId, creationTime.UTC().Truncate(time.Microsecond).Format(time.RFC3339Nano)),
-		rec.Body.String(),
-	)
+	if !assert.Equal(t, http.StatusOK, rec.Code) {
+		return
+	}
+
+	var res account
+	if !assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &res)) {
+		return
+	}
+
+	assert.Equal(t, []accountFederation{{Issuer: "google", IdAtIssuer: "GoogleB"}}, res.Federations)
+	if assert.Len(t, res.Sessions, 2) {
+		if res.Sessions[0].Id != sessionId {
+			res.Sessions[0], res.Sessions[1] = res.Sessions[1], res.Sessions[0]
+		}
+
+		// the location of other sessions can not be decrypted
+		assert.Equal(t, otherSessionId, res.Sessions[1].Id)
+		assert.Nil(t, res.Sessions[1].Location)
+		assert.False(t, res.Sessions[1].Current)
+
+		sess := res.Sessions[0]
+		assert.Equal(t, sessionId, sess.Id)
+		assert.Equal(t, "google", sess.Issuer)
+		assert.Equal(t, "GoogleB", sess.IdAtIssuer)
+		assert.True(t, sess.CreationTime.Equal(creationTime.Truncate(time.Microsecond)))
+		assert.NotNil(t, sess.LastSeenTime)
+		assert.True(t, sess.ExpirationTime.After(creationTime))
+		assert.Equal(t, &accountFederationSessionLocation{Lat: 52.5, Lng: 13.4}, sess.Location)
+		assert.True(t, sess.Current)
+	}
 }
 
 func testDeleteAccountEndpointUnauthorized(t *testing.T, pool *pgxpool.Pool, conv *service.SessionJwtConverter, truncateTablesFn func() error) {
@@ -165,3 +189,39 @@ func testDeleteAccountFederationSessionEndpointCurrentSession(t *testing.T, pool
 		sessionId,
 	)
 }
+
+func testDeleteOtherAccountFederationSessionsEndpointUnauthorized(t *testing.T, pool *pgxpool.Pool, conv *service.SessionJwtConverter, truncateTablesFn func() error) {
+	e := newEchoWithMiddleware(pool, conv)
+	e.DELETE("/", DeleteOtherAccountFederationSessionsEndpoint())
+	test.MustUnauthorized(t, e, httptest.NewRequest(http.MethodDelete, "/", nil))
+}
+
+func testDeleteOtherAccountFederationSessionsEndpointSimple(t *testing.T, pool *pgxpool.Pool, conv *service.SessionJwtConverter, truncateTablesFn func() error) {
+	test.CreateAccountAndFederation(t, pool, test.NewUUID(t), "google", "GoogleA", time.Now())
+	foreignSessionId := test.NewUUID(t).String()
+	test.CreateSession(t, pool, foreignSessionId, "google", "GoogleA", time.Now(), time.Now().Add(time.Hour), []byte{1})
+
+	req := httptest.NewRequest(http.MethodDelete, "/", nil)
+	accountId, sessionId, _, _ := test.Authenticated(t, req, pool, conv, "google", "GoogleB")
+
+	test.CreateAccountFederation(t, pool, accountId, "cognito", "CognitoB")
+	otherSessionIds := []string{test.NewUUID(t).String(), test.NewUUID(t).String()}
+	test.CreateSession(t, pool, otherSessionIds[0], "google", "GoogleB", time.Now(), time.Now().Add(time.Hour), []byte{1})
+	test.CreateSession(t, pool, otherSessionIds[1], "cognito", "CognitoB", time.Now(), time.Now().Add(time.Hour), []byte{1})
+
+	e := newEchoWithMiddleware(pool, conv)
+	e.DELETE("/", DeleteOtherAccountFederationSessionsEndpoint())
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.JSONEq(t, `{"deleted": 2}`, rec.Body.String())
+
+	test.MustExist(t, pool, "SELECT TRUE FROM account_federation_sessions WHERE id = $1", sessionId)
+	test.MustExist(t, pool, "SELECT TRUE FROM account_federation_sessions WHERE id = $1", foreignSessionId)
+	for _, id := range otherSessionIds {
+		test.MustNotExist(t, pool, "SELECT TRUE FROM account_federation_sessions WHERE id = $1", id)
+	}
+
+	test.MustExist(t, pool, `SELECT TRUE FROM account_logs WHERE account_id = $1 AND type = 'other_sessions_deleted' AND fields = '{"count": 2}'::JSONB`, accountId)
+}
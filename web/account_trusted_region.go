@@ -0,0 +1,178 @@
+package web
+
+import (
+	"context"
+	"github.com/gofrs/uuid/v5"
+	"github.com/gw2auth/gw2auth.com-api/service/accountlog"
+	"github.com/gw2auth/gw2auth.com-api/service/auth"
+	"github.com/gw2auth/gw2auth.com-api/util"
+	"github.com/jackc/pgx/v5"
+	"github.com/labstack/echo/v4"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+const maxTrustedRegionRadiusKm = 500
+
+type accountTrustedRegion struct {
+	Id           uuid.UUID `json:"id"`
+	CreationTime time.Time `json:"creationTime"`
+	DisplayName  string    `json:"displayName"`
+	Lat          float64   `json:"lat"`
+	Lng          float64   `json:"lng"`
+	RadiusKm     float64   `json:"radiusKm"`
+}
+
+type accountTrustedRegionCreate struct {
+	DisplayName string  `json:"displayName"`
+	Lat         float64 `json:"lat"`
+	Lng         float64 `json:"lng"`
+	RadiusKm    float64 `json:"radiusKm"`
+}
+
+func AccountTrustedRegionsEndpoint() echo.HandlerFunc {
+	return wrapAuthenticatedHandlerFunc(func(c echo.Context, rctx RequestContext, session auth.Session) error {
+		ctx := c.Request().Context()
+		results := make([]accountTrustedRegion, 0)
+		err := rctx.ExecuteTx(ctx, pgx.TxOptions{AccessMode: pgx.ReadOnly}, func(tx pgx.Tx) error {
+			var err error
+			results, err = selectAccountTrustedRegions(ctx, tx, session.AccountId)
+			return err
+		})
+
+		if err != nil {
+			return util.NewEchoPgxHTTPError(err)
+		}
+
+		return c.JSON(http.StatusOK, results)
+	})
+}
+
+func CreateAccountTrustedRegionEndpoint() echo.HandlerFunc {
+	return wrapAuthenticatedHandlerFunc(func(c echo.Context, rctx RequestContext, session auth.Session) error {
+		var body accountTrustedRegionCreate
+		if err := c.Bind(&body); err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, err)
+		}
+
+		if body.DisplayName == "" || len(body.DisplayName) > 100 {
+			return echo.NewHTTPError(http.StatusBadRequest, "displayname must be between 1 and 100 characters")
+		} else if body.Lat < -90 || body.Lat > 90 || body.Lng < -180 || body.Lng > 180 {
+			return echo.NewHTTPError(http.StatusBadRequest, "lat must be between -90 and 90, lng must be between -180 and 180")
+		} else if body.RadiusKm <= 0 || body.RadiusKm > maxTrustedRegionRadiusKm {
+			return echo.NewHTTPError(http.StatusBadRequest, "radiuskm must be greater than 0 and at most 500")
+		}
+
+		regionId, err := uuid.NewV4()
+		if err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, err)
+		}
+
+		ctx := c.Request().Context()
+		slog.InfoContext(
+			ctx,
+			"creating trusted region",
+			slog.String("trusted_region.id", regionId.String()),
+			slog.Float64("trusted_region.radius_km", body.RadiusKm),
+		)
+
+		err = rctx.ExecuteTx(ctx, pgx.TxOptions{}, func(tx pgx.Tx) error {
+			const sql = `
+INSERT INTO account_trusted_regions
+(id, account_id, creation_time, display_name, lat, lng, radius_km)
+VALUES
+($1, $2, $3, $4, $5, $6, $7)
+`
+			if _, err := tx.Exec(ctx, sql, regionId, session.AccountId, time.Now(), body.DisplayName, body.Lat, body.Lng, body.RadiusKm); err != nil {
+				return err
+			}
+
+			return accountlog.Write(ctx, tx, session.AccountId, accountlog.TypeTrustedRegionCreated, accountlog.Fields{
+				"trustedRegionId": regionId,
+				"displayName":     body.DisplayName,
+			})
+		})
+
+		if err != nil {
+			return util.NewEchoPgxHTTPError(err)
+		}
+
+		return c.JSON(http.StatusOK, map[string]any{
+			"id": regionId,
+		})
+	})
+}
+
+func DeleteAccountTrustedRegionEndpoint() echo.HandlerFunc {
+	return wrapAuthenticatedHandlerFunc(func(c echo.Context, rctx RequestContext, session auth.Session) error {
+		regionId, err := uuid.FromString(c.Param("id"))
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, err)
+		}
+
+		ctx := c.Request().Context()
+		slog.InfoContext(
+			ctx,
+			"deleting trusted region",
+			slog.String("trusted_region.id", regionId.String()),
+		)
+
+		var deleted bool
+		err = rctx.ExecuteTx(ctx, pgx.TxOptions{}, func(tx pgx.Tx) error {
+			const sql = `
+DELETE FROM account_trusted_regions
+WHERE account_id = $1
+AND id = $2
+`
+			tag, err := tx.Exec(ctx, sql, session.AccountId, regionId)
+			if err != nil {
+				return err
+			}
+
+			deleted = tag.RowsAffected() > 0
+			if !deleted {
+				return nil
+			}
+
+			return accountlog.Write(ctx, tx, session.AccountId, accountlog.TypeTrustedRegionDeleted, accountlog.Fields{
+				"trustedRegionId": regionId,
+			})
+		})
+
+		if err != nil {
+			return util.NewEchoPgxHTTPError(err)
+		}
+
+		if !deleted {
+			return echo.NewHTTPError(http.StatusNotFound, "the trusted region does not exist")
+		}
+
+		return c.JSON(http.StatusOK, map[string]string{})
+	})
+}
+
+func selectAccountTrustedRegions(ctx context.Context, tx pgx.Tx, accountId uuid.UUID) ([]accountTrustedRegion, error) {
+	const sql = `
+SELECT id, creation_time, display_name, lat, lng, radius_km
+FROM account_trusted_regions
+WHERE account_id = $1
+ORDER BY creation_time
+`
+	rows, err := tx.Query(ctx, sql, accountId)
+	if err != nil {
+		return nil, err
+	}
+
+	return pgx.CollectRows(rows, func(row pgx.CollectableRow) (accountTrustedRegion, error) {
+		var r accountTrustedRegion
+		return r, row.Scan(
+			&r.Id,
+			&r.CreationTime,
+			&r.DisplayName,
+			&r.Lat,
+			&r.Lng,
+			&r.RadiusKm,
+		)
+	})
+}
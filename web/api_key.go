@@ -1,7 +1,6 @@
 package web
 
 import (
-	"errors"
 	"github.com/gofrs/uuid/v5"
 	"github.com/gw2auth/gw2auth.com-api/service/auth"
 	"github.com/gw2auth/gw2auth.com-api/util"
@@ -17,6 +16,77 @@ type modifyRedirectURIsRequest struct {
 	Remove []string `json:"remove"`
 }
 
+func APIKeyDevApplicationEndpoint() echo.HandlerFunc {
+	return wrapApiKeyAuthenticatedHandlerFunc(func(c echo.Context, rctx RequestContext, apiKey auth.ApiKey) error {
+		return serveDevApplication(c, rctx, apiKey.AccountId, apiKey.ApplicationId)
+	})
+}
+
+func APIKeyDevApplicationUsersEndpoint() echo.HandlerFunc {
+	return wrapApiKeyAuthenticatedHandlerFunc(func(c echo.Context, rctx RequestContext, apiKey auth.ApiKey) error {
+		return serveDevApplicationUsers(c, rctx, apiKey.AccountId, apiKey.ApplicationId)
+	})
+}
+
+func APIKeyDevApplicationClientEndpoint() echo.HandlerFunc {
+	return wrapApiKeyAuthenticatedHandlerFunc(func(c echo.Context, rctx RequestContext, apiKey auth.ApiKey) error {
+		clientId, err := uuid.FromString(c.Param("client_id"))
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, err)
+		}
+
+		return serveDevApplicationClient(c, rctx, apiKey.AccountId, apiKey.ApplicationId, clientId)
+	})
+}
+
+func APIKeyCreateDevApplicationClientEndpoint() echo.HandlerFunc {
+	return wrapApiKeyAuthenticatedHandlerFunc(func(c echo.Context, rctx RequestContext, apiKey auth.ApiKey) error {
+		return createDevApplicationClient(c, rctx, apiKey.AccountId, apiKey.ApplicationId)
+	})
+}
+
+func APIKeyRegenerateDevApplicationClientSecretEndpoint() echo.HandlerFunc {
+	return wrapApiKeyAuthenticatedHandlerFunc(func(c echo.Context, rctx RequestContext, apiKey auth.ApiKey) error {
+		clientId, err := uuid.FromString(c.Param("client_id"))
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, err)
+		}
+
+		return regenerateDevApplicationClientSecret(c, rctx, apiKey.AccountId, apiKey.ApplicationId, clientId)
+	})
+}
+
+func APIKeyDeleteDevApplicationClientEndpoint() echo.HandlerFunc {
+	return wrapApiKeyAuthenticatedHandlerFunc(func(c echo.Context, rctx RequestContext, apiKey auth.ApiKey) error {
+		clientId, err := uuid.FromString(c.Param("client_id"))
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, err)
+		}
+
+		return deleteDevApplicationClient(c, rctx, apiKey.AccountId, apiKey.ApplicationId, clientId)
+	})
+}
+
+func APIKeyUpdateDevApplicationClientUserEndpoint() echo.HandlerFunc {
+	return wrapApiKeyAuthenticatedHandlerFunc(func(c echo.Context, rctx RequestContext, apiKey auth.ApiKey) error {
+		var clientId, userId uuid.UUID
+		if values, err := util.EchoAllParams(c, uuid.FromString, "client_id", "user_id"); err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, err)
+		} else {
+			clientId, userId = values[0], values[1]
+		}
+
+		return updateDevApplicationClientUser(c, rctx, apiKey.AccountId, apiKey.ApplicationId, clientId, userId)
+	})
+}
+
+// APIKeyRotateEndpoint rotates the key used to authenticate the request
+func APIKeyRotateEndpoint(apiKeyCache *auth.APIKeyCache) echo.HandlerFunc {
+	return wrapApiKeyAuthenticatedHandlerFunc(func(c echo.Context, rctx RequestContext, apiKey auth.ApiKey) error {
+		return rotateDevApplicationAPIKey(c, rctx, apiKeyCache, apiKey.AccountId, apiKey.ApplicationId, apiKey.Id)
+	})
+}
+
 func ModifyDevApplicationClientRedirectURIsEndpoint() echo.HandlerFunc {
 	return wrapApiKeyAuthenticatedHandlerFunc(func(c echo.Context, rctx RequestContext, apiKey auth.ApiKey) error {
 		var err error
@@ -32,7 +102,7 @@ func ModifyDevApplicationClientRedirectURIsEndpoint() echo.HandlerFunc {
 
 		body.Add = preprocessRedirectURIs(apiKey.ApplicationId, clientId, body.Add)
 		if len(body.Add) > 50 {
-			return echo.NewHTTPError(http.StatusBadRequest, errors.New("at most 50 redirect URIs may be added"))
+			return echo.NewHTTPError(http.StatusBadRequest, "at most 50 redirect URIs may be added")
 		}
 
 		if err = validateRedirectURIs(body.Add); err != nil {
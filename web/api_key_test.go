@@ -0,0 +1,354 @@
+package web
+
+import (
+	"encoding/json"
+	"github.com/gofrs/uuid/v5"
+	"github.com/gw2auth/gw2auth.com-api/internal/test"
+	"github.com/gw2auth/gw2auth.com-api/service"
+	"github.com/gw2auth/gw2auth.com-api/service/auth"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAPIKeyAll(t *testing.T) {
+	test.RunAll(t, dbScope, map[string]map[string]test.Fn{
+		"APIKeyDevApplicationEndpoint": {
+			"read":               testAPIKeyDevApplicationEndpointRead,
+			"missing permission": testAPIKeyDevApplicationEndpointMissingPermission,
+		},
+		"APIKeyUsageRecorder": {
+			"usage is flushed": testAPIKeyUsageRecorderFlush,
+		},
+		"APIKeyCreateDevApplicationClientEndpoint": {
+			"create": testAPIKeyCreateDevApplicationClientEndpointCreate,
+		},
+		"APIKeyRegenerateDevApplicationClientSecretEndpoint": {
+			"regenerate": testAPIKeyRegenerateDevApplicationClientSecretEndpointRegenerate,
+		},
+		"APIKeyDeleteDevApplicationClientEndpoint": {
+			"delete":                      testAPIKeyDeleteDevApplicationClientEndpointDelete,
+			"client of other application": testAPIKeyDeleteDevApplicationClientEndpointOtherApplication,
+		},
+	})
+}
+
+func testAPIKeyDevApplicationEndpointRead(t *testing.T, pool *pgxpool.Pool, conv *service.SessionJwtConverter, truncateTablesFn func() error) {
+	_, keyId := createApplicationWithAPIKey(t, pool, auth.PermissionRead)
+
+	e := newEchoWithAPIKeyMiddleware(pool)
+	e.GET("/", APIKeyDevApplicationEndpoint(), ApplicationAPIKeyPermissionMiddleware(auth.PermissionRead))
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.SetBasicAuth(keyId.String(), "secret")
+
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+
+	if !assert.Equal(t, http.StatusOK, rec.Code) {
+		return
+	}
+
+	var res devApplication
+	if assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &res)) {
+		assert.Equal(t, "App", res.DisplayName)
+		if assert.Len(t, res.ApiKeys, 1) {
+			assert.Equal(t, keyId, res.ApiKeys[0].Id)
+		}
+	}
+}
+
+func testAPIKeyDevApplicationEndpointMissingPermission(t *testing.T, pool *pgxpool.Pool, conv *service.SessionJwtConverter, truncateTablesFn func() error) {
+	_, keyId := createApplicationWithAPIKey(t, pool, auth.PermissionClientModify)
+
+	e := newEchoWithAPIKeyMiddleware(pool)
+	e.GET("/", APIKeyDevApplicationEndpoint(), ApplicationAPIKeyPermissionMiddleware(auth.PermissionRead))
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.SetBasicAuth(keyId.String(), "secret")
+
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+}
+
+func testAPIKeyUsageRecorderFlush(t *testing.T, pool *pgxpool.Pool, conv *service.SessionJwtConverter, truncateTablesFn func() error) {
+	_, keyId := createApplicationWithAPIKey(t, pool, auth.PermissionRead)
+	usage := auth.NewAPIKeyUsageRecorder(pool, 100, time.Minute)
+
+	e := echo.New()
+	e.HTTPErrorHandler = HTTPErrorHandler
+	e.Use(Middleware(pool), ApplicationAPIKeyAuthenticatedMiddleware(WithAPIKeyUsageRecorder(usage)))
+	e.GET("/", APIKeyDevApplicationEndpoint(), ApplicationAPIKeyPermissionMiddleware(auth.PermissionRead))
+
+	for range 2 {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.SetBasicAuth(keyId.String(), "secret")
+		req.Header.Set("Cloudfront-Viewer-Latitude", "52.5163")
+		req.Header.Set("Cloudfront-Viewer-Longitude", "13.3777")
+
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+		assert.Equal(t, http.StatusOK, rec.Code)
+	}
+
+	// nothing is written until the usage is flushed
+	test.MustExist(t, pool, `SELECT 1 FROM application_api_keys WHERE id = $1 AND last_used_time IS NULL AND request_count = 0`, keyId)
+
+	if !assert.NoError(t, usage.Flush(t.Context())) {
+		return
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.SetBasicAuth(keyId.String(), "secret")
+
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+
+	if !assert.Equal(t, http.StatusOK, rec.Code) {
+		return
+	}
+
+	var res devApplication
+	if assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &res)) && assert.Len(t, res.ApiKeys, 1) {
+		key := res.ApiKeys[0]
+		assert.NotNil(t, key.LastUsedTime)
+		assert.Equal(t, &accountFederationSessionLocation{Lat: 52.5, Lng: 13.4}, key.LastUsedLocation)
+		assert.Equal(t, int64(2), key.RequestCount)
+	}
+}
+
+func testAPIKeyCreateDevApplicationClientEndpointCreate(t *testing.T, pool *pgxpool.Pool, conv *service.SessionJwtConverter, truncateTablesFn func() error) {
+	applicationId, keyId := createApplicationWithAPIKey(t, pool, auth.PermissionClientCreate)
+
+	e := newEchoWithAPIKeyMiddleware(pool)
+	e.PUT("/", APIKeyCreateDevApplicationClientEndpoint(), ApplicationAPIKeyPermissionMiddleware(auth.PermissionClientCreate))
+
+	req := httptest.NewRequest(http.MethodPut, "/", strings.NewReader(`{"displayName":"Preview","redirectURIs":["https://preview.example.com/callback"]}`))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	req.SetBasicAuth(keyId.String(), "secret")
+
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+
+	if !assert.Equal(t, http.StatusOK, rec.Code) {
+		return
+	}
+
+	var res devApplicationClientCreateResponse
+	if assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &res)) {
+		assert.Equal(t, "Preview", res.DisplayName)
+		assert.NotEmpty(t, res.ClientSecret)
+		test.MustExist(t, pool, `SELECT 1 FROM application_clients WHERE id = $1 AND application_id = $2`, res.Id, applicationId)
+	}
+}
+
+func testAPIKeyRegenerateDevApplicationClientSecretEndpointRegenerate(t *testing.T, pool *pgxpool.Pool, conv *service.SessionJwtConverter, truncateTablesFn func() error) {
+	applicationId, keyId := createApplicationWithAPIKey(t, pool, auth.PermissionClientSecret)
+	clientId := test.NewUUID(t)
+	test.CreateApplicationClient(t, pool, applicationId, clientId, "Preview", []string{"https://preview.example.com/callback"})
+
+	e := newEchoWithAPIKeyMiddleware(pool)
+	e.POST("/:client_id", APIKeyRegenerateDevApplicationClientSecretEndpoint(), ApplicationAPIKeyPermissionMiddleware(auth.PermissionClientSecret))
+
+	req := httptest.NewRequest(http.MethodPost, "/"+clientId.String(), nil)
+	req.SetBasicAuth(keyId.String(), "secret")
+
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+
+	if !assert.Equal(t, http.StatusOK, rec.Code) {
+		return
+	}
+
+	var res map[string]string
+	if assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &res)) {
+		assert.NotEmpty(t, res["clientSecret"])
+	}
+
+	test.MustNotExist(t, pool, `SELECT 1 FROM application_clients WHERE id = $1 AND client_secret = 'secret'`, clientId)
+}
+
+func testAPIKeyDeleteDevApplicationClientEndpointDelete(t *testing.T, pool *pgxpool.Pool, conv *service.SessionJwtConverter, truncateTablesFn func() error) {
+	applicationId, keyId := createApplicationWithAPIKey(t, pool, auth.PermissionClientDelete)
+	clientId := test.NewUUID(t)
+	test.CreateApplicationClient(t, pool, applicationId, clientId, "Preview", []string{"https://preview.example.com/callback"})
+
+	e := newEchoWithAPIKeyMiddleware(pool)
+	e.DELETE("/:client_id", APIKeyDeleteDevApplicationClientEndpoint(), ApplicationAPIKeyPermissionMiddleware(auth.PermissionClientDelete))
+
+	req := httptest.NewRequest(http.MethodDelete, "/"+clientId.String(), nil)
+	req.SetBasicAuth(keyId.String(), "secret")
+
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+
+	assert.Equal(t, http.StatusOK, rec.Code)
+	test.MustNotExist(t, pool, `SELECT 1 FROM application_clients WHERE id = $1`, clientId)
+}
+
+func testAPIKeyDeleteDevApplicationClientEndpointOtherApplication(t *testing.T, pool *pgxpool.Pool, conv *service.SessionJwtConverter, truncateTablesFn func() error) {
+	_, keyId := createApplicationWithAPIKey(t, pool, auth.PermissionClientDelete)
+	otherApplicationId, _ := createApplicationWithAPIKey(t, pool, auth.PermissionClientDelete)
+	clientId := test.NewUUID(t)
+	test.CreateApplicationClient(t, pool, otherApplicationId, clientId, "Preview", []string{"https://preview.example.com/callback"})
+
+	e := newEchoWithAPIKeyMiddleware(pool)
+	e.DELETE("/:client_id", APIKeyDeleteDevApplicationClientEndpoint(), ApplicationAPIKeyPermissionMiddleware(auth.PermissionClientDelete))
+
+	req := httptest.NewRequest(http.MethodDelete, "/"+clientId.String(), nil)
+	req.SetBasicAuth(keyId.String(), "secret")
+
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+	test.MustExist(t, pool, `SELECT 1 FROM application_clients WHERE id = $1`, clientId)
+}
+
+func testAPIKeyRotateEndpointRotate(t *testing.T, pool *pgxpool.Pool, conv *service.SessionJwtConverter, truncateTablesFn func() error) {
+	applicationId, keyId := createApplicationWithAPIKey(t, pool, auth.PermissionRead, auth.PermissionAPIKeyRotate)
+
+	e := newEchoWithAPIKeyMiddleware(pool)
+	e.POST("/rotate", APIKeyRotateEndpoint(nil), ApplicationAPIKeyPermissionMiddleware(auth.PermissionAPIKeyRotate))
+	e.GET("/", APIKeyDevApplicationEndpoint(), ApplicationAPIKeyPermissionMiddleware(auth.PermissionRead))
+
+	req := httptest.NewRequest(http.MethodPost, "/rotate", strings.NewReader(`{"gracePeriod":"10m"}`))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	req.SetBasicAuth(keyId.String(), "secret")
+
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+
+	if !assert.Equal(t, http.StatusOK, rec.Code) {
+		return
+	}
+
+	var res devApplicationApiKeyRotateResponse
+	if !assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &res)) {
+		return
+	}
+
+	assert.Equal(t, keyId, res.RotatedFromId)
+	assert.Equal(t, []auth.Permission{auth.PermissionRead, auth.PermissionAPIKeyRotate}, res.Permissions)
+	assert.WithinDuration(t, time.Now().Add(10*time.Minute), res.RotatedFromExpiresAt, time.Minute)
+	assert.WithinDuration(t, time.Now().Add(time.Hour), res.ExpiresAt, time.Minute)
+	test.MustExist(t, pool, `SELECT 1 FROM application_api_keys WHERE id = $1 AND application_id = $2 AND rotated_from_id = $3`, res.Id, applicationId, keyId)
+
+	// both keys are valid during the grace period
+	for _, id := range []uuid.UUID{keyId, res.Id} {
+		secret := "secret"
+		if id == res.Id {
+			secret = res.Key
+		}
+
+		req = httptest.NewRequest(http.MethodGet, "/", nil)
+		req.SetBasicAuth(id.String(), secret)
+
+		rec = httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+
+		if !assert.Equal(t, http.StatusOK, rec.Code) {
+			return
+		}
+
+		var app devApplication
+		if assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &app)) && assert.Len(t, app.ApiKeys, 2) {
+			for _, key := range app.ApiKeys {
+				if key.Id == res.Id {
+					assert.Equal(t, &keyId, key.RotatedFromId)
+				} else {
+					assert.Nil(t, key.RotatedFromId)
+				}
+			}
+		}
+	}
+}
+
+func testAPIKeyRotateEndpointInvalidGracePeriod(t *testing.T, pool *pgxpool.Pool, conv *service.SessionJwtConverter, truncateTablesFn func() error) {
+	_, keyId := createApplicationWithAPIKey(t, pool, auth.PermissionAPIKeyRotate)
+
+	e := newEchoWithAPIKeyMiddleware(pool)
+	e.POST("/", APIKeyRotateEndpoint(nil), ApplicationAPIKeyPermissionMiddleware(auth.PermissionAPIKeyRotate))
+
+	req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(`{"gracePeriod":"-1h"}`))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	req.SetBasicAuth(keyId.String(), "secret")
+
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	test.MustNotExist(t, pool, `SELECT 1 FROM application_api_keys WHERE rotated_from_id = $1`, keyId)
+}
+
+func testAPIKeyRotateEndpointMissingPermission(t *testing.T, pool *pgxpool.Pool, conv *service.SessionJwtConverter, truncateTablesFn func() error) {
+	_, keyId := createApplicationWithAPIKey(t, pool, auth.PermissionRead)
+
+	e := newEchoWithAPIKeyMiddleware(pool)
+	e.POST("/", APIKeyRotateEndpoint(nil), ApplicationAPIKeyPermissionMiddleware(auth.PermissionAPIKeyRotate))
+
+	req := httptest.NewRequest(http.MethodPost, "/", nil)
+	req.SetBasicAuth(keyId.String(), "secret")
+
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+	test.MustNotExist(t, pool, `SELECT 1 FROM application_api_keys WHERE rotated_from_id = $1`, keyId)
+}
+
+func testAPIKeyRotateEndpointAlreadyRotated(t *testing.T, pool *pgxpool.Pool, conv *service.SessionJwtConverter, truncateTablesFn func() error) {
+	_, keyId := createApplicationWithAPIKey(t, pool, auth.PermissionAPIKeyRotate)
+
+	e := newEchoWithAPIKeyMiddleware(pool)
+	e.POST("/", APIKeyRotateEndpoint(nil), ApplicationAPIKeyPermissionMiddleware(auth.PermissionAPIKeyRotate))
+
+	for _, expected := range []int{http.StatusOK, http.StatusConflict} {
+		req := httptest.NewRequest(http.MethodPost, "/", nil)
+		req.SetBasicAuth(keyId.String(), "secret")
+
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+
+		assert.Equal(t, expected, rec.Code)
+	}
+
+	test.MustExist(t, pool, `SELECT 1 FROM application_api_keys WHERE rotated_from_id = $1 HAVING COUNT(*) = 1`, keyId)
+}
+
+func createApplicationWithAPIKey(t *testing.T, pool *pgxpool.Pool, perms ...auth.Permission) (applicationId, keyId uuid.UUID) {
+	accountId := test.NewUUID(t)
+	applicationId = test.NewUUID(t)
+	test.CreateAccount(t, pool, accountId, time.Now())
+	test.CreateApplication(t, pool, accountId, applicationId, "App")
+
+	encoded, err := service.DefaultArgon2Policy.Encode([]byte("secret"))
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+
+	rawPerms := make([]string, 0, len(perms))
+	for _, perm := range perms {
+		rawPerms = append(rawPerms, string(perm))
+	}
+
+	keyId = test.NewUUID(t)
+	test.CreateApplicationAPIKey(t, pool, applicationId, keyId, encoded, rawPerms, time.Now().Add(-time.Hour), time.Now().Add(time.Hour))
+
+	return applicationId, keyId
+}
+
+func newEchoWithAPIKeyMiddleware(pool *pgxpool.Pool) *echo.Echo {
+	e := echo.New()
+	e.HTTPErrorHandler = HTTPErrorHandler
+	e.Use(Middleware(pool), ApplicationAPIKeyAuthenticatedMiddleware())
+
+	return e
+}
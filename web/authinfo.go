@@ -9,12 +9,13 @@ import (
 
 func AuthInfoEndpoint() echo.HandlerFunc {
 	return wrapAuthenticatedHandlerFunc(func(c echo.Context, rctx RequestContext, session auth.Session) error {
-		return c.JSON(http.StatusOK, map[string]string{
+		return c.JSON(http.StatusOK, map[string]any{
 			"sessionId":           session.Id,
 			"sessionCreationTime": session.CreationTime.Format(time.RFC3339),
 			"accountCreationTime": session.AccountCreationTime.Format(time.RFC3339),
 			"issuer":              session.Issuer,
 			"idAtIssuer":          session.IdAtIssuer,
+			"stepUpRequired":      session.StepUpRequired,
 		})
 	})
 }
@@ -2,6 +2,7 @@ package web
 
 import (
 	"bytes"
+	"context"
 	"encoding/base64"
 	"encoding/binary"
 	"encoding/json"
@@ -9,6 +10,7 @@ import (
 	"fmt"
 	"github.com/gofrs/uuid/v5"
 	"github.com/gw2auth/gw2auth.com-api/service"
+	"github.com/gw2auth/gw2auth.com-api/service/accountlog"
 	"github.com/gw2auth/gw2auth.com-api/service/auth"
 	"github.com/gw2auth/gw2auth.com-api/util"
 	"github.com/jackc/pgx/v5"
@@ -44,14 +46,19 @@ type devApplicationClientForList struct {
 	DisplayName      string    `json:"displayName"`
 	ApiVersion       uint32    `json:"apiVersion"`
 	Type             string    `json:"type"`
+	RedirectURIs     []string  `json:"redirectURIs"`
 	RequiresApproval bool      `json:"requiresApproval"`
 }
 
 type devApplicationApiKeyForList struct {
-	Id          uuid.UUID         `json:"id"`
-	Permissions []auth.Permission `json:"permissions"`
-	NotBefore   time.Time         `json:"notBefore"`
-	ExpiresAt   time.Time         `json:"expiresAt"`
+	Id               uuid.UUID                         `json:"id"`
+	Permissions      []auth.Permission                 `json:"permissions"`
+	NotBefore        time.Time                         `json:"notBefore"`
+	ExpiresAt        time.Time                         `json:"expiresAt"`
+	LastUsedTime     *time.Time                        `json:"lastUsedTime,omitempty"`
+	LastUsedLocation *accountFederationSessionLocation `json:"lastUsedLocation,omitempty"`
+	RequestCount     int64                             `json:"requestCount"`
+	RotatedFromId    *uuid.UUID                        `json:"rotatedFromId,omitempty"`
 }
 
 type pagedResult[T any] struct {
@@ -96,6 +103,17 @@ type devApplicationApiKeyCreateResponse struct {
 	ExpiresAt   time.Time         `json:"expiresAt"`
 }
 
+type devApplicationApiKeyRotateRequest struct {
+	// GracePeriod is how long the rotated key stays valid, e.g. "24h"
+	GracePeriod string `json:"gracePeriod,omitempty"`
+}
+
+type devApplicationApiKeyRotateResponse struct {
+	devApplicationApiKeyCreateResponse
+	RotatedFromId        uuid.UUID `json:"rotatedFromId"`
+	RotatedFromExpiresAt time.Time `json:"rotatedFromExpiresAt"`
+}
+
 func CreateDevApplicationEndpoint() echo.HandlerFunc {
 	return wrapAuthenticatedHandlerFunc(func(c echo.Context, rctx RequestContext, session auth.Session) error {
 		var body devApplicationCreate
@@ -104,7 +122,7 @@ func CreateDevApplicationEndpoint() echo.HandlerFunc {
 		}
 
 		if body.DisplayName == "" || len(body.DisplayName) > 100 {
-			return echo.NewHTTPError(http.StatusBadRequest, errors.New("displayname must be between 1 and 100 characters"))
+			return echo.NewHTTPError(http.StatusBadRequest, "displayname must be between 1 and 100 characters")
 		}
 
 		applicationId, err := uuid.NewV4()
@@ -141,7 +159,7 @@ VALUES
 	})
 }
 
-func DeleteDevApplicationEndpoint() echo.HandlerFunc {
+func DeleteDevApplicationEndpoint(apiKeyCache *auth.APIKeyCache) echo.HandlerFunc {
 	return wrapAuthenticatedHandlerFunc(func(c echo.Context, rctx RequestContext, session auth.Session) error {
 		applicationId, err := uuid.FromString(c.Param("id"))
 		if err != nil {
@@ -176,9 +194,11 @@ AND id = $2
 		}
 
 		if !deleted {
-			return echo.NewHTTPError(http.StatusNotFound, errors.New("the application does not exist"))
+			return echo.NewHTTPError(http.StatusNotFound, "the application does not exist")
 		}
 
+		apiKeyCache.InvalidateApplication(applicationId)
+
 		return c.JSON(http.StatusOK, map[string]string{})
 	})
 }
@@ -188,37 +208,8 @@ func DevApplicationsEndpoint() echo.HandlerFunc {
 		ctx := c.Request().Context()
 		var results []devApplicationForList
 		err := rctx.ExecuteTx(ctx, pgx.TxOptions{AccessMode: pgx.ReadOnly}, func(tx pgx.Tx) error {
-			const sql = `
-SELECT
-    apps.id,
-    MAX(apps.creation_time),
-    MAX(apps.display_name),
-    COUNT(DISTINCT app_clients.id),
-    COUNT(DISTINCT app_accs.account_id)
-FROM applications apps
-LEFT JOIN application_clients app_clients
-ON apps.id = app_clients.application_id
-LEFT JOIN application_accounts app_accs
-ON apps.id = app_accs.application_id
-WHERE apps.account_id = $1
-GROUP BY apps.id
-`
-			rows, err := tx.Query(ctx, sql, session.AccountId)
-			if err != nil {
-				return err
-			}
-
-			results, err = pgx.CollectRows(rows, func(row pgx.CollectableRow) (devApplicationForList, error) {
-				var app devApplicationForList
-				return app, row.Scan(
-					&app.Id,
-					&app.CreationTime,
-					&app.DisplayName,
-					&app.ClientCount,
-					&app.UserCount,
-				)
-			})
-
+			var err error
+			results, err = selectDevApplications(ctx, tx, session.AccountId)
 			return err
 		})
 
@@ -238,108 +229,87 @@ func DevApplicationEndpoint() echo.HandlerFunc {
 			return echo.NewHTTPError(http.StatusBadRequest, err)
 		}
 
-		ctx := c.Request().Context()
-		var result devApplication
-		err = rctx.ExecuteTx(ctx, pgx.TxOptions{AccessMode: pgx.ReadOnly}, func(tx pgx.Tx) error {
-			const sql = `
-SELECT
-    MAX(app.creation_time),
-    MAX(app.display_name),
-    COALESCE(ARRAY_AGG(DISTINCT JSONB_BUILD_OBJECT(
-		'id', app_clients.id,
-		'creationTime', app_clients.creation_time,
-        'displayName', app_clients.display_name,
-        'apiVersion', app_clients.api_version,
-        'type', app_clients.type,
-        'requiresApproval', app_clients.requires_approval
-	)) FILTER ( WHERE app_clients.id IS NOT NULL ), ARRAY[]::JSONB[]),
-    COALESCE(ARRAY_AGG(DISTINCT JSONB_BUILD_OBJECT(
-		'id', app_api_keys.id,
-		'permissions', app_api_keys.permissions,
-        'notBefore', app_api_keys.not_before,
-        'expiresAt', app_api_keys.expires_at
-	)) FILTER ( WHERE app_api_keys.id IS NOT NULL ), ARRAY[]::JSONB[])
-FROM applications app
-LEFT JOIN application_clients app_clients
-ON app.id = app_clients.application_id
-LEFT JOIN application_api_keys app_api_keys
-ON app.id = app_api_keys.application_id
-WHERE app.account_id = $1
-AND app.id = $2
-GROUP BY app.id
-`
-			return tx.QueryRow(ctx, sql, session.AccountId, applicationId).Scan(
-				&result.CreationTime,
-				&result.DisplayName,
-				&result.Clients,
-				&result.ApiKeys,
-			)
-		})
+		return serveDevApplication(c, rctx, session.AccountId, applicationId)
+	})
+}
 
+func serveDevApplication(c echo.Context, rctx RequestContext, accountId, applicationId uuid.UUID) error {
+	ctx := c.Request().Context()
+	var result devApplication
+	err := rctx.ExecuteTx(ctx, pgx.TxOptions{AccessMode: pgx.ReadOnly}, func(tx pgx.Tx) error {
+		var err error
+		result, err = selectDevApplication(ctx, tx, accountId, applicationId)
+		return err
+	})
+
+	if err != nil {
+		return util.NewEchoPgxHTTPError(err)
+	}
+
+	return c.JSON(http.StatusOK, result)
+}
+
+func DevApplicationUsersEndpoint() echo.HandlerFunc {
+	return wrapAuthenticatedHandlerFunc(func(c echo.Context, rctx RequestContext, session auth.Session) error {
+		applicationId, err := uuid.FromString(c.Param("id"))
 		if err != nil {
-			return util.NewEchoPgxHTTPError(err)
+			return echo.NewHTTPError(http.StatusBadRequest, err)
 		}
 
-		return c.JSON(http.StatusOK, result)
+		return serveDevApplicationUsers(c, rctx, session.AccountId, applicationId)
 	})
 }
 
-func DevApplicationUsersEndpoint() echo.HandlerFunc {
+func serveDevApplicationUsers(c echo.Context, rctx RequestContext, accountId, applicationId uuid.UUID) error {
 	const defaultPageSize = 50
 
 	// keep in sync with predefined query parameters
 	const firstAdditionalParamIdx = 5
 
-	return wrapAuthenticatedHandlerFunc(func(c echo.Context, rctx RequestContext, session auth.Session) error {
-		applicationId, err := uuid.FromString(c.Param("id"))
-		if err != nil {
+	additionalSQL := "TRUE"
+	additionalParams := make([]any, 0)
+
+	if qJson := c.QueryParam("query"); qJson != "" {
+		var query cloudscapeQuery
+		if err := json.Unmarshal([]byte(qJson), &query); err != nil {
 			return echo.NewHTTPError(http.StatusBadRequest, err)
 		}
 
-		additionalSQL := "TRUE"
-		additionalParams := make([]any, 0)
-
-		if qJson := c.QueryParam("query"); qJson != "" {
-			var query cloudscapeQuery
-			if err = json.Unmarshal([]byte(qJson), &query); err != nil {
-				return echo.NewHTTPError(http.StatusBadRequest, err)
-			}
-
-			if sql, params, err := translateQuery(firstAdditionalParamIdx, query); err != nil {
-				return echo.NewHTTPError(http.StatusBadRequest, err)
-			} else {
-				additionalSQL = sql
-				additionalParams = params
-			}
+		if sql, params, err := translateQuery(firstAdditionalParamIdx, query); err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, err)
+		} else {
+			additionalSQL = sql
+			additionalParams = params
 		}
+	}
 
-		var t time.Time
-		var pageSize uint32
-		var offset uint32
+	var t time.Time
+	var pageSize uint32
+	var offset uint32
 
-		if nextToken := c.QueryParam("nextToken"); nextToken != "" {
-			if err = parseNextToken(nextToken, &t, &pageSize, &offset); err != nil {
-				return echo.NewHTTPError(http.StatusBadRequest, err)
-			}
+	if nextToken := c.QueryParam("nextToken"); nextToken != "" {
+		if err := parseNextToken(nextToken, &t, &pageSize, &offset); err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, err)
+		}
 
-			// in case anyone messes with the nextToken
-			if pageSize < 1 || pageSize > 50 || t.Before(time.Now().Add(-time.Hour)) {
-				return echo.NewHTTPError(http.StatusBadRequest, errors.New("pageSize or timestamp out of bounds"))
-			}
-		} else {
-			t = time.Now().Add(-time.Second)
-			pageSize = defaultPageSize
-			offset = 0
+		// in case anyone messes with the nextToken
+		if pageSize < 1 || pageSize > 50 || t.Before(time.Now().Add(-time.Hour)) {
+			return echo.NewHTTPError(http.StatusBadRequest, "pageSize or timestamp out of bounds")
 		}
+	} else {
+		t = time.Now().Add(-time.Second)
+		pageSize = defaultPageSize
+		offset = 0
+	}
 
-		ctx := c.Request().Context()
-		var results []devApplicationUser
-		err = rctx.ExecuteTx(ctx, pgx.TxOptions{AccessMode: pgx.ReadOnly}, func(tx pgx.Tx) error {
-			if _, err := tx.Exec(ctx, fmt.Sprintf("SET TRANSACTION AS OF SYSTEM TIME %d", t.UnixNano())); err != nil {
-				return err
-			}
+	ctx := c.Request().Context()
+	var results []devApplicationUser
+	err := rctx.ExecuteTx(ctx, pgx.TxOptions{AccessMode: pgx.ReadOnly}, func(tx pgx.Tx) error {
+		if _, err := tx.Exec(ctx, fmt.Sprintf("SET TRANSACTION AS OF SYSTEM TIME %d", t.UnixNano())); err != nil {
+			return err
+		}
 
-			sql := `
+		sql := `
 SELECT
     app_account_subs.account_sub,
     app_accounts.creation_time,
@@ -350,7 +320,7 @@ SELECT
         'approvalRequestMessage', app_client_accounts.approval_request_message,
         'authorizedScopes', app_client_accounts.authorized_scopes
     )
-	END 
+END 
 FROM applications apps
 INNER JOIN application_accounts app_accounts
 ON apps.id = app_accounts.application_id
@@ -361,48 +331,47 @@ ON app_accounts.application_id = app_client_accounts.application_id AND app_acco
 WHERE apps.id = $1
 AND apps.account_id = $2
 `
-			sql += fmt.Sprintf("AND ( %s ) OFFSET $3 LIMIT ($4 + 1)", additionalSQL)
-
-			params := make([]any, 0, 4+len(additionalParams))
-			params = append(params, applicationId, session.AccountId, offset, pageSize)
-			if len(params)+1 != firstAdditionalParamIdx {
-				// should never happen, just a safety measure to prevent additional params from overlapping with predefined ones
-				return errors.New("something went wrong, please contact a developer")
-			}
+		sql += fmt.Sprintf("AND ( %s ) OFFSET $3 LIMIT ($4 + 1)", additionalSQL)
 
-			params = append(params, additionalParams...)
-
-			rows, err := tx.Query(ctx, sql, params...)
-			if err != nil {
-				return err
-			}
+		params := make([]any, 0, 4+len(additionalParams))
+		params = append(params, applicationId, accountId, offset, pageSize)
+		if len(params)+1 != firstAdditionalParamIdx {
+			// should never happen, just a safety measure to prevent additional params from overlapping with predefined ones
+			return errors.New("something went wrong, please contact a developer")
+		}
 
-			results, err = pgx.CollectRows(rows, func(row pgx.CollectableRow) (devApplicationUser, error) {
-				var user devApplicationUser
-				return user, row.Scan(
-					&user.UserId,
-					&user.CreationTime,
-					&user.Client,
-				)
-			})
+		params = append(params, additionalParams...)
 
+		rows, err := tx.Query(ctx, sql, params...)
+		if err != nil {
 			return err
+		}
+
+		results, err = pgx.CollectRows(rows, func(row pgx.CollectableRow) (devApplicationUser, error) {
+			var user devApplicationUser
+			return user, row.Scan(
+				&user.UserId,
+				&user.CreationTime,
+				&user.Client,
+			)
 		})
 
-		if err != nil {
-			return util.NewEchoPgxHTTPError(err)
-		}
+		return err
+	})
 
-		nextToken := ""
-		if len(results) > int(pageSize) {
-			results = results[:pageSize]
-			nextToken = buildNextToken(t, pageSize, offset+pageSize)
-		}
+	if err != nil {
+		return util.NewEchoPgxHTTPError(err)
+	}
 
-		return c.JSON(http.StatusOK, pagedResult[devApplicationUser]{
-			Items:     results,
-			NextToken: nextToken,
-		})
+	nextToken := ""
+	if len(results) > int(pageSize) {
+		results = results[:pageSize]
+		nextToken = buildNextToken(t, pageSize, offset+pageSize)
+	}
+
+	return c.JSON(http.StatusOK, pagedResult[devApplicationUser]{
+		Items:     results,
+		NextToken: nextToken,
 	})
 }
 
@@ -420,7 +389,7 @@ func CreateDevApplicationAPIKeyEndpoint() echo.HandlerFunc {
 
 		body.Permissions = auth.FilterPermissions(body.Permissions)
 		if len(body.Permissions) < 1 {
-			return echo.NewHTTPError(http.StatusBadRequest, errors.New("must have at least one permission"))
+			return echo.NewHTTPError(http.StatusBadRequest, "must have at least one permission")
 		}
 
 		now := time.Now()
@@ -432,7 +401,7 @@ func CreateDevApplicationAPIKeyEndpoint() echo.HandlerFunc {
 		}
 
 		if now.Add(time.Hour).After(expiresAt) {
-			return echo.NewHTTPError(http.StatusBadRequest, errors.New("must be valid for at least one hour"))
+			return echo.NewHTTPError(http.StatusBadRequest, "must be valid for at least one hour")
 		}
 
 		var apiKeyId uuid.UUID
@@ -468,8 +437,16 @@ VALUES
 ($1, $2, $3, $4, $5, $6)
 `
 
-			_, err := tx.Exec(ctx, sql, apiKeyId, applicationId, apiKeyEncoded, body.Permissions, now, expiresAt)
-			return err
+			if _, err := tx.Exec(ctx, sql, apiKeyId, applicationId, apiKeyEncoded, body.Permissions, now, expiresAt); err != nil {
+				return err
+			}
+
+			return accountlog.Write(ctx, tx, session.AccountId, accountlog.TypeApplicationAPIKeyCreated, accountlog.Fields{
+				"applicationId": applicationId,
+				"apiKeyId":      apiKeyId,
+				"permissions":   body.Permissions,
+				"expiresAt":     expiresAt,
+			})
 		})
 
 		if err != nil {
@@ -486,7 +463,7 @@ VALUES
 	})
 }
 
-func DeleteDevApplicationAPIKeyEndpoint() echo.HandlerFunc {
+func DeleteDevApplicationAPIKeyEndpoint(apiKeyCache *auth.APIKeyCache) echo.HandlerFunc {
 	return wrapAuthenticatedHandlerFunc(func(c echo.Context, rctx RequestContext, session auth.Session) error {
 		var applicationId, keyId uuid.UUID
 		if values, err := util.EchoAllParams(c, uuid.FromString, "app_id", "key_id"); err != nil {
@@ -523,7 +500,14 @@ WHERE id = (
 			}
 
 			deleted = tag.RowsAffected() > 0
-			return nil
+			if !deleted {
+				return nil
+			}
+
+			return accountlog.Write(ctx, tx, session.AccountId, accountlog.TypeApplicationAPIKeyDeleted, accountlog.Fields{
+				"applicationId": applicationId,
+				"apiKeyId":      keyId,
+			})
 		})
 
 		if err != nil {
@@ -531,13 +515,161 @@ WHERE id = (
 		}
 
 		if !deleted {
-			return echo.NewHTTPError(http.StatusNotFound, errors.New("the key does not exist"))
+			return echo.NewHTTPError(http.StatusNotFound, "the key does not exist")
 		}
 
+		apiKeyCache.Invalidate(keyId)
+
 		return c.JSON(http.StatusOK, map[string]string{})
 	})
 }
 
+func RotateDevApplicationAPIKeyEndpoint(apiKeyCache *auth.APIKeyCache) echo.HandlerFunc {
+	return wrapAuthenticatedHandlerFunc(func(c echo.Context, rctx RequestContext, session auth.Session) error {
+		var applicationId, keyId uuid.UUID
+		if values, err := util.EchoAllParams(c, uuid.FromString, "app_id", "key_id"); err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, err)
+		} else {
+			applicationId, keyId = values[0], values[1]
+		}
+
+		return rotateDevApplicationAPIKey(c, rctx, apiKeyCache, session.AccountId, applicationId, keyId)
+	})
+}
+
+// rotateDevApplicationAPIKey issues a successor of the key with the same permissions and expiration.
+// The rotated key stays valid for the grace period so that running services can switch to the new key without downtime.
+// Every key can only be rotated once, so a rotation never extends the validity of a key.
+func rotateDevApplicationAPIKey(c echo.Context, rctx RequestContext, apiKeyCache *auth.APIKeyCache, accountId, applicationId, keyId uuid.UUID) error {
+	const defaultGracePeriod = 24 * time.Hour
+	const maxGracePeriod = 30 * 24 * time.Hour
+
+	var body devApplicationApiKeyRotateRequest
+	if err := c.Bind(&body); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err)
+	}
+
+	gracePeriod := defaultGracePeriod
+	if body.GracePeriod != "" {
+		var err error
+		if gracePeriod, err = time.ParseDuration(body.GracePeriod); err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, err)
+		}
+
+		if gracePeriod < 0 || gracePeriod > maxGracePeriod {
+			return echo.NewHTTPError(http.StatusBadRequest, "gracePeriod must be between 0 and 720h")
+		}
+	}
+
+	var err error
+	var apiKeyId uuid.UUID
+	var apiKeyRaw string
+	var apiKeyEncoded string
+
+	if apiKeyId, err = uuid.NewV4(); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err)
+	}
+
+	if apiKeyRaw, err = generateClientSecret(); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err)
+	}
+
+	if apiKeyEncoded, err = service.EncodeArgon2id([]byte(apiKeyRaw)); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err)
+	}
+
+	ctx := c.Request().Context()
+	slog.InfoContext(
+		ctx,
+		"rotating application api key",
+		slog.String("application.id", applicationId.String()),
+		slog.String("application.api_key.id", keyId.String()),
+		slog.String("application.api_key.successor.id", apiKeyId.String()),
+		slog.Duration("application.api_key.grace_period", gracePeriod),
+	)
+
+	now := time.Now()
+	res := devApplicationApiKeyRotateResponse{
+		devApplicationApiKeyCreateResponse: devApplicationApiKeyCreateResponse{
+			Id:        apiKeyId,
+			Key:       apiKeyRaw,
+			NotBefore: now,
+		},
+		RotatedFromId: keyId,
+	}
+
+	err = rctx.ExecuteTx(ctx, pgx.TxOptions{}, func(tx pgx.Tx) error {
+		const sqlSelect = `
+SELECT app_api_keys.permissions, app_api_keys.expires_at
+FROM application_api_keys app_api_keys
+INNER JOIN applications apps
+ON app_api_keys.application_id = apps.id
+WHERE apps.account_id = $1
+AND apps.id = $2
+AND app_api_keys.id = $3
+AND app_api_keys.expires_at > $4
+FOR UPDATE
+`
+		if err := tx.QueryRow(ctx, sqlSelect, accountId, applicationId, keyId, now).Scan(&res.Permissions, &res.RotatedFromExpiresAt); err != nil {
+			if errors.Is(err, pgx.ErrNoRows) {
+				return echo.NewHTTPError(http.StatusNotFound, "the key does not exist or is expired")
+			}
+
+			return err
+		}
+
+		// the successor expires together with the rotated key
+		res.ExpiresAt = res.RotatedFromExpiresAt
+		if graceEnd := now.Add(gracePeriod); graceEnd.Before(res.RotatedFromExpiresAt) {
+			res.RotatedFromExpiresAt = graceEnd
+		}
+
+		const sqlInsert = `
+INSERT INTO application_api_keys
+(id, application_id, key, permissions, not_before, expires_at, rotated_from_id)
+VALUES
+($1, $2, $3, $4, $5, $6, $7)
+`
+		if _, err := tx.Exec(ctx, sqlInsert, apiKeyId, applicationId, apiKeyEncoded, res.Permissions, now, res.ExpiresAt, keyId); err != nil {
+			// rotated_from_id is unique, a key can only have one successor
+			if util.IsUniqueViolation(err) {
+				return echo.NewHTTPError(http.StatusConflict, "the key was already rotated")
+			}
+
+			return err
+		}
+
+		const sqlUpdate = `
+UPDATE application_api_keys
+SET expires_at = $2
+WHERE id = $1
+`
+		if _, err := tx.Exec(ctx, sqlUpdate, keyId, res.RotatedFromExpiresAt); err != nil {
+			return err
+		}
+
+		return accountlog.Write(ctx, tx, accountId, accountlog.TypeApplicationAPIKeyRotated, accountlog.Fields{
+			"applicationId": applicationId,
+			"apiKeyId":      keyId,
+			"successorId":   apiKeyId,
+			"expiresAt":     res.RotatedFromExpiresAt,
+		})
+	})
+
+	if err != nil {
+		var httpError *echo.HTTPError
+		if errors.As(err, &httpError) {
+			return httpError
+		}
+
+		return util.NewEchoPgxHTTPError(err)
+	}
+
+	apiKeyCache.Invalidate(keyId)
+
+	return c.JSON(http.StatusOK, res)
+}
+
 func translateQuery(paramNum int, query cloudscapeQuery) (string, []any, error) {
 	propertyToSQL := map[string]string{
 		"user_id":           "app_account_subs.account_sub",
@@ -660,3 +792,93 @@ func parseExpiresAt(s string) (time.Time, error) {
 
 	return t, err
 }
+
+func selectDevApplications(ctx context.Context, tx pgx.Tx, accountId uuid.UUID) ([]devApplicationForList, error) {
+	const sql = `
+SELECT
+    apps.id,
+    MAX(apps.creation_time),
+    MAX(apps.display_name),
+    COUNT(DISTINCT app_clients.id),
+    COUNT(DISTINCT app_accs.account_id)
+FROM applications apps
+LEFT JOIN application_clients app_clients
+ON apps.id = app_clients.application_id
+LEFT JOIN application_accounts app_accs
+ON apps.id = app_accs.application_id
+WHERE apps.account_id = $1
+GROUP BY apps.id
+`
+	rows, err := tx.Query(ctx, sql, accountId)
+	if err != nil {
+		return nil, err
+	}
+
+	return pgx.CollectRows(rows, func(row pgx.CollectableRow) (devApplicationForList, error) {
+		var app devApplicationForList
+		return app, row.Scan(
+			&app.Id,
+			&app.CreationTime,
+			&app.DisplayName,
+			&app.ClientCount,
+			&app.UserCount,
+		)
+	})
+}
+
+// selectDevApplicationSql selects the details of all dev applications of account $1, or only of application $2 if not NULL
+const selectDevApplicationSql = `
+SELECT
+    app.id,
+    MAX(app.creation_time),
+    MAX(app.display_name),
+    COALESCE(ARRAY_AGG(DISTINCT JSONB_BUILD_OBJECT(
+		'id', app_clients.id,
+		'creationTime', app_clients.creation_time,
+        'displayName', app_clients.display_name,
+        'apiVersion', app_clients.api_version,
+        'type', app_clients.type,
+        'redirectURIs', app_clients.redirect_uris,
+        'requiresApproval', app_clients.requires_approval
+	)) FILTER ( WHERE app_clients.id IS NOT NULL ), ARRAY[]::JSONB[]),
+    COALESCE(ARRAY_AGG(DISTINCT JSONB_BUILD_OBJECT(
+		'id', app_api_keys.id,
+		'permissions', app_api_keys.permissions,
+        'notBefore', app_api_keys.not_before,
+        'expiresAt', app_api_keys.expires_at,
+        'lastUsedTime', app_api_keys.last_used_time,
+        'lastUsedLocation', CASE
+            WHEN app_api_keys.last_used_approx_lat IS NULL OR app_api_keys.last_used_approx_lng IS NULL THEN NULL
+            ELSE JSONB_BUILD_OBJECT('lat', app_api_keys.last_used_approx_lat, 'lng', app_api_keys.last_used_approx_lng)
+        END,
+        'requestCount', app_api_keys.request_count,
+        'rotatedFromId', app_api_keys.rotated_from_id
+	)) FILTER ( WHERE app_api_keys.id IS NOT NULL ), ARRAY[]::JSONB[])
+FROM applications app
+LEFT JOIN application_clients app_clients
+ON app.id = app_clients.application_id
+LEFT JOIN application_api_keys app_api_keys
+ON app.id = app_api_keys.application_id
+WHERE app.account_id = $1
+AND ($2::UUID IS NULL OR app.id = $2)
+GROUP BY app.id
+`
+
+func selectDevApplication(ctx context.Context, tx pgx.Tx, accountId, applicationId uuid.UUID) (devApplication, error) {
+	_, result, err := scanDevApplication(tx.QueryRow(ctx, selectDevApplicationSql, accountId, applicationId))
+	return result, err
+}
+
+func scanDevApplication(row pgx.Row) (uuid.UUID, devApplication, error) {
+	var applicationId uuid.UUID
+	var result devApplication
+	err := row.Scan(
+		&applicationId,
+		&result.CreationTime,
+		&result.DisplayName,
+		&result.Clients,
+		&result.ApiKeys,
+	)
+
+	return applicationId, result, err
+}
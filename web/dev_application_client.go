@@ -50,9 +50,6 @@ type devAppClientUserUpdate struct {
 }
 
 func CreateDevApplicationClientEndpoint() echo.HandlerFunc {
-	const apiVersion = 0
-	const clientType = "CONFIDENTIAL"
-
 	return wrapAuthenticatedHandlerFunc(func(c echo.Context, rctx RequestContext, session auth.Session) error {
 		var err error
 		var applicationId uuid.UUID
@@ -60,52 +57,60 @@ func CreateDevApplicationClientEndpoint() echo.HandlerFunc {
 			return echo.NewHTTPError(http.StatusBadRequest, err)
 		}
 
-		var body devApplicationClientCreate
-		if err = c.Bind(&body); err != nil {
-			return echo.NewHTTPError(http.StatusBadRequest, err)
-		}
+		return createDevApplicationClient(c, rctx, session.AccountId, applicationId)
+	})
+}
 
-		if body.DisplayName == "" || len(body.DisplayName) > 100 {
-			return echo.NewHTTPError(http.StatusBadRequest, errors.New("displayname must be between 1 and 100 characters"))
-		}
+func createDevApplicationClient(c echo.Context, rctx RequestContext, accountId, applicationId uuid.UUID) error {
+	const apiVersion = 0
+	const clientType = "CONFIDENTIAL"
 
-		applicationClientId, err := uuid.NewV4()
-		if err != nil {
-			return echo.NewHTTPError(http.StatusInternalServerError, err)
-		}
+	var body devApplicationClientCreate
+	if err := c.Bind(&body); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err)
+	}
 
-		body.RedirectURIs = preprocessRedirectURIs(applicationId, applicationClientId, body.RedirectURIs)
-		if len(body.RedirectURIs) < 1 || len(body.RedirectURIs) > 50 {
-			return echo.NewHTTPError(http.StatusBadRequest, errors.New("at least one and at most 50 redirect URIs might be added"))
-		}
+	if body.DisplayName == "" || len(body.DisplayName) > 100 {
+		return echo.NewHTTPError(http.StatusBadRequest, "displayname must be between 1 and 100 characters")
+	}
 
-		if err = validateRedirectURIs(body.RedirectURIs); err != nil {
-			return echo.NewHTTPError(http.StatusBadRequest, err)
-		}
+	applicationClientId, err := uuid.NewV4()
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err)
+	}
 
-		creationTime := time.Now()
-		clientSecret, err := generateClientSecret()
-		if err != nil {
-			return echo.NewHTTPError(http.StatusInternalServerError, err)
-		}
+	body.RedirectURIs = preprocessRedirectURIs(applicationId, applicationClientId, body.RedirectURIs)
+	if len(body.RedirectURIs) < 1 || len(body.RedirectURIs) > 50 {
+		return echo.NewHTTPError(http.StatusBadRequest, "at least one and at most 50 redirect URIs might be added")
+	}
 
-		encodedClientSecret, err := encodeClientSecret(clientSecret)
-		if err != nil {
-			return echo.NewHTTPError(http.StatusInternalServerError, err)
-		}
+	if err = validateRedirectURIs(body.RedirectURIs); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err)
+	}
 
-		ctx := c.Request().Context()
-		slog.InfoContext(
-			ctx,
-			"creating new application client",
-			slog.String("application.id", applicationId.String()),
-			slog.String("application.client.id", applicationClientId.String()),
-			slog.String("application.client.name", body.DisplayName),
-		)
+	creationTime := time.Now()
+	clientSecret, err := generateClientSecret()
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err)
+	}
 
-		var created bool
-		err = rctx.ExecuteTx(ctx, pgx.TxOptions{}, func(tx pgx.Tx) error {
-			const sql = `
+	encodedClientSecret, err := encodeClientSecret(clientSecret)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err)
+	}
+
+	ctx := c.Request().Context()
+	slog.InfoContext(
+		ctx,
+		"creating new application client",
+		slog.String("application.id", applicationId.String()),
+		slog.String("application.client.id", applicationClientId.String()),
+		slog.String("application.client.name", body.DisplayName),
+	)
+
+	var created bool
+	err = rctx.ExecuteTx(ctx, pgx.TxOptions{}, func(tx pgx.Tx) error {
+		const sql = `
 INSERT INTO application_clients
 (id, application_id, creation_time, display_name, client_secret, authorization_grant_types, redirect_uris, requires_approval, api_version, type)
 SELECT
@@ -123,47 +128,46 @@ FROM applications
 WHERE account_id = $1
 AND id = $2
 `
-			tag, err := tx.Exec(
-				ctx,
-				sql,
-				session.AccountId,
-				applicationId,
-				applicationClientId,
-				creationTime,
-				body.DisplayName,
-				encodedClientSecret,
-				[]string{"authorization_code", "refresh_token"},
-				body.RedirectURIs,
-				body.RequiresApproval,
-				apiVersion,
-				clientType,
-			)
-			if err != nil {
-				return err
-			}
-
-			created = tag.RowsAffected() > 0
-			return nil
-		})
-
+		tag, err := tx.Exec(
+			ctx,
+			sql,
+			accountId,
+			applicationId,
+			applicationClientId,
+			creationTime,
+			body.DisplayName,
+			encodedClientSecret,
+			[]string{"authorization_code", "refresh_token"},
+			body.RedirectURIs,
+			body.RequiresApproval,
+			apiVersion,
+			clientType,
+		)
 		if err != nil {
-			return util.NewEchoPgxHTTPError(err)
+			return err
 		}
 
-		if !created {
-			return echo.NewHTTPError(http.StatusNotFound, errors.New("the application does not exist"))
-		}
+		created = tag.RowsAffected() > 0
+		return nil
+	})
 
-		return c.JSON(http.StatusOK, devApplicationClientCreateResponse{
-			Id:               applicationClientId,
-			CreationTime:     creationTime,
-			DisplayName:      body.DisplayName,
-			ApiVersion:       apiVersion,
-			Type:             clientType,
-			RedirectURIs:     body.RedirectURIs,
-			RequiresApproval: body.RequiresApproval,
-			ClientSecret:     clientSecret,
-		})
+	if err != nil {
+		return util.NewEchoPgxHTTPError(err)
+	}
+
+	if !created {
+		return echo.NewHTTPError(http.StatusNotFound, "the application does not exist")
+	}
+
+	return c.JSON(http.StatusOK, devApplicationClientCreateResponse{
+		Id:               applicationClientId,
+		CreationTime:     creationTime,
+		DisplayName:      body.DisplayName,
+		ApiVersion:       apiVersion,
+		Type:             clientType,
+		RedirectURIs:     body.RedirectURIs,
+		RequiresApproval: body.RequiresApproval,
+		ClientSecret:     clientSecret,
 	})
 }
 
@@ -176,10 +180,15 @@ func DevApplicationClientEndpoint() echo.HandlerFunc {
 			applicationId, clientId = values[0], values[1]
 		}
 
-		ctx := c.Request().Context()
-		var result devApplicationClient
-		err := rctx.ExecuteTx(ctx, pgx.TxOptions{AccessMode: pgx.ReadOnly}, func(tx pgx.Tx) error {
-			const sql = `
+		return serveDevApplicationClient(c, rctx, session.AccountId, applicationId, clientId)
+	})
+}
+
+func serveDevApplicationClient(c echo.Context, rctx RequestContext, accountId, applicationId, clientId uuid.UUID) error {
+	ctx := c.Request().Context()
+	var result devApplicationClient
+	err := rctx.ExecuteTx(ctx, pgx.TxOptions{AccessMode: pgx.ReadOnly}, func(tx pgx.Tx) error {
+		const sql = `
 SELECT
     app_clients.creation_time,
     app_clients.display_name,
@@ -194,22 +203,21 @@ WHERE apps.account_id = $1
 AND apps.id = $2
 AND app_clients.id = $3
 `
-			return tx.QueryRow(ctx, sql, session.AccountId, applicationId, clientId).Scan(
-				&result.CreationTime,
-				&result.DisplayName,
-				&result.ApiVersion,
-				&result.Type,
-				&result.RedirectURIs,
-				&result.RequiresApproval,
-			)
-		})
+		return tx.QueryRow(ctx, sql, accountId, applicationId, clientId).Scan(
+			&result.CreationTime,
+			&result.DisplayName,
+			&result.ApiVersion,
+			&result.Type,
+			&result.RedirectURIs,
+			&result.RequiresApproval,
+		)
+	})
 
-		if err != nil {
-			return util.NewEchoPgxHTTPError(err)
-		}
+	if err != nil {
+		return util.NewEchoPgxHTTPError(err)
+	}
 
-		return c.JSON(http.StatusOK, result)
-	})
+	return c.JSON(http.StatusOK, result)
 }
 
 func RegenerateDevApplicationClientSecretEndpoint() echo.HandlerFunc {
@@ -221,27 +229,32 @@ func RegenerateDevApplicationClientSecretEndpoint() echo.HandlerFunc {
 			applicationId, clientId = values[0], values[1]
 		}
 
-		clientSecret, err := generateClientSecret()
-		if err != nil {
-			return echo.NewHTTPError(http.StatusInternalServerError, err)
-		}
+		return regenerateDevApplicationClientSecret(c, rctx, session.AccountId, applicationId, clientId)
+	})
+}
 
-		encodedClientSecret, err := encodeClientSecret(clientSecret)
-		if err != nil {
-			return echo.NewHTTPError(http.StatusInternalServerError, err)
-		}
+func regenerateDevApplicationClientSecret(c echo.Context, rctx RequestContext, accountId, applicationId, clientId uuid.UUID) error {
+	clientSecret, err := generateClientSecret()
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err)
+	}
 
-		ctx := c.Request().Context()
-		slog.InfoContext(
-			ctx,
-			"regenerating client secret for client",
-			slog.String("application.id", applicationId.String()),
-			slog.String("application.client.id", clientId.String()),
-		)
+	encodedClientSecret, err := encodeClientSecret(clientSecret)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err)
+	}
 
-		var updated bool
-		err = rctx.ExecuteTx(ctx, pgx.TxOptions{}, func(tx pgx.Tx) error {
-			const sql = `
+	ctx := c.Request().Context()
+	slog.InfoContext(
+		ctx,
+		"regenerating client secret for client",
+		slog.String("application.id", applicationId.String()),
+		slog.String("application.client.id", clientId.String()),
+	)
+
+	var updated bool
+	err = rctx.ExecuteTx(ctx, pgx.TxOptions{}, func(tx pgx.Tx) error {
+		const sql = `
 UPDATE application_clients
 SET client_secret = $4
 WHERE id = (
@@ -255,26 +268,25 @@ WHERE id = (
 )
 `
 
-			tag, err := tx.Exec(ctx, sql, session.AccountId, applicationId, clientId, encodedClientSecret)
-			if err != nil {
-				return err
-			}
-
-			updated = tag.RowsAffected() > 0
-			return nil
-		})
-
+		tag, err := tx.Exec(ctx, sql, accountId, applicationId, clientId, encodedClientSecret)
 		if err != nil {
-			return util.NewEchoPgxHTTPError(err)
+			return err
 		}
 
-		if !updated {
-			return echo.NewHTTPError(http.StatusNotFound, errors.New("no rows were updated"))
-		}
+		updated = tag.RowsAffected() > 0
+		return nil
+	})
 
-		return c.JSON(http.StatusOK, map[string]string{
-			"clientSecret": clientSecret,
-		})
+	if err != nil {
+		return util.NewEchoPgxHTTPError(err)
+	}
+
+	if !updated {
+		return echo.NewHTTPError(http.StatusNotFound, "no rows were updated")
+	}
+
+	return c.JSON(http.StatusOK, map[string]string{
+		"clientSecret": clientSecret,
 	})
 }
 
@@ -295,7 +307,7 @@ func UpdateDevApplicationClientRedirectURIsEndpoint() echo.HandlerFunc {
 
 		redirectURIs = preprocessRedirectURIs(applicationId, clientId, redirectURIs)
 		if len(redirectURIs) < 1 || len(redirectURIs) > 50 {
-			return echo.NewHTTPError(http.StatusBadRequest, errors.New("at least one and at most 50 redirect URIs might be added"))
+			return echo.NewHTTPError(http.StatusBadRequest, "at least one and at most 50 redirect URIs might be added")
 		}
 
 		if err = validateRedirectURIs(redirectURIs); err != nil {
@@ -358,17 +370,22 @@ func DeleteDevApplicationClientEndpoint() echo.HandlerFunc {
 			applicationId, clientId = values[0], values[1]
 		}
 
-		ctx := c.Request().Context()
-		slog.InfoContext(
-			ctx,
-			"deleting application client",
-			slog.String("application.id", applicationId.String()),
-			slog.String("application.client.id", clientId.String()),
-		)
+		return deleteDevApplicationClient(c, rctx, session.AccountId, applicationId, clientId)
+	})
+}
 
-		var deleted bool
-		err := rctx.ExecuteTx(ctx, pgx.TxOptions{}, func(tx pgx.Tx) error {
-			const sql = `
+func deleteDevApplicationClient(c echo.Context, rctx RequestContext, accountId, applicationId, clientId uuid.UUID) error {
+	ctx := c.Request().Context()
+	slog.InfoContext(
+		ctx,
+		"deleting application client",
+		slog.String("application.id", applicationId.String()),
+		slog.String("application.client.id", clientId.String()),
+	)
+
+	var deleted bool
+	err := rctx.ExecuteTx(ctx, pgx.TxOptions{}, func(tx pgx.Tx) error {
+		const sql = `
 DELETE FROM application_clients
 WHERE id = (
     SELECT app_clients.id
@@ -380,25 +397,24 @@ WHERE id = (
     AND app_clients.id = $3
 )
 `
-			tag, err := tx.Exec(ctx, sql, session.AccountId, applicationId, clientId)
-			if err != nil {
-				return err
-			}
-
-			deleted = tag.RowsAffected() > 0
-			return nil
-		})
-
+		tag, err := tx.Exec(ctx, sql, accountId, applicationId, clientId)
 		if err != nil {
-			return util.NewEchoPgxHTTPError(err)
-		}
-
-		if !deleted {
-			return echo.NewHTTPError(http.StatusNotFound, errors.New("the client does not exist"))
+			return err
 		}
 
-		return c.JSON(http.StatusOK, map[string]string{})
+		deleted = tag.RowsAffected() > 0
+		return nil
 	})
+
+	if err != nil {
+		return util.NewEchoPgxHTTPError(err)
+	}
+
+	if !deleted {
+		return echo.NewHTTPError(http.StatusNotFound, "the client does not exist")
+	}
+
+	return c.JSON(http.StatusOK, map[string]string{})
 }
 
 func UpdateDevApplicationClientUserEndpoint() echo.HandlerFunc {
@@ -410,66 +426,70 @@ func UpdateDevApplicationClientUserEndpoint() echo.HandlerFunc {
 			applicationId, clientId, userId = values[0], values[1], values[2]
 		}
 
-		var update devAppClientUserUpdate
-		if err := c.Bind(&update); err != nil {
-			return echo.NewHTTPError(http.StatusBadRequest, err)
-		}
+		return updateDevApplicationClientUser(c, rctx, session.AccountId, applicationId, clientId, userId)
+	})
+}
 
-		if !slices.Contains([]string{"APPROVED", "BLOCKED"}, update.ApprovalStatus) {
-			return echo.NewHTTPError(http.StatusBadRequest, errors.New("invalid approval status"))
-		}
+func updateDevApplicationClientUser(c echo.Context, rctx RequestContext, accountId, applicationId, clientId, userId uuid.UUID) error {
+	var update devAppClientUserUpdate
+	if err := c.Bind(&update); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err)
+	}
 
-		ctx := c.Request().Context()
-		slog.InfoContext(
-			ctx,
-			"updating application client user",
-			slog.String("application.id", applicationId.String()),
-			slog.String("application.client.id", clientId.String()),
-			slog.String("application.user.id", userId.String()),
-			slog.String("application.client.user.status", update.ApprovalStatus),
-		)
+	if !slices.Contains([]string{"APPROVED", "BLOCKED"}, update.ApprovalStatus) {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid approval status")
+	}
 
-		var updated bool
-		err := rctx.ExecuteTx(ctx, pgx.TxOptions{}, func(tx pgx.Tx) error {
-			const sql = `
+	ctx := c.Request().Context()
+	slog.InfoContext(
+		ctx,
+		"updating application client user",
+		slog.String("application.id", applicationId.String()),
+		slog.String("application.client.id", clientId.String()),
+		slog.String("application.user.id", userId.String()),
+		slog.String("application.client.user.status", update.ApprovalStatus),
+	)
+
+	var updated bool
+	err := rctx.ExecuteTx(ctx, pgx.TxOptions{}, func(tx pgx.Tx) error {
+		const sql = `
 UPDATE application_client_accounts
 SET approval_status = $5, approval_request_message = $6
 FROM (
-	SELECT
-		app_client_accounts.application_client_id,
-		app_client_accounts.account_id
-	FROM application_account_subs app_account_subs
-	INNER JOIN application_client_accounts app_client_accounts
-	USING (application_id, account_id)
-	INNER JOIN applications app
-	ON app_client_accounts.application_id = app.id
-	WHERE app.account_id = $1
-	AND app.id = $2
-	AND app_client_accounts.application_client_id = $3
-	AND app_account_subs.account_sub = $4
+SELECT
+	app_client_accounts.application_client_id,
+	app_client_accounts.account_id
+FROM application_account_subs app_account_subs
+INNER JOIN application_client_accounts app_client_accounts
+USING (application_id, account_id)
+INNER JOIN applications app
+ON app_client_accounts.application_id = app.id
+WHERE app.account_id = $1
+AND app.id = $2
+AND app_client_accounts.application_client_id = $3
+AND app_account_subs.account_sub = $4
 ) AS app_client_account
 WHERE application_client_accounts.application_client_id = app_client_account.application_client_id
 AND application_client_accounts.account_id = app_client_account.account_id
 `
-			tag, err := tx.Exec(ctx, sql, session.AccountId, applicationId, clientId, userId, update.ApprovalStatus, update.ApprovalMessage)
-			if err != nil {
-				return err
-			}
-
-			updated = tag.RowsAffected() > 0
-			return nil
-		})
-
+		tag, err := tx.Exec(ctx, sql, accountId, applicationId, clientId, userId, update.ApprovalStatus, update.ApprovalMessage)
 		if err != nil {
-			return util.NewEchoPgxHTTPError(err)
-		}
-
-		if !updated {
-			return echo.NewHTTPError(http.StatusNotFound, errors.New("no rows were updated"))
+			return err
 		}
 
-		return c.JSON(http.StatusOK, update)
+		updated = tag.RowsAffected() > 0
+		return nil
 	})
+
+	if err != nil {
+		return util.NewEchoPgxHTTPError(err)
+	}
+
+	if !updated {
+		return echo.NewHTTPError(http.StatusNotFound, "no rows were updated")
+	}
+
+	return c.JSON(http.StatusOK, update)
 }
 
 func preprocessRedirectURIs(applicationId, clientId uuid.UUID, redirectURIs []string) []string {
@@ -0,0 +1,43 @@
+package web
+
+import (
+	"github.com/gw2auth/gw2auth.com-api/util"
+	"github.com/labstack/echo/v4"
+	"go.opentelemetry.io/otel/trace"
+	"log/slog"
+	"net/http"
+)
+
+// HTTPErrorHandler renders every error returned by a handler or middleware as util.Error
+func HTTPErrorHandler(err error, c echo.Context) {
+	if c.Response().Committed {
+		return
+	}
+
+	e := *util.ErrorFrom(err)
+	ctx := c.Request().Context()
+
+	e.RequestId = c.Response().Header().Get(echo.HeaderXRequestID)
+	if e.RequestId == "" {
+		e.RequestId = c.Request().Header.Get(echo.HeaderXRequestID)
+	}
+
+	if spanCtx := trace.SpanContextFromContext(ctx); spanCtx.HasTraceID() {
+		e.TraceId = spanCtx.TraceID().String()
+	}
+
+	if e.Status >= http.StatusInternalServerError {
+		slog.ErrorContext(ctx, "request failed", slog.Int("http.response.status_code", e.Status), slog.String("error", err.Error()))
+	}
+
+	var writeErr error
+	if c.Request().Method == http.MethodHead {
+		writeErr = c.NoContent(e.Status)
+	} else {
+		writeErr = c.JSON(e.Status, e)
+	}
+
+	if writeErr != nil {
+		slog.ErrorContext(ctx, "failed to write error response", slog.String("error", writeErr.Error()))
+	}
+}
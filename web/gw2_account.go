@@ -1,7 +1,7 @@
 package web
 
 import (
-	"errors"
+	"context"
 	"github.com/gofrs/uuid/v5"
 	"github.com/gw2auth/gw2auth.com-api/service/auth"
 	"github.com/gw2auth/gw2auth.com-api/service/gw2"
@@ -62,7 +62,95 @@ func Gw2AccountsEndpoint() echo.HandlerFunc {
 
 		var results []gw2AccountForList
 		err := rctx.ExecuteTx(ctx, pgx.TxOptions{AccessMode: pgx.ReadOnly}, func(tx pgx.Tx) error {
+			var err error
+			results, err = selectGw2Accounts(ctx, tx, session.AccountId)
+			return err
+		})
+		if err != nil {
+			return util.NewEchoPgxHTTPError(err)
+		}
+
+		return c.JSON(http.StatusOK, results)
+	})
+}
+
+func Gw2AccountEndpoint() echo.HandlerFunc {
+	return wrapAuthenticatedHandlerFunc(func(c echo.Context, rctx RequestContext, session auth.Session) error {
+		gw2AccountId, err := uuid.FromString(c.Param("id"))
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, err)
+		}
+
+		ctx := c.Request().Context()
+
+		var acc gw2Account
+		err = rctx.ExecuteTx(ctx, pgx.TxOptions{AccessMode: pgx.ReadOnly}, func(tx pgx.Tx) error {
+			var err error
+			acc, err = selectGw2Account(ctx, tx, session.AccountId, gw2AccountId)
+			return err
+		})
+		if err != nil {
+			return util.NewEchoPgxHTTPError(err)
+		}
+
+		return c.JSON(http.StatusOK, acc)
+	})
+}
+
+func UpdateGw2AccountEndpoint() echo.HandlerFunc {
+	return wrapAuthenticatedHandlerFunc(func(c echo.Context, rctx RequestContext, session auth.Session) error {
+		gw2AccountId, err := uuid.FromString(c.Param("id"))
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, err)
+		}
+
+		var update gw2AccountUpdate
+		if err = c.Bind(&update); err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, err)
+		}
+
+		if update.DisplayName == "" || len(update.DisplayName) > 100 {
+			return echo.NewHTTPError(http.StatusBadRequest, "displayname must be between 1 and 100 characters")
+		}
+
+		ctx := c.Request().Context()
+		slog.InfoContext(
+			ctx,
+			"updating gw2account",
+			slog.String("gw2account.id", gw2AccountId.String()),
+			slog.String("gw2account.display_name", update.DisplayName),
+		)
+
+		var rowsAffected int64
+		err = rctx.ExecuteTx(ctx, pgx.TxOptions{}, func(tx pgx.Tx) error {
 			const sql = `
+UPDATE gw2_accounts
+SET display_name = $3
+WHERE account_id = $1 AND gw2_account_id = $2
+`
+			tag, err := tx.Exec(ctx, sql, session.AccountId, gw2AccountId, update.DisplayName)
+			if err != nil {
+				return err
+			}
+
+			rowsAffected = tag.RowsAffected()
+			return nil
+		})
+
+		if err != nil {
+			return util.NewEchoPgxHTTPError(err)
+		}
+
+		if rowsAffected < 1 {
+			return echo.NewHTTPError(http.StatusNotFound, map[string]string{})
+		}
+
+		return c.JSON(http.StatusOK, map[string]string{})
+	})
+}
+
+func selectGw2Accounts(ctx context.Context, tx pgx.Tx, accountId uuid.UUID) ([]gw2AccountForList, error) {
+	const sql = `
 SELECT
     gw2_acc.gw2_account_id,
     MAX(gw2_acc.gw2_account_name),
@@ -88,72 +176,46 @@ LEFT JOIN application_clients app_client
 WHERE gw2_acc.account_id = $1
 GROUP BY gw2_acc.gw2_account_id
 `
-			rows, err := tx.Query(ctx, sql, session.AccountId)
-			if err != nil {
-				return err
-			}
+	rows, err := tx.Query(ctx, sql, accountId)
+	if err != nil {
+		return nil, err
+	}
 
-			results, err = pgx.CollectRows(rows, func(row pgx.CollectableRow) (gw2AccountForList, error) {
-				var acc gw2AccountForList
-				var verified bool
-				var pendingVer bool
-
-				err := row.Scan(
-					&acc.Id,
-					&acc.Name,
-					&acc.DisplayName,
-					&acc.CreationTime,
-					&verified,
-					&pendingVer,
-					&acc.ApiToken,
-					&acc.AuthorizedApps,
-				)
-				if err != nil {
-					return acc, err
-				}
-
-				if verified {
-					acc.VerificationStatus = verificationStatusVerified
-				} else if pendingVer {
-					acc.VerificationStatus = verificationStatusPending
-				} else {
-					acc.VerificationStatus = verificationStatusNone
-				}
-
-				return acc, nil
-			})
+	return pgx.CollectRows(rows, func(row pgx.CollectableRow) (gw2AccountForList, error) {
+		var acc gw2AccountForList
+		var verified bool
+		var pendingVer bool
 
-			return err
-		})
+		err := row.Scan(
+			&acc.Id,
+			&acc.Name,
+			&acc.DisplayName,
+			&acc.CreationTime,
+			&verified,
+			&pendingVer,
+			&acc.ApiToken,
+			&acc.AuthorizedApps,
+		)
 		if err != nil {
-			return util.NewEchoPgxHTTPError(err)
+			return acc, err
 		}
 
-		return c.JSON(http.StatusOK, results)
-	})
-}
-
-func Gw2AccountEndpoint() echo.HandlerFunc {
-	return wrapAuthenticatedHandlerFunc(func(c echo.Context, rctx RequestContext, session auth.Session) error {
-		gw2AccountId, err := uuid.FromString(c.Param("id"))
-		if err != nil {
-			return echo.NewHTTPError(http.StatusBadRequest, err)
+		if verified {
+			acc.VerificationStatus = verificationStatusVerified
+		} else if pendingVer {
+			acc.VerificationStatus = verificationStatusPending
+		} else {
+			acc.VerificationStatus = verificationStatusNone
 		}
 
-		ctx := c.Request().Context()
+		return acc, nil
+	})
+}
 
-		var acc gw2Account
-		var verified bool
-		var pendingVer bool
-		var apiTokenRaw *struct {
-			Value        string    `json:"value"`
-			CreationTime time.Time `json:"creationTime"`
-			Permissions  int32     `json:"permissions"`
-			Exists       bool      `json:"exists"`
-		}
-		err = rctx.ExecuteTx(ctx, pgx.TxOptions{AccessMode: pgx.ReadOnly}, func(tx pgx.Tx) error {
-			const sql = `
+// selectGw2AccountSql selects the details of all gw2 accounts of account $1, or only of gw2 account $2 if not NULL
+const selectGw2AccountSql = `
 SELECT
+    gw2_acc.gw2_account_id,
     MAX(gw2_acc.gw2_account_name),
     MAX(gw2_acc.display_name),
     MAX(gw2_acc.creation_time),
@@ -195,91 +257,55 @@ LEFT JOIN (
 	GROUP BY app_client_auth_gw2_acc.account_id, app_client_auth_gw2_acc.gw2_account_id, app.id
 ) AS authorized_apps
 	USING (account_id, gw2_account_id)
-WHERE gw2_acc.account_id = $1 AND gw2_acc.gw2_account_id = $2
+WHERE gw2_acc.account_id = $1 AND ($2::UUID IS NULL OR gw2_acc.gw2_account_id = $2)
 GROUP BY gw2_acc.gw2_account_id
 `
-			return tx.QueryRow(ctx, sql, session.AccountId, gw2AccountId).Scan(
-				&acc.Name,
-				&acc.DisplayName,
-				&acc.CreationTime,
-				&verified,
-				&pendingVer,
-				&apiTokenRaw,
-				&acc.AuthorizedApps,
-			)
-		})
-		if err != nil {
-			return util.NewEchoPgxHTTPError(err)
-		}
-
-		if verified {
-			acc.VerificationStatus = verificationStatusVerified
-		} else if pendingVer {
-			acc.VerificationStatus = verificationStatusPending
-		} else {
-			acc.VerificationStatus = verificationStatusNone
-		}
 
-		if apiTokenRaw != nil {
-			acc.ApiToken = &apiToken{
-				Value:        apiTokenRaw.Value,
-				CreationTime: apiTokenRaw.CreationTime,
-				Permissions:  gw2.PermissionsFromBitSet(apiTokenRaw.Permissions),
-			}
-		}
-
-		return c.JSON(http.StatusOK, acc)
-	})
+func selectGw2Account(ctx context.Context, tx pgx.Tx, accountId, gw2AccountId uuid.UUID) (gw2Account, error) {
+	_, acc, err := scanGw2Account(tx.QueryRow(ctx, selectGw2AccountSql, accountId, gw2AccountId))
+	return acc, err
 }
 
-func UpdateGw2AccountEndpoint() echo.HandlerFunc {
-	return wrapAuthenticatedHandlerFunc(func(c echo.Context, rctx RequestContext, session auth.Session) error {
-		gw2AccountId, err := uuid.FromString(c.Param("id"))
-		if err != nil {
-			return echo.NewHTTPError(http.StatusBadRequest, err)
-		}
-
-		var update gw2AccountUpdate
-		if err = c.Bind(&update); err != nil {
-			return echo.NewHTTPError(http.StatusBadRequest, err)
-		}
-
-		if update.DisplayName == "" || len(update.DisplayName) > 100 {
-			return echo.NewHTTPError(http.StatusBadRequest, errors.New("displayname must be between 1 and 100 characters"))
-		}
-
-		ctx := c.Request().Context()
-		slog.InfoContext(
-			ctx,
-			"updating gw2account",
-			slog.String("gw2account.id", gw2AccountId.String()),
-			slog.String("gw2account.display_name", update.DisplayName),
-		)
-
-		var rowsAffected int64
-		err = rctx.ExecuteTx(ctx, pgx.TxOptions{}, func(tx pgx.Tx) error {
-			const sql = `
-UPDATE gw2_accounts
-SET display_name = $3
-WHERE account_id = $1 AND gw2_account_id = $2
-`
-			tag, err := tx.Exec(ctx, sql, session.AccountId, gw2AccountId, update.DisplayName)
-			if err != nil {
-				return err
-			}
+func scanGw2Account(row pgx.Row) (uuid.UUID, gw2Account, error) {
+	var gw2AccountId uuid.UUID
+	var acc gw2Account
+	var verified bool
+	var pendingVer bool
+	var apiTokenRaw *struct {
+		Value        string    `json:"value"`
+		CreationTime time.Time `json:"creationTime"`
+		Permissions  int32     `json:"permissions"`
+		Exists       bool      `json:"exists"`
+	}
+	err := row.Scan(
+		&gw2AccountId,
+		&acc.Name,
+		&acc.DisplayName,
+		&acc.CreationTime,
+		&verified,
+		&pendingVer,
+		&apiTokenRaw,
+		&acc.AuthorizedApps,
+	)
+	if err != nil {
+		return gw2AccountId, acc, err
+	}
 
-			rowsAffected = tag.RowsAffected()
-			return nil
-		})
+	if verified {
+		acc.VerificationStatus = verificationStatusVerified
+	} else if pendingVer {
+		acc.VerificationStatus = verificationStatusPending
+	} else {
+		acc.VerificationStatus = verificationStatusNone
+	}
 
-		if err != nil {
-			return util.NewEchoPgxHTTPError(err)
+	if apiTokenRaw != nil {
+		acc.ApiToken = &apiToken{
+			Value:        apiTokenRaw.Value,
+			CreationTime: apiTokenRaw.CreationTime,
+			Permissions:  gw2.PermissionsFromBitSet(apiTokenRaw.Permissions),
 		}
+	}
 
-		if rowsAffected < 1 {
-			return echo.NewHTTPError(http.StatusNotFound, map[string]string{})
-		}
-
-		return c.JSON(http.StatusOK, map[string]string{})
-	})
+	return gw2AccountId, acc, nil
 }
@@ -8,8 +8,10 @@ import (
 	"encoding/binary"
 	"errors"
 	"github.com/gofrs/uuid/v5"
+	"github.com/gw2auth/gw2auth.com-api/service/accountlog"
 	"github.com/gw2auth/gw2auth.com-api/service/auth"
 	"github.com/gw2auth/gw2auth.com-api/service/gw2"
+	"github.com/gw2auth/gw2auth.com-api/service/verification"
 	"github.com/gw2auth/gw2auth.com-api/util"
 	"github.com/jackc/pgx/v5"
 	"github.com/labstack/echo/v4"
@@ -62,6 +64,8 @@ func AddOrUpdateApiTokenEndpoint(gw2ApiClient *gw2.ApiClient) echo.HandlerFunc {
 		gw2Acc, tokenInfo, err := accountAndTokenInfo(ctx, gw2ApiClient, body.ApiToken)
 		if err != nil {
 			return httpErrorForGw2ApiError(err)
+		} else if tokenInfo.IsSubToken() {
+			return subTokenHTTPError()
 		}
 
 		if !expectGw2AccountId.IsNil() && expectGw2AccountId != gw2Acc.Id {
@@ -113,6 +117,11 @@ SELECT
 				return echo.NewHTTPError(http.StatusNotAcceptable, "the gw2account is already verified for another gw2auth account")
 			}
 
+			// subtokens created from the previous api token must not be handed out anymore
+			if _, err := tx.Exec(ctx, `DELETE FROM gw2_account_api_subtokens WHERE account_id = $1 AND gw2_account_id = $2`, session.AccountId, gw2Acc.Id); err != nil {
+				return err
+			}
+
 			sql = `
 WITH gw2_account AS (
 	INSERT INTO gw2_accounts
@@ -160,24 +169,24 @@ last_valid_check_time = EXCLUDED.last_valid_check_time
 				return err
 			}
 
+			logType := accountlog.TypeApiTokenAdded
+			if existingTokenPermissionsBitSet != nil {
+				logType = accountlog.TypeApiTokenUpdated
+			}
+
+			err = accountlog.Write(ctx, tx, session.AccountId, logType, accountlog.Fields{
+				"gw2AccountId":   gw2Acc.Id,
+				"gw2AccountName": gw2Acc.Name,
+				"permissions":    tokenInfo.Permissions,
+				"verified":       isVerifiedAdd,
+			})
+
+			if err != nil {
+				return err
+			}
+
 			if isVerifiedAdd {
-				sqls := []string{
-					"DELETE FROM gw2_account_api_tokens WHERE gw2_account_id = $1 AND account_id != $2",
-					"INSERT INTO gw2_account_verifications (gw2_account_id, account_id) VALUES ($1, $2) ON CONFLICT (gw2_account_id) DO UPDATE SET account_id = EXCLUDED.account_id",
-				}
-
-				for _, sql = range sqls {
-					_, err = tx.Exec(
-						ctx,
-						sql,
-						gw2Acc.Id,
-						session.AccountId,
-					)
-
-					if err != nil {
-						return err
-					}
-				}
+				return verification.MarkVerified(ctx, tx, session.AccountId, gw2Acc.Id)
 			}
 
 			return nil
@@ -221,8 +230,14 @@ DELETE FROM gw2_account_api_tokens
 WHERE account_id = $1
 AND gw2_account_id = $2
 `
-			_, err := tx.Exec(ctx, sql, session.AccountId, gw2AccountId)
-			return err
+			tag, err := tx.Exec(ctx, sql, session.AccountId, gw2AccountId)
+			if err != nil || tag.RowsAffected() < 1 {
+				return err
+			}
+
+			return accountlog.Write(ctx, tx, session.AccountId, accountlog.TypeApiTokenDeleted, accountlog.Fields{
+				"gw2AccountId": gw2AccountId,
+			})
 		})
 
 		if err != nil {
@@ -266,12 +281,19 @@ func accountAndTokenInfo(ctx context.Context, client *gw2.ApiClient, token strin
 
 func httpErrorForGw2ApiError(err error) error {
 	if errors.Is(err, gw2.ErrInvalidApiToken) {
-		return echo.NewHTTPError(http.StatusBadRequest, err)
+		return util.NewError(http.StatusBadRequest, "gw2_api_token_invalid", "the provided apitoken is invalid").WithCause(err)
+	} else if errors.Is(err, gw2.ErrCircuitOpen) {
+		return util.NewError(http.StatusServiceUnavailable, "gw2_api_unavailable", "the gw2 api is currently unavailable").WithCause(err)
 	} else if gw2.IsApiError(err) {
-		return echo.NewHTTPError(http.StatusBadGateway, err)
+		return util.NewError(http.StatusBadGateway, "gw2_api_error", "the gw2 api responded with an error").WithCause(err)
 	}
 
-	return echo.NewHTTPError(http.StatusInternalServerError, err)
+	return util.NewError(http.StatusInternalServerError, util.ErrCodeInternal, "internal server error").WithCause(err)
+}
+
+// subtokens expire and may be restricted to specific urls, so they are never accepted in place of an apitoken
+func subTokenHTTPError() error {
+	return util.NewError(http.StatusBadRequest, "gw2_api_token_is_subtoken", "the provided apitoken is a subtoken; please provide a regular apitoken")
 }
 
 func verifyTokenName(name, sessionId string) bool {
@@ -22,6 +22,7 @@ func TestGw2ApiTokenAll(t *testing.T) {
 		"AddOrUpdateApiTokenEndpoint": {
 			"unauthorized":                       testAddOrUpdateApiTokenEndpointUnauthorized,
 			"add invalid token":                  testAddOrUpdateApiTokenEndpointAddInvalidApiToken,
+			"add subtoken":                       testAddOrUpdateApiTokenEndpointAddSubToken,
 			"add happycase":                      testAddOrUpdateApiTokenEndpointAddHappycase,
 			"add with verification":              testAddOrUpdateApiTokenEndpointAddWithVerification,
 			"update happycase":                   testAddOrUpdateApiTokenEndpointUpdateHappycase,
@@ -61,7 +62,40 @@ func testAddOrUpdateApiTokenEndpointAddInvalidApiToken(t *testing.T, pool *pgxpo
 		e.ServeHTTP(rec, req)
 
 		assert.Equal(t, http.StatusBadRequest, rec.Code)
-		assert.JSONEq(t, `{"message": "invalid api token\nstatus=401 response=[]"}`, rec.Body.String())
+		assert.JSONEq(t, `{"code": "gw2_api_token_invalid", "message": "the provided apitoken is invalid"}`, rec.Body.String())
+
+		return nil
+	}))
+}
+
+func testAddOrUpdateApiTokenEndpointAddSubToken(t *testing.T, pool *pgxpool.Pool, conv *service.SessionJwtConverter, truncateTablesFn func() error) {
+	gw2AccountId := uuid.FromStringOrNil("93df54c6-78f7-e111-809d-78e7d1936ef0")
+
+	mux := http.NewServeMux()
+	prepareMuxForAccountRequest(mux, "testSubToken", gw2AccountId, "Felix.9127")
+	mux.HandleFunc("/v2/tokeninfo", func(w http.ResponseWriter, req *http.Request) {
+		expiresAt := time.Now().Add(time.Hour)
+		_ = json.NewEncoder(w).Encode(gw2.TokenInfo{
+			Name:        "TokenName",
+			Permissions: []gw2.Permission{gw2.PermissionAccount},
+			Type:        gw2.TokenTypeSubToken,
+			ExpiresAt:   &expiresAt,
+		})
+	})
+
+	assert.NoError(t, test.WithGw2ApiClient(mux, func(gw2ApiClient *gw2.ApiClient) error {
+		req := httptest.NewRequest(http.MethodPut, "/", strings.NewReader(`{"apiToken": "testSubToken"}`))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		accountId, _, _, _ := test.Authenticated(t, req, pool, conv, "google", "GoogleA")
+
+		e := newEchoWithMiddleware(pool, conv)
+		e.PUT("/", AddOrUpdateApiTokenEndpoint(gw2ApiClient))
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+
+		assert.Equal(t, http.StatusBadRequest, rec.Code)
+		assert.JSONEq(t, `{"code": "gw2_api_token_is_subtoken", "message": "the provided apitoken is a subtoken; please provide a regular apitoken"}`, rec.Body.String())
+		test.MustNotExist(t, pool, `SELECT TRUE FROM gw2_account_api_tokens WHERE account_id = $1`, accountId)
 
 		return nil
 	}))
@@ -158,6 +192,7 @@ func testAddOrUpdateApiTokenEndpointUpdateHappycase(t *testing.T, pool *pgxpool.
 
 			test.CreateGw2Account(t, pool, accountId, gw2AccountId, "Felix.9127", "Felix.9127")
 			test.CreateGw2ApiToken(t, pool, accountId, gw2AccountId, "oldApiToken", []gw2.Permission{})
+			test.CreateGw2ApiSubToken(t, pool, accountId, gw2AccountId, []gw2.Permission{gw2.PermissionAccount}, "oldSubToken", time.Now().Add(time.Hour))
 
 			start := time.Now()
 			rec := httptest.NewRecorder()
@@ -199,6 +234,7 @@ AND tk.gw2_api_permissions_bit_set = $4
 					"testApiToken",
 					gw2.PermissionsToBitSet([]gw2.Permission{gw2.PermissionAccount}),
 				)
+				test.MustNotExist(t, pool, `SELECT TRUE FROM gw2_account_api_subtokens WHERE account_id = $1`, accountId)
 			}
 		}
 
@@ -346,7 +382,7 @@ func testAddOrUpdateApiTokenEndpointVerifiedForOtherGw2AuthAccount(t *testing.T,
 			e.ServeHTTP(rec, req)
 
 			assert.Equal(t, http.StatusNotAcceptable, rec.Code)
-			assert.JSONEq(t, `{"message": "the gw2account is already verified for another gw2auth account"}`, rec.Body.String())
+			assert.JSONEq(t, `{"code": "not_acceptable", "message": "the gw2account is already verified for another gw2auth account"}`, rec.Body.String())
 
 			test.MustNotExist(
 				t,
@@ -11,6 +11,7 @@ import (
 	"github.com/gw2auth/gw2auth.com-api/service"
 	"github.com/gw2auth/gw2auth.com-api/service/auth"
 	"github.com/gw2auth/gw2auth.com-api/telemetry"
+	"github.com/gw2auth/gw2auth.com-api/util"
 	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgxpool"
 	"github.com/labstack/echo/v4"
@@ -18,6 +19,7 @@ import (
 	"go.opentelemetry.io/otel/attribute"
 	"go.opentelemetry.io/otel/trace"
 	"log/slog"
+	"net"
 	"net/http"
 	"slices"
 	"strconv"
@@ -28,9 +30,11 @@ import (
 const sessionCookieName = "BEARER"
 const cfLatHeaderName = "Cloudfront-Viewer-Latitude"
 const cfLngHeaderName = "Cloudfront-Viewer-Longitude"
+const cfAddressHeaderName = "Cloudfront-Viewer-Address"
 
 type requestContextKey struct{}
 type sessionContextKey struct{}
+type sessionCacheContextKey struct{}
 type applicationApiKeyContextKey struct{}
 
 type RequestContext interface {
@@ -102,7 +106,40 @@ func Middleware(pool *pgxpool.Pool) echo.MiddlewareFunc {
 	})
 }
 
-func AuthenticatedMiddleware(conv *service.SessionJwtConverter) echo.MiddlewareFunc {
+// sessionLastSeenInterval is how often the last seen time of a session is advanced while the session is not renewed
+const sessionLastSeenInterval = 5 * time.Minute
+
+type AuthenticatedMiddlewareOption func(o *authenticatedMiddlewareOptions)
+
+type authenticatedMiddlewareOptions struct {
+	cache          *auth.SessionCache
+	renewThreshold time.Duration
+}
+
+// WithSessionCache keeps validated sessions in memory; sessions deleted through handlers are revoked in the cache immediately.
+// Revocation is best-effort and local to this instance: other instances keep accepting deleted sessions until their cached entries expire.
+func WithSessionCache(cache *auth.SessionCache) AuthenticatedMiddlewareOption {
+	return func(o *authenticatedMiddlewareOptions) {
+		o.cache = cache
+	}
+}
+
+// WithSessionRenewThreshold only extends the session and re-issues the cookie once the remaining lifetime of the session
+// drops below d or the location of the request changed. All other requests only read the session
+// and advance its last seen time at most once every sessionLastSeenInterval.
+// The default of 0 renews the session on every request.
+func WithSessionRenewThreshold(d time.Duration) AuthenticatedMiddlewareOption {
+	return func(o *authenticatedMiddlewareOptions) {
+		o.renewThreshold = d
+	}
+}
+
+func AuthenticatedMiddleware(conv *service.SessionJwtConverter, policy auth.AnomalyPolicy, options ...AuthenticatedMiddlewareOption) echo.MiddlewareFunc {
+	var opts authenticatedMiddlewareOptions
+	for _, opt := range options {
+		opt(&opts)
+	}
+
 	tracer := otel.Tracer("github.com/gw2auth/gw2auth.com-api::AuthenticatedMiddleware", trace.WithInstrumentationVersion("v0.0.1"))
 
 	updateCookie := func(c echo.Context, cookie *http.Cookie, newValue string, exp time.Time) {
@@ -127,6 +164,7 @@ func AuthenticatedMiddleware(conv *service.SessionJwtConverter) echo.MiddlewareF
 		}
 
 		if sessionId != "" {
+			opts.cache.Revoke(sessionId)
 			err = rctx.ExecuteTx(ctx, pgx.TxOptions{}, func(tx pgx.Tx) error {
 				return auth.DeleteSession(ctx, tx, sessionId)
 			})
@@ -160,6 +198,10 @@ func AuthenticatedMiddleware(conv *service.SessionJwtConverter) echo.MiddlewareF
 			return ctx, nil, onErr(c, rctx, cookie, "", err)
 		}
 
+		if opts.cache.IsRevoked(claims.SessionId) {
+			return ctx, nil, onErr(c, rctx, cookie, "", errors.New("the session was revoked"))
+		}
+
 		var sessionMetadata auth.SessionMetadata
 		if cfLat, cfLng := c.Request().Header.Get(cfLatHeaderName), c.Request().Header.Get(cfLngHeaderName); cfLat != "" && cfLng != "" {
 			sessionMetadata.Lat, err = strconv.ParseFloat(cfLat, 64)
@@ -176,21 +218,19 @@ func AuthenticatedMiddleware(conv *service.SessionJwtConverter) echo.MiddlewareF
 			// continue with zero value of SessionMetadata
 		}
 
-		var session auth.Session
-		err = rctx.ExecuteTx(ctx, pgx.TxOptions{}, func(tx pgx.Tx) error {
-			return auth.LoadAndUpdateSession(ctx, tx, claims.SessionId, claims.EncryptionKey, iat, sessionMetadata, &session)
-		})
-
+		session, renewed, err := opts.session(ctx, rctx, policy, claims, iat, sessionMetadata)
 		if err != nil {
 			return ctx, nil, onErr(c, rctx, cookie, claims.SessionId, err)
 		}
 
-		jwtStr, err := conv.WriteJWT(claims, session.ExpirationTime)
-		if err != nil {
-			return ctx, nil, onErr(c, rctx, cookie, claims.SessionId, err)
-		}
+		if renewed {
+			jwtStr, err := conv.WriteJWT(claims, session.ExpirationTime)
+			if err != nil {
+				return ctx, nil, onErr(c, rctx, cookie, claims.SessionId, err)
+			}
 
-		updateCookie(c, cookie, jwtStr, session.ExpirationTime)
+			updateCookie(c, cookie, jwtStr, session.ExpirationTime)
+		}
 
 		// this cookie should no longer be persisted if the request is already authenticated
 		if cookie, err := c.Cookie("REDIRECT_URI"); err == nil {
@@ -212,11 +252,98 @@ func AuthenticatedMiddleware(conv *service.SessionJwtConverter) echo.MiddlewareF
 				attribute.String("session.account.id", session.AccountId.String()),
 			),
 		)
-		return withSession(ctx, session), func() { span.End() }, nil
+		ctx = withSessionCache(withSession(ctx, session), opts.cache)
+		return ctx, func() { span.End() }, nil
 	})
 }
 
-func ApplicationAPIKeyAuthenticatedMiddleware() echo.MiddlewareFunc {
+// session returns the session of the request and whether it was renewed.
+// The session is only renewed if required, every renewal must be followed by re-issuing the cookie:
+// the anomaly evaluation relies on the stored metadata being as old as the iat of the cookie.
+func (o authenticatedMiddlewareOptions) session(ctx context.Context, rctx *requestContext, policy auth.AnomalyPolicy, claims service.SessionJwtClaims, iat time.Time, metadata auth.SessionMetadata) (auth.Session, bool, error) {
+	needsRenewal := func(sess auth.Session) bool {
+		return o.renewThreshold <= 0 || time.Until(sess.ExpirationTime) < o.renewThreshold || sess.Metadata != metadata
+	}
+
+	session, ok := o.cache.Get(claims.SessionId)
+	if !ok && o.renewThreshold > 0 {
+		err := rctx.ExecuteTx(ctx, pgx.TxOptions{AccessMode: pgx.ReadOnly}, func(tx pgx.Tx) error {
+			return auth.LoadSession(ctx, tx, claims.SessionId, claims.EncryptionKey, &session)
+		})
+
+		if err != nil {
+			return auth.Session{}, false, err
+		}
+
+		o.cache.Put(session)
+		ok = true
+	}
+
+	if ok && !needsRenewal(session) {
+		if now := time.Now(); now.Sub(session.LastSeenTime) >= sessionLastSeenInterval {
+			err := rctx.ExecuteTx(ctx, pgx.TxOptions{}, func(tx pgx.Tx) error {
+				return auth.TouchSession(ctx, tx, session.Id, now)
+			})
+
+			if err != nil {
+				slog.WarnContext(ctx, "failed to update the last seen time of the session", telemetry.Error(err))
+			} else {
+				session.LastSeenTime = now
+				o.cache.Put(session)
+			}
+		}
+
+		return session, false, nil
+	}
+
+	err := rctx.ExecuteTx(ctx, pgx.TxOptions{}, func(tx pgx.Tx) error {
+		return auth.LoadAndUpdateSession(ctx, tx, policy, claims.SessionId, claims.EncryptionKey, iat, metadata, &session)
+	})
+
+	if err != nil {
+		return auth.Session{}, false, err
+	}
+
+	o.cache.Put(session)
+	return session, true, nil
+}
+
+type ApplicationAPIKeyMiddlewareOption func(o *applicationAPIKeyMiddlewareOptions)
+
+type applicationAPIKeyMiddlewareOptions struct {
+	usage   *auth.APIKeyUsageRecorder
+	cache   *auth.APIKeyCache
+	limiter *auth.APIKeyFailureLimiter
+}
+
+// WithAPIKeyUsageRecorder records the time, approximate location and count of requests of every successfully authenticated api key.
+func WithAPIKeyUsageRecorder(usage *auth.APIKeyUsageRecorder) ApplicationAPIKeyMiddlewareOption {
+	return func(o *applicationAPIKeyMiddlewareOptions) {
+		o.usage = usage
+	}
+}
+
+// WithAPIKeyCache skips the verification of the secret for keys which were verified recently.
+func WithAPIKeyCache(cache *auth.APIKeyCache) ApplicationAPIKeyMiddlewareOption {
+	return func(o *applicationAPIKeyMiddlewareOptions) {
+		o.cache = cache
+	}
+}
+
+// WithAPIKeyFailureLimiter rejects requests for key ids with too many recent invalid secrets, in total or from the same client ip, before verifying the secret.
+// Keys found in the cache are never rejected.
+func WithAPIKeyFailureLimiter(limiter *auth.APIKeyFailureLimiter) ApplicationAPIKeyMiddlewareOption {
+	return func(o *applicationAPIKeyMiddlewareOptions) {
+		o.limiter = limiter
+	}
+}
+
+func ApplicationAPIKeyAuthenticatedMiddleware(options ...ApplicationAPIKeyMiddlewareOption) echo.MiddlewareFunc {
+	var opts applicationAPIKeyMiddlewareOptions
+	for _, opt := range options {
+		opt(&opts)
+	}
+
 	tracer := otel.Tracer("github.com/gw2auth/gw2auth.com-api::APIKeyAuthenticatedMiddleware", trace.WithInstrumentationVersion("v0.0.1"))
 
 	return contextManipulatingMiddleware(func(c echo.Context) (context.Context, context.CancelFunc, error) {
@@ -238,10 +365,16 @@ func ApplicationAPIKeyAuthenticatedMiddleware() echo.MiddlewareFunc {
 			return ctx, nil, echo.NewHTTPError(http.StatusUnauthorized)
 		}
 
-		var apiKey auth.ApiKey
-		var apiKeyEncoded string
-		err = rctx.ExecuteTx(ctx, pgx.TxOptions{AccessMode: pgx.ReadOnly}, func(tx pgx.Tx) error {
-			const sql = `
+		apiKey, cached := opts.cache.Get(keyId, keyRaw)
+		if !cached {
+			clientIp := requestClientIp(c)
+			if opts.limiter.Blocked(keyId, clientIp) {
+				return ctx, nil, echo.NewHTTPError(http.StatusTooManyRequests)
+			}
+
+			var apiKeyEncoded string
+			err = rctx.ExecuteTx(ctx, pgx.TxOptions{AccessMode: pgx.ReadOnly}, func(tx pgx.Tx) error {
+				const sql = `
 SELECT
     k.key,
     k.id,
@@ -256,30 +389,48 @@ ON k.application_id = app.id
 WHERE k.id = $1
 `
 
-			return tx.QueryRow(ctx, sql, keyId).Scan(
-				&apiKeyEncoded,
-				&apiKey.Id,
-				&apiKey.ApplicationId,
-				&apiKey.Permissions,
-				&apiKey.NotBefore,
-				&apiKey.ExpiresAt,
-				&apiKey.AccountId,
-			)
-		})
+				return tx.QueryRow(ctx, sql, keyId).Scan(
+					&apiKeyEncoded,
+					&apiKey.Id,
+					&apiKey.ApplicationId,
+					&apiKey.Permissions,
+					&apiKey.NotBefore,
+					&apiKey.ExpiresAt,
+					&apiKey.AccountId,
+				)
+			})
 
-		if err != nil {
-			if errors.Is(err, pgx.ErrNoRows) {
+			if err != nil {
+				if errors.Is(err, pgx.ErrNoRows) {
+					return ctx, nil, echo.NewHTTPError(http.StatusUnauthorized)
+				}
+
+				return ctx, nil, echo.NewHTTPError(http.StatusInternalServerError)
+			}
+
+			now := time.Now()
+			if now.Before(apiKey.NotBefore) || now.After(apiKey.ExpiresAt) || len(apiKey.Permissions) < 1 {
 				return ctx, nil, echo.NewHTTPError(http.StatusUnauthorized)
 			}
 
-			return ctx, nil, echo.NewHTTPError(http.StatusInternalServerError)
-		}
+			valid, needsRehash := service.DefaultArgon2Policy.VerifyAndNeedsRehash(apiKeyEncoded, []byte(keyRaw))
+			if !valid {
+				opts.limiter.Fail(keyId, clientIp)
+				return ctx, nil, echo.NewHTTPError(http.StatusUnauthorized)
+			}
 
-		now := time.Now()
-		if now.Before(apiKey.NotBefore) || now.After(apiKey.ExpiresAt) || len(apiKey.Permissions) < 1 || !service.VerifyArgon2id(apiKeyEncoded, []byte(keyRaw)) {
-			return ctx, nil, echo.NewHTTPError(http.StatusUnauthorized)
+			if needsRehash {
+				if err = rehashApplicationAPIKey(ctx, rctx, keyId, apiKeyEncoded, keyRaw); err != nil {
+					slog.WarnContext(ctx, "failed to rehash application api key", slog.String("application.api_key.id", keyId.String()), slog.String("error", err.Error()))
+				}
+			}
+
+			opts.limiter.Reset(keyId, clientIp)
+			opts.cache.Put(apiKey, keyRaw)
 		}
 
+		opts.usage.Record(apiKey.Id, time.Now(), requestLocation(c))
+
 		ctx, span := tracer.Start(
 			ctx,
 			"APIKeyAuthenticated",
@@ -295,6 +446,67 @@ WHERE k.id = $1
 	})
 }
 
+// rehashApplicationAPIKey replaces the stored hash of the key with one using the current parameters.
+// Only the hash the request verified against is replaced, so concurrent upgrades do not overwrite each other.
+func rehashApplicationAPIKey(ctx context.Context, rctx RequestContext, keyId uuid.UUID, apiKeyEncoded, keyRaw string) error {
+	rehashed, err := service.DefaultArgon2Policy.Encode([]byte(keyRaw))
+	if err != nil {
+		return err
+	}
+
+	return rctx.ExecuteTx(ctx, pgx.TxOptions{}, func(tx pgx.Tx) error {
+		_, err := tx.Exec(ctx, `UPDATE application_api_keys SET key = $3 WHERE id = $1 AND key = $2`, keyId, apiKeyEncoded, rehashed)
+		return err
+	})
+}
+
+// requestLocation reads the location of the request from the CloudFront headers.
+// The zero value is returned if the headers are absent or invalid.
+func requestLocation(c echo.Context) auth.SessionMetadata {
+	cfLat, cfLng := c.Request().Header.Get(cfLatHeaderName), c.Request().Header.Get(cfLngHeaderName)
+	if cfLat == "" || cfLng == "" {
+		return auth.SessionMetadata{}
+	}
+
+	lat, latErr := strconv.ParseFloat(cfLat, 64)
+	lng, lngErr := strconv.ParseFloat(cfLng, 64)
+	if latErr != nil || lngErr != nil {
+		return auth.SessionMetadata{}
+	}
+
+	return auth.SessionMetadata{Lat: lat, Lng: lng}
+}
+
+// requestClientIp reads the ip of the client from the CloudFront headers, falling back to the ip echo sees.
+func requestClientIp(c echo.Context) string {
+	if cfAddr := c.Request().Header.Get(cfAddressHeaderName); cfAddr != "" {
+		if host, _, err := net.SplitHostPort(cfAddr); err == nil {
+			return host
+		}
+	}
+
+	return c.RealIP()
+}
+
+// StepUpMiddleware guards sensitive operations: sessions which showed suspicious activity have to re-authenticate first.
+// Must be used after AuthenticatedMiddleware.
+func StepUpMiddleware() echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			session, ok := c.Request().Context().Value(sessionContextKey{}).(auth.Session)
+			if !ok {
+				return echo.NewHTTPError(http.StatusUnauthorized)
+			}
+
+			if session.StepUpRequired {
+				return util.NewError(http.StatusForbidden, "reauthentication_required", "this operation requires you to sign in again")
+			}
+
+			return next(c)
+		}
+	}
+}
+
 func ApplicationAPIKeyPermissionMiddleware(requiredPermissions ...auth.Permission) echo.MiddlewareFunc {
 	return func(next echo.HandlerFunc) echo.HandlerFunc {
 		return func(c echo.Context) error {
@@ -436,3 +648,14 @@ func withSession(ctx context.Context, session auth.Session) context.Context {
 func withApiKey(ctx context.Context, apiKey auth.ApiKey) context.Context {
 	return context.WithValue(ctx, applicationApiKeyContextKey{}, apiKey)
 }
+
+func withSessionCache(ctx context.Context, cache *auth.SessionCache) context.Context {
+	return context.WithValue(ctx, sessionCacheContextKey{}, cache)
+}
+
+// revokeSessions removes deleted sessions from the local session cache, if any; the sessions must be deleted in the database as well
+func revokeSessions(ctx context.Context, ids ...string) {
+	if cache, ok := ctx.Value(sessionCacheContextKey{}).(*auth.SessionCache); ok {
+		cache.Revoke(ids...)
+	}
+}
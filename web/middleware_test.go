@@ -0,0 +1,322 @@
+package web
+
+import (
+	"context"
+	"github.com/gofrs/uuid/v5"
+	"github.com/gw2auth/gw2auth.com-api/internal/test"
+	"github.com/gw2auth/gw2auth.com-api/service"
+	"github.com/gw2auth/gw2auth.com-api/service/auth"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestMiddlewareAll(t *testing.T) {
+	test.RunAll(t, dbScope, map[string]map[string]test.Fn{
+		"AuthenticatedMiddleware": {
+			"suspicious location requires step-up": testAuthenticatedMiddlewareSuspiciousLocation,
+			"trusted region":                       testAuthenticatedMiddlewareTrustedRegion,
+			"renew threshold":                      testAuthenticatedMiddlewareRenewThreshold,
+			"revoked session":                      testAuthenticatedMiddlewareRevokedSession,
+		},
+		"ApplicationAPIKeyAuthenticatedMiddleware": {
+			"outdated hash is upgraded": testApplicationAPIKeyAuthenticatedMiddlewareRehash,
+			"current hash is kept":      testApplicationAPIKeyAuthenticatedMiddlewareNoRehash,
+			"invalid secret":            testApplicationAPIKeyAuthenticatedMiddlewareInvalidSecret,
+			"expired":                   testApplicationAPIKeyAuthenticatedMiddlewareExpired,
+			"cached":                    testApplicationAPIKeyAuthenticatedMiddlewareCached,
+			"failure limit":             testApplicationAPIKeyAuthenticatedMiddlewareFailureLimit,
+			"failure limit cached":      testApplicationAPIKeyAuthenticatedMiddlewareFailureLimitCached,
+			"failure limit per key":     testApplicationAPIKeyAuthenticatedMiddlewareFailureLimitPerKey,
+		},
+	})
+}
+
+func testAuthenticatedMiddlewareSuspiciousLocation(t *testing.T, pool *pgxpool.Pool, conv *service.SessionJwtConverter, truncateTablesFn func() error) {
+	e := newEchoWithMiddleware(pool, conv)
+	e.GET("/", AuthInfoEndpoint())
+	e.DELETE("/", DeleteAccountEndpoint(), StepUpMiddleware())
+
+	// new york, while the session was last used in berlin
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	accountId, sessionId, _, _ := test.Authenticated(t, req, pool, conv, "google", "GoogleA")
+	req.Header.Set("Cloudfront-Viewer-Latitude", "40.7128")
+	req.Header.Set("Cloudfront-Viewer-Longitude", "-74.0060")
+
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+
+	if assert.Equal(t, http.StatusOK, rec.Code) {
+		assert.Contains(t, rec.Body.String(), `"stepUpRequired":true`)
+	}
+
+	test.MustExist(t, pool, `SELECT 1 FROM account_federation_sessions WHERE id = $1 AND step_up_required`, sessionId)
+
+	// the location is plausible for the next request, but the flag stays for the lifetime of the session
+	req = httptest.NewRequest(http.MethodDelete, "/", nil)
+	for _, cookie := range rec.Result().Cookies() {
+		req.AddCookie(cookie)
+	}
+	req.Header.Set("Cloudfront-Viewer-Latitude", "40.7128")
+	req.Header.Set("Cloudfront-Viewer-Longitude", "-74.0060")
+
+	rec = httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+
+	assert.Equal(t, http.StatusForbidden, rec.Code)
+	assert.Contains(t, rec.Body.String(), `"code":"reauthentication_required"`)
+	test.MustExist(t, pool, `SELECT 1 FROM accounts WHERE id = $1`, accountId)
+}
+
+func testAuthenticatedMiddlewareTrustedRegion(t *testing.T, pool *pgxpool.Pool, conv *service.SessionJwtConverter, truncateTablesFn func() error) {
+	e := newEchoWithMiddleware(pool, conv)
+	e.DELETE("/", DeleteAccountEndpoint(), StepUpMiddleware())
+
+	req := httptest.NewRequest(http.MethodDelete, "/", nil)
+	accountId, sessionId, _, _ := test.Authenticated(t, req, pool, conv, "google", "GoogleA")
+	test.MustExec(
+		t,
+		pool,
+		`INSERT INTO account_trusted_regions (id, account_id, creation_time, display_name, lat, lng, radius_km) VALUES ($1, $2, NOW(), 'New York', 40.7, -74, 50)`,
+		test.NewUUID(t),
+		accountId,
+	)
+
+	req.Header.Set("Cloudfront-Viewer-Latitude", "40.7128")
+	req.Header.Set("Cloudfront-Viewer-Longitude", "-74.0060")
+
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+
+	assert.Equal(t, http.StatusOK, rec.Code)
+	test.MustNotExist(t, pool, `SELECT 1 FROM account_federation_sessions WHERE id = $1`, sessionId)
+}
+
+func testAuthenticatedMiddlewareRenewThreshold(t *testing.T, pool *pgxpool.Pool, conv *service.SessionJwtConverter, truncateTablesFn func() error) {
+	e := echo.New()
+	e.HTTPErrorHandler = HTTPErrorHandler
+	e.Use(Middleware(pool), AuthenticatedMiddleware(conv, auth.DefaultAnomalyPolicy(), WithSessionRenewThreshold(30*time.Minute)))
+	e.GET("/", AuthInfoEndpoint())
+
+	// the session created by test.Authenticated expires in one hour
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	_, sessionId, _, expirationTime := test.Authenticated(t, req, pool, conv, "google", "GoogleA")
+
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Empty(t, rec.Result().Cookies())
+	test.MustExist(t, pool, `SELECT 1 FROM account_federation_sessions WHERE id = $1 AND expiration_time = $2`, sessionId, expirationTime)
+
+	// the last seen time is advanced without renewing the session
+	test.MustExec(t, pool, `UPDATE account_federation_sessions SET last_seen_time = NOW() - INTERVAL '1 hour' WHERE id = $1`, sessionId)
+	rec = httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Empty(t, rec.Result().Cookies())
+	test.MustExist(t, pool, `SELECT 1 FROM account_federation_sessions WHERE id = $1 AND expiration_time = $2 AND last_seen_time > NOW() - INTERVAL '1 minute'`, sessionId, expirationTime)
+
+	// a changed location always renews the session
+	req.Header.Set("Cloudfront-Viewer-Latitude", "52.6")
+	rec = httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.NotEmpty(t, rec.Result().Cookies())
+	test.MustNotExist(t, pool, `SELECT 1 FROM account_federation_sessions WHERE id = $1 AND expiration_time = $2`, sessionId, expirationTime)
+}
+
+func testAuthenticatedMiddlewareRevokedSession(t *testing.T, pool *pgxpool.Pool, conv *service.SessionJwtConverter, truncateTablesFn func() error) {
+	cache := auth.NewSessionCache(time.Minute, 10)
+	e := echo.New()
+	e.HTTPErrorHandler = HTTPErrorHandler
+	e.Use(Middleware(pool), AuthenticatedMiddleware(conv, auth.DefaultAnomalyPolicy(), WithSessionCache(cache), WithSessionRenewThreshold(30*time.Minute)))
+	e.GET("/", AuthInfoEndpoint())
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	_, sessionId, _, _ := test.Authenticated(t, req, pool, conv, "google", "GoogleA")
+
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	// the session is served from the cache until it expires there ...
+	test.MustExec(t, pool, `DELETE FROM account_federation_sessions WHERE id = $1`, sessionId)
+	rec = httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	// ... or is revoked
+	cache.Revoke(sessionId)
+	rec = httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+}
+
+func testApplicationAPIKeyAuthenticatedMiddlewareRehash(t *testing.T, pool *pgxpool.Pool, conv *service.SessionJwtConverter, truncateTablesFn func() error) {
+	outdated := service.DefaultArgon2Policy
+	outdated.Memory /= 2
+
+	keyId, encoded := createApplicationAPIKey(t, pool, outdated, "secret", time.Now().Add(time.Hour))
+
+	rec := serveApplicationAPIKeyRequest(pool, keyId.String(), "secret")
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	var stored string
+	if !assert.NoError(t, pool.QueryRow(context.Background(), `SELECT key FROM application_api_keys WHERE id = $1`, keyId).Scan(&stored)) {
+		return
+	}
+
+	assert.NotEqual(t, encoded, stored)
+
+	valid, needsRehash := service.DefaultArgon2Policy.VerifyAndNeedsRehash(stored, []byte("secret"))
+	assert.True(t, valid)
+	assert.False(t, needsRehash)
+}
+
+func testApplicationAPIKeyAuthenticatedMiddlewareNoRehash(t *testing.T, pool *pgxpool.Pool, conv *service.SessionJwtConverter, truncateTablesFn func() error) {
+	keyId, encoded := createApplicationAPIKey(t, pool, service.DefaultArgon2Policy, "secret", time.Now().Add(time.Hour))
+
+	rec := serveApplicationAPIKeyRequest(pool, keyId.String(), "secret")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	test.MustExist(t, pool, `SELECT 1 FROM application_api_keys WHERE id = $1 AND key = $2`, keyId, encoded)
+}
+
+func testApplicationAPIKeyAuthenticatedMiddlewareInvalidSecret(t *testing.T, pool *pgxpool.Pool, conv *service.SessionJwtConverter, truncateTablesFn func() error) {
+	keyId, encoded := createApplicationAPIKey(t, pool, service.SpringArgon2Policy, "secret", time.Now().Add(time.Hour))
+
+	rec := serveApplicationAPIKeyRequest(pool, keyId.String(), "other")
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+	test.MustExist(t, pool, `SELECT 1 FROM application_api_keys WHERE id = $1 AND key = $2`, keyId, encoded)
+}
+
+func testApplicationAPIKeyAuthenticatedMiddlewareExpired(t *testing.T, pool *pgxpool.Pool, conv *service.SessionJwtConverter, truncateTablesFn func() error) {
+	keyId, encoded := createApplicationAPIKey(t, pool, service.SpringArgon2Policy, "secret", time.Now().Add(-time.Hour))
+
+	rec := serveApplicationAPIKeyRequest(pool, keyId.String(), "secret")
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+	test.MustExist(t, pool, `SELECT 1 FROM application_api_keys WHERE id = $1 AND key = $2`, keyId, encoded)
+}
+
+func testApplicationAPIKeyAuthenticatedMiddlewareCached(t *testing.T, pool *pgxpool.Pool, conv *service.SessionJwtConverter, truncateTablesFn func() error) {
+	keyId, _ := createApplicationAPIKey(t, pool, service.DefaultArgon2Policy, "secret", time.Now().Add(time.Hour))
+	cache := auth.NewAPIKeyCache(time.Minute, 10)
+	options := []ApplicationAPIKeyMiddlewareOption{WithAPIKeyCache(cache)}
+
+	rec := serveApplicationAPIKeyRequest(pool, keyId.String(), "secret", options...)
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	// the key is served from the cache until it expires there ...
+	test.MustExec(t, pool, `DELETE FROM application_api_keys WHERE id = $1`, keyId)
+	rec = serveApplicationAPIKeyRequest(pool, keyId.String(), "secret", options...)
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	// ... but only for the verified secret ...
+	rec = serveApplicationAPIKeyRequest(pool, keyId.String(), "other", options...)
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+
+	// ... or is invalidated
+	cache.Invalidate(keyId)
+	rec = serveApplicationAPIKeyRequest(pool, keyId.String(), "secret", options...)
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+}
+
+func testApplicationAPIKeyAuthenticatedMiddlewareFailureLimit(t *testing.T, pool *pgxpool.Pool, conv *service.SessionJwtConverter, truncateTablesFn func() error) {
+	keyId, _ := createApplicationAPIKey(t, pool, service.DefaultArgon2Policy, "secret", time.Now().Add(time.Hour))
+	options := []ApplicationAPIKeyMiddlewareOption{WithAPIKeyFailureLimiter(auth.NewAPIKeyFailureLimiter(10, 2, time.Minute))}
+
+	for range 2 {
+		rec := serveApplicationAPIKeyRequest(pool, keyId.String(), "other", options...)
+		assert.Equal(t, http.StatusUnauthorized, rec.Code)
+	}
+
+	// blocked before the secret is verified, even if it is correct ...
+	rec := serveApplicationAPIKeyRequest(pool, keyId.String(), "secret", options...)
+	assert.Equal(t, http.StatusTooManyRequests, rec.Code)
+
+	// ... but only for the client which presented the invalid secrets
+	rec = serveApplicationAPIKeyRequestFrom(pool, "198.51.100.7", keyId.String(), "secret", options...)
+	assert.Equal(t, http.StatusOK, rec.Code)
+}
+
+func testApplicationAPIKeyAuthenticatedMiddlewareFailureLimitPerKey(t *testing.T, pool *pgxpool.Pool, conv *service.SessionJwtConverter, truncateTablesFn func() error) {
+	keyId, _ := createApplicationAPIKey(t, pool, service.DefaultArgon2Policy, "secret", time.Now().Add(time.Hour))
+	options := []ApplicationAPIKeyMiddlewareOption{WithAPIKeyFailureLimiter(auth.NewAPIKeyFailureLimiter(3, 2, time.Minute))}
+
+	// rotating the client ip does not grant a fresh budget ...
+	for _, clientIp := range []string{"198.51.100.1", "198.51.100.2", "198.51.100.3"} {
+		rec := serveApplicationAPIKeyRequestFrom(pool, clientIp, keyId.String(), "other", options...)
+		assert.Equal(t, http.StatusUnauthorized, rec.Code)
+	}
+
+	// ... once the ceiling for the key id is reached, every client is blocked
+	rec := serveApplicationAPIKeyRequestFrom(pool, "198.51.100.4", keyId.String(), "secret", options...)
+	assert.Equal(t, http.StatusTooManyRequests, rec.Code)
+}
+
+func testApplicationAPIKeyAuthenticatedMiddlewareFailureLimitCached(t *testing.T, pool *pgxpool.Pool, conv *service.SessionJwtConverter, truncateTablesFn func() error) {
+	keyId, _ := createApplicationAPIKey(t, pool, service.DefaultArgon2Policy, "secret", time.Now().Add(time.Hour))
+	options := []ApplicationAPIKeyMiddlewareOption{
+		WithAPIKeyCache(auth.NewAPIKeyCache(time.Minute, 10)),
+		WithAPIKeyFailureLimiter(auth.NewAPIKeyFailureLimiter(10, 2, time.Minute)),
+	}
+
+	rec := serveApplicationAPIKeyRequest(pool, keyId.String(), "secret", options...)
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	for range 2 {
+		rec = serveApplicationAPIKeyRequest(pool, keyId.String(), "other", options...)
+		assert.Equal(t, http.StatusUnauthorized, rec.Code)
+	}
+
+	// the cached key is not affected by the failures
+	rec = serveApplicationAPIKeyRequest(pool, keyId.String(), "secret", options...)
+	assert.Equal(t, http.StatusOK, rec.Code)
+}
+
+func createApplicationAPIKey(t *testing.T, pool *pgxpool.Pool, policy service.Argon2Policy, secret string, expiresAt time.Time) (keyId uuid.UUID, encoded string) {
+	accountId, applicationId := test.NewUUID(t), test.NewUUID(t)
+	test.CreateAccount(t, pool, accountId, time.Now())
+	test.CreateApplication(t, pool, accountId, applicationId, "App")
+
+	encoded, err := policy.Encode([]byte(secret))
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+
+	keyId = test.NewUUID(t)
+	test.CreateApplicationAPIKey(t, pool, applicationId, keyId, encoded, []string{"read"}, time.Now().Add(-time.Hour), expiresAt)
+
+	return keyId, encoded
+}
+
+func serveApplicationAPIKeyRequest(pool *pgxpool.Pool, keyId, secret string, options ...ApplicationAPIKeyMiddlewareOption) *httptest.ResponseRecorder {
+	return serveApplicationAPIKeyRequestFrom(pool, "", keyId, secret, options...)
+}
+
+func serveApplicationAPIKeyRequestFrom(pool *pgxpool.Pool, clientIp, keyId, secret string, options ...ApplicationAPIKeyMiddlewareOption) *httptest.ResponseRecorder {
+	e := echo.New()
+	e.HTTPErrorHandler = HTTPErrorHandler
+	e.Use(Middleware(pool), ApplicationAPIKeyAuthenticatedMiddleware(options...))
+	e.GET("/", func(c echo.Context) error {
+		return c.NoContent(http.StatusOK)
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.SetBasicAuth(keyId, secret)
+	if clientIp != "" {
+		req.Header.Set(cfAddressHeaderName, net.JoinHostPort(clientIp, "443"))
+	}
+
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+
+	return rec
+}
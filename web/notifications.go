@@ -62,7 +62,7 @@ type notification struct {
 	Content string           `json:"content,omitempty"`
 }
 
-func NotificationsEndpoint(httpClient *http.Client) echo.HandlerFunc {
+func NotificationsEndpoint(httpClient *http.Client, gw2ApiURL, gw2EfficiencyStatusURL string) echo.HandlerFunc {
 	apiDowntimeStart := time.Unix(1761325200, 0)
 	apiDowntimeEnd := time.Unix(1761843600, 0)
 	relevantEndpoints := []string{
@@ -93,7 +93,7 @@ func NotificationsEndpoint(httpClient *http.Client) echo.HandlerFunc {
 			var endpointsWithIssues []string
 
 			g.Go(func() error {
-				req, err := http.NewRequestWithContext(gCtx, http.MethodGet, "https://api.guildwars2.com/v2.json", nil)
+				req, err := http.NewRequestWithContext(gCtx, http.MethodGet, gw2ApiURL+"/v2.json", nil)
 				if err != nil {
 					return echo.NewHTTPError(http.StatusInternalServerError)
 				}
@@ -126,7 +126,7 @@ func NotificationsEndpoint(httpClient *http.Client) echo.HandlerFunc {
 			})
 
 			g.Go(func() error {
-				req, err := http.NewRequestWithContext(gCtx, http.MethodGet, "https://status.gw2efficiency.com/api", nil)
+				req, err := http.NewRequestWithContext(gCtx, http.MethodGet, gw2EfficiencyStatusURL, nil)
 				if err != nil {
 					return echo.NewHTTPError(http.StatusInternalServerError)
 				}
@@ -2,16 +2,18 @@ package web
 
 import (
 	"github.com/gw2auth/gw2auth.com-api/service"
+	"github.com/gw2auth/gw2auth.com-api/service/auth"
 	"github.com/jackc/pgx/v5/pgxpool"
 	"github.com/labstack/echo/v4"
 )
 
 func newEchoWithMiddleware(pool *pgxpool.Pool, conv *service.SessionJwtConverter) *echo.Echo {
 	e := echo.New()
+	e.HTTPErrorHandler = HTTPErrorHandler
 	e.Use(
 		Middleware(pool),
 		DeleteHistoricalCookiesMiddleware(),
-		AuthenticatedMiddleware(conv),
+		AuthenticatedMiddleware(conv, auth.DefaultAnomalyPolicy()),
 	)
 
 	return e
@@ -1,7 +1,9 @@
 package web
 
 import (
+	"context"
 	"github.com/gofrs/uuid/v5"
+	"github.com/gw2auth/gw2auth.com-api/service/accountlog"
 	"github.com/gw2auth/gw2auth.com-api/service/auth"
 	"github.com/gw2auth/gw2auth.com-api/util"
 	"github.com/jackc/pgx/v5"
@@ -39,7 +41,82 @@ func UserApplicationsEndpoint() echo.HandlerFunc {
 
 		var results []applicationForList
 		err := rctx.ExecuteTx(ctx, pgx.TxOptions{AccessMode: pgx.ReadOnly}, func(tx pgx.Tx) error {
+			var err error
+			results, err = selectUserApplications(ctx, tx, session.AccountId)
+			return err
+		})
+
+		if err != nil {
+			return util.NewEchoPgxHTTPError(err)
+		}
+
+		return c.JSON(http.StatusOK, results)
+	})
+}
+
+func UserApplicationEndpoint() echo.HandlerFunc {
+	return wrapAuthenticatedHandlerFunc(func(c echo.Context, rctx RequestContext, session auth.Session) error {
+		applicationId, err := uuid.FromString(c.Param("id"))
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, err)
+		}
+
+		ctx := c.Request().Context()
+		var result application
+		err = rctx.ExecuteTx(ctx, pgx.TxOptions{AccessMode: pgx.ReadOnly}, func(tx pgx.Tx) error {
+			var err error
+			result, err = selectUserApplication(ctx, tx, session.AccountId, applicationId)
+			return err
+		})
+
+		if err != nil {
+			return util.NewEchoPgxHTTPError(err)
+		}
+
+		return c.JSON(http.StatusOK, result)
+	})
+}
+
+func DeleteUserApplicationEndpoint() echo.HandlerFunc {
+	return wrapAuthenticatedHandlerFunc(func(c echo.Context, rctx RequestContext, session auth.Session) error {
+		applicationId, err := uuid.FromString(c.Param("id"))
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, err)
+		}
+
+		ctx := c.Request().Context()
+		slog.InfoContext(
+			ctx,
+			"deleting user application (revoke access)",
+			slog.String("application.id", applicationId.String()),
+		)
+
+		err = rctx.ExecuteTx(ctx, pgx.TxOptions{}, func(tx pgx.Tx) error {
 			const sql = `
+DELETE FROM application_accounts
+WHERE account_id = $1
+AND application_id = $2
+`
+			tag, err := tx.Exec(ctx, sql, session.AccountId, applicationId)
+			if err != nil || tag.RowsAffected() < 1 {
+				return err
+			}
+
+			return accountlog.Write(ctx, tx, session.AccountId, accountlog.TypeApplicationRevoked, accountlog.Fields{
+				"applicationId": applicationId,
+			})
+		})
+
+		if err != nil {
+			return util.NewEchoPgxHTTPError(err)
+		}
+
+		return c.JSON(http.StatusOK, map[string]string{})
+	})
+}
+
+func selectUserApplications(ctx context.Context, tx pgx.Tx, accountId uuid.UUID) ([]applicationForList, error) {
+	const sql = `
 SELECT
     apps.id,
     MAX(apps.display_name),
@@ -70,45 +147,27 @@ ON app_client_auth.application_id = app_accs.application_id AND app_client_auth.
 WHERE app_accs.account_id = $1
 GROUP BY apps.id
 `
-			rows, err := tx.Query(ctx, sql, session.AccountId)
-			if err != nil {
-				return err
-			}
-
-			results, err = pgx.CollectRows(rows, func(row pgx.CollectableRow) (applicationForList, error) {
-				var app applicationForList
-				return app, row.Scan(
-					&app.Id,
-					&app.DisplayName,
-					&app.UserId,
-					&app.LastUsed,
-					&app.AuthorizedScopes,
-				)
-			})
-
-			return err
-		})
-
-		if err != nil {
-			return util.NewEchoPgxHTTPError(err)
-		}
-
-		return c.JSON(http.StatusOK, results)
+	rows, err := tx.Query(ctx, sql, accountId)
+	if err != nil {
+		return nil, err
+	}
+
+	return pgx.CollectRows(rows, func(row pgx.CollectableRow) (applicationForList, error) {
+		var app applicationForList
+		return app, row.Scan(
+			&app.Id,
+			&app.DisplayName,
+			&app.UserId,
+			&app.LastUsed,
+			&app.AuthorizedScopes,
+		)
 	})
 }
 
-func UserApplicationEndpoint() echo.HandlerFunc {
-	return wrapAuthenticatedHandlerFunc(func(c echo.Context, rctx RequestContext, session auth.Session) error {
-		applicationId, err := uuid.FromString(c.Param("id"))
-		if err != nil {
-			return echo.NewHTTPError(http.StatusBadRequest, err)
-		}
-
-		ctx := c.Request().Context()
-		var result application
-		err = rctx.ExecuteTx(ctx, pgx.TxOptions{AccessMode: pgx.ReadOnly}, func(tx pgx.Tx) error {
-			const sql = `
+// selectUserApplicationSql selects the details of all applications of account $1, or only of application $2 if not NULL
+const selectUserApplicationSql = `
 SELECT
+    app_accs.application_id,
     MAX(apps.display_name),
     MAX(app_acc_sub.account_sub),
     MAX(app_client_auth.last_update_time),
@@ -150,53 +209,26 @@ LEFT JOIN (
 ) AS app_client_auth
 ON app_client_auth.application_id = app_accs.application_id AND app_client_auth.account_id = app_accs.account_id
 WHERE app_accs.account_id = $1
-AND app_accs.application_id = $2
+AND ($2::UUID IS NULL OR app_accs.application_id = $2)
+GROUP BY app_accs.application_id
 `
-			return tx.QueryRow(ctx, sql, session.AccountId, applicationId).Scan(
-				&result.DisplayName,
-				&result.UserId,
-				&result.LastUsed,
-				&result.AuthorizedScopes,
-				&result.AuthorizedGw2Accounts,
-			)
-		})
-
-		if err != nil {
-			return util.NewEchoPgxHTTPError(err)
-		}
 
-		return c.JSON(http.StatusOK, result)
-	})
+func selectUserApplication(ctx context.Context, tx pgx.Tx, accountId, applicationId uuid.UUID) (application, error) {
+	_, result, err := scanUserApplication(tx.QueryRow(ctx, selectUserApplicationSql, accountId, applicationId))
+	return result, err
 }
 
-func DeleteUserApplicationEndpoint() echo.HandlerFunc {
-	return wrapAuthenticatedHandlerFunc(func(c echo.Context, rctx RequestContext, session auth.Session) error {
-		applicationId, err := uuid.FromString(c.Param("id"))
-		if err != nil {
-			return echo.NewHTTPError(http.StatusBadRequest, err)
-		}
-
-		ctx := c.Request().Context()
-		slog.InfoContext(
-			ctx,
-			"deleting user application (revoke access)",
-			slog.String("application.id", applicationId.String()),
-		)
-
-		err = rctx.ExecuteTx(ctx, pgx.TxOptions{}, func(tx pgx.Tx) error {
-			const sql = `
-DELETE FROM application_accounts
-WHERE account_id = $1
-AND application_id = $2
-`
-			_, err := tx.Exec(ctx, sql, session.AccountId, applicationId)
-			return err
-		})
-
-		if err != nil {
-			return util.NewEchoPgxHTTPError(err)
-		}
-
-		return c.JSON(http.StatusOK, map[string]string{})
-	})
+func scanUserApplication(row pgx.Row) (uuid.UUID, application, error) {
+	var applicationId uuid.UUID
+	var result application
+	err := row.Scan(
+		&applicationId,
+		&result.DisplayName,
+		&result.UserId,
+		&result.LastUsed,
+		&result.AuthorizedScopes,
+		&result.AuthorizedGw2Accounts,
+	)
+
+	return applicationId, result, err
 }
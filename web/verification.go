@@ -1,12 +1,16 @@
 package web
 
 import (
+	"errors"
 	"github.com/gofrs/uuid/v5"
+	"github.com/gw2auth/gw2auth.com-api/service/accountlog"
 	"github.com/gw2auth/gw2auth.com-api/service/auth"
 	"github.com/gw2auth/gw2auth.com-api/service/gw2"
+	"github.com/gw2auth/gw2auth.com-api/service/verification"
 	"github.com/gw2auth/gw2auth.com-api/util"
 	"github.com/jackc/pgx/v5"
 	"github.com/labstack/echo/v4"
+	"log/slog"
 	"net/http"
 	"time"
 )
@@ -29,6 +33,18 @@ type verificationPendingChallenge struct {
 	TimeoutTime           time.Time `json:"timeoutTime"`
 }
 
+type verificationStartRequest struct {
+	ChallengeId int `json:"challengeId"`
+}
+
+type verificationSubmitRequest struct {
+	ApiToken string `json:"apiToken"`
+}
+
+type verificationSubmitResponse struct {
+	IsSuccess bool `json:"isSuccess"`
+}
+
 type availableGw2Account struct {
 	Id                uuid.UUID `json:"id"`
 	Name              string    `json:"name"`
@@ -85,15 +101,8 @@ WHERE ch.account_id = $1
 		}
 
 		var reqPerms util.Set[gw2.Permission]
-		switch result.ChallengeId {
-		case 1:
-			reqPerms = util.NewSet(gw2.PermissionAccount)
-
-		case 2:
-			reqPerms = util.NewSet(gw2.PermissionAccount, gw2.PermissionTradingpost)
-
-		case 3:
-			reqPerms = util.NewSet(gw2.PermissionAccount, gw2.PermissionCharacters)
+		if challenge, ok := verification.Get(result.ChallengeId); ok {
+			reqPerms = util.NewSet(challenge.RequiredPermissions()...)
 		}
 
 		resultingAccs := make([]availableGw2Account, 0, len(result.AvailableGw2Accounts))
@@ -160,3 +169,247 @@ WHERE ch.account_id = $1
 		return c.JSON(http.StatusOK, result)
 	})
 }
+
+func StartVerificationEndpoint() echo.HandlerFunc {
+	return wrapAuthenticatedHandlerFunc(func(c echo.Context, rctx RequestContext, session auth.Session) error {
+		var body verificationStartRequest
+		if err := c.Bind(&body); err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, err)
+		}
+
+		challenge, ok := verification.Get(body.ChallengeId)
+		if !ok {
+			return echo.NewHTTPError(http.StatusBadRequest, "invalid challenge id")
+		}
+
+		state, err := challenge.NewState()
+		if err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, err)
+		}
+
+		ctx := c.Request().Context()
+		slog.InfoContext(
+			ctx,
+			"starting verification challenge",
+			slog.Int("verification.challenge.id", body.ChallengeId),
+		)
+
+		creationTime := time.Now()
+		err = rctx.ExecuteTx(ctx, pgx.TxOptions{}, func(tx pgx.Tx) error {
+			const sql = `
+INSERT INTO gw2_account_verification_challenges
+(account_id, challenge_id, state, creation_time)
+VALUES
+($1, $2, $3, $4)
+ON CONFLICT (account_id) DO UPDATE SET
+challenge_id = EXCLUDED.challenge_id,
+state = EXCLUDED.state,
+creation_time = EXCLUDED.creation_time
+`
+			if _, err := tx.Exec(ctx, sql, session.AccountId, body.ChallengeId, state, creationTime); err != nil {
+				return err
+			}
+
+			return accountlog.Write(ctx, tx, session.AccountId, accountlog.TypeVerificationStarted, accountlog.Fields{
+				"challengeId": body.ChallengeId,
+			})
+		})
+
+		if err != nil {
+			return util.NewEchoPgxHTTPError(err)
+		}
+
+		return c.JSON(http.StatusOK, verificationActiveChallenge{
+			ChallengeId:          body.ChallengeId,
+			State:                state,
+			CreationTime:         creationTime,
+			AvailableGw2Accounts: make([]availableGw2Account, 0),
+		})
+	})
+}
+
+func CancelVerificationEndpoint() echo.HandlerFunc {
+	return wrapAuthenticatedHandlerFunc(func(c echo.Context, rctx RequestContext, session auth.Session) error {
+		ctx := c.Request().Context()
+		slog.InfoContext(ctx, "cancelling verification challenge")
+
+		var deleted bool
+		err := rctx.ExecuteTx(ctx, pgx.TxOptions{}, func(tx pgx.Tx) error {
+			const sql = `
+DELETE FROM gw2_account_verification_challenges
+WHERE account_id = $1
+`
+			tag, err := tx.Exec(ctx, sql, session.AccountId)
+			if err != nil {
+				return err
+			}
+
+			deleted = tag.RowsAffected() > 0
+			return nil
+		})
+
+		if err != nil {
+			return util.NewEchoPgxHTTPError(err)
+		}
+
+		if !deleted {
+			return echo.NewHTTPError(http.StatusNotFound, "no active verification challenge")
+		}
+
+		return c.JSON(http.StatusOK, map[string]string{})
+	})
+}
+
+func SubmitVerificationEndpoint(gw2ApiClient *gw2.ApiClient) echo.HandlerFunc {
+	return wrapAuthenticatedHandlerFunc(func(c echo.Context, rctx RequestContext, session auth.Session) error {
+		var body verificationSubmitRequest
+		if err := c.Bind(&body); err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, err)
+		}
+
+		if body.ApiToken == "" {
+			return echo.NewHTTPError(http.StatusBadRequest, "the apitoken is invalid")
+		}
+
+		ctx := c.Request().Context()
+
+		var challengeId int
+		var state string
+		var startTime time.Time
+		err := rctx.ExecuteTx(ctx, pgx.TxOptions{AccessMode: pgx.ReadOnly}, func(tx pgx.Tx) error {
+			const sql = `
+SELECT challenge_id, state, creation_time
+FROM gw2_account_verification_challenges
+WHERE account_id = $1
+`
+			return tx.QueryRow(ctx, sql, session.AccountId).Scan(&challengeId, &state, &startTime)
+		})
+
+		if err != nil {
+			return util.NewEchoPgxHTTPError(err)
+		}
+
+		challenge, ok := verification.Get(challengeId)
+		if !ok {
+			return echo.NewHTTPError(http.StatusInternalServerError, "unknown challenge id")
+		}
+
+		gw2Acc, tokenInfo, err := accountAndTokenInfo(ctx, gw2ApiClient, body.ApiToken)
+		if err != nil {
+			return httpErrorForGw2ApiError(err)
+		} else if tokenInfo.IsSubToken() {
+			return subTokenHTTPError()
+		}
+
+		if !util.NewSet(tokenInfo.Permissions...).ContainsAll(util.NewSet(challenge.RequiredPermissions()...)) {
+			return echo.NewHTTPError(http.StatusBadRequest, "the provided apitoken does not provide all permissions required for this challenge")
+		}
+
+		isSuccess, err := challenge.Evaluate(ctx, gw2ApiClient, body.ApiToken, state, startTime)
+		if err != nil {
+			return httpErrorForGw2ApiError(err)
+		}
+
+		if !isSuccess && challenge.Timeout() <= 0 {
+			return echo.NewHTTPError(http.StatusBadRequest, "the verification challenge was not fulfilled")
+		}
+
+		slog.InfoContext(
+			ctx,
+			"verification challenge submitted",
+			slog.Int("verification.challenge.id", challengeId),
+			slog.String("gw2account.id", gw2Acc.Id.String()),
+			slog.String("gw2account.name", gw2Acc.Name),
+			slog.Bool("verification.success", isSuccess),
+		)
+
+		now := time.Now()
+		err = rctx.ExecuteTx(ctx, pgx.TxOptions{}, func(tx pgx.Tx) error {
+			sql := `
+SELECT
+	EXISTS(
+		SELECT TRUE
+		FROM gw2_account_verification_challenges
+		WHERE account_id = $1
+		AND challenge_id = $3
+		AND state = $4
+	),
+	EXISTS(
+		SELECT TRUE
+		FROM gw2_account_verifications
+		WHERE account_id = $1
+		AND gw2_account_id = $2
+	),
+	EXISTS(
+		SELECT TRUE
+		FROM gw2_account_verification_pending_challenges
+		WHERE account_id = $1
+		AND gw2_account_id = $2
+	)
+`
+			var challengeActive, alreadyVerified, alreadyPending bool
+			if err := tx.QueryRow(ctx, sql, session.AccountId, gw2Acc.Id, challengeId, state).Scan(&challengeActive, &alreadyVerified, &alreadyPending); err != nil {
+				return err
+			}
+
+			if !challengeActive {
+				return echo.NewHTTPError(http.StatusConflict, "the verification challenge changed in the meantime")
+			} else if alreadyVerified {
+				return echo.NewHTTPError(http.StatusBadRequest, "the gw2account is already verified for this gw2auth account")
+			} else if alreadyPending {
+				return echo.NewHTTPError(http.StatusBadRequest, "there is already a pending verification challenge for this gw2account")
+			}
+
+			sql = `
+INSERT INTO gw2_accounts
+(account_id, gw2_account_id, display_name, order_rank, gw2_account_name, creation_time, last_name_check_time)
+VALUES
+($1, $2, $3, $4, $5, $6, $6)
+ON CONFLICT (account_id, gw2_account_id) DO UPDATE SET
+gw2_account_name = EXCLUDED.gw2_account_name,
+last_name_check_time = EXCLUDED.last_name_check_time
+`
+			if _, err := tx.Exec(ctx, sql, session.AccountId, gw2Acc.Id, gw2Acc.Name, "A", gw2Acc.Name, now); err != nil {
+				return err
+			}
+
+			err := accountlog.Write(ctx, tx, session.AccountId, accountlog.TypeVerificationSubmitted, accountlog.Fields{
+				"challengeId":    challengeId,
+				"gw2AccountId":   gw2Acc.Id,
+				"gw2AccountName": gw2Acc.Name,
+				"success":        isSuccess,
+			})
+
+			if err != nil {
+				return err
+			}
+
+			if isSuccess {
+				return verification.MarkVerified(ctx, tx, session.AccountId, gw2Acc.Id)
+			}
+
+			sql = `
+INSERT INTO gw2_account_verification_pending_challenges
+(account_id, gw2_account_id, challenge_id, state, gw2_api_token, creation_time, submit_time, timeout_time)
+SELECT account_id, $2, challenge_id, state, $3, creation_time, $4, $5
+FROM gw2_account_verification_challenges
+WHERE account_id = $1
+`
+			_, err = tx.Exec(ctx, sql, session.AccountId, gw2Acc.Id, body.ApiToken, now, now.Add(challenge.Timeout()))
+			return err
+		})
+
+		if err != nil {
+			var httpError *echo.HTTPError
+			if errors.As(err, &httpError) {
+				return httpError
+			} else {
+				return util.NewEchoPgxHTTPError(err)
+			}
+		}
+
+		return c.JSON(http.StatusOK, verificationSubmitResponse{
+			IsSuccess: isSuccess,
+		})
+	})
+}
@@ -0,0 +1,246 @@
+package web
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/gofrs/uuid/v5"
+	"github.com/gw2auth/gw2auth.com-api/internal/test"
+	"github.com/gw2auth/gw2auth.com-api/service"
+	"github.com/gw2auth/gw2auth.com-api/service/gw2"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestVerificationAll(t *testing.T) {
+	test.RunAll(t, dbScope, map[string]map[string]test.Fn{
+		"StartVerificationEndpoint": {
+			"unauthorized":         testStartVerificationEndpointUnauthorized,
+			"invalid challenge id": testStartVerificationEndpointInvalidChallengeId,
+			"simple":               testStartVerificationEndpointSimple,
+		},
+		"CancelVerificationEndpoint": {
+			"unauthorized": testCancelVerificationEndpointUnauthorized,
+			"simple":       testCancelVerificationEndpointSimple,
+		},
+		"SubmitVerificationEndpoint": {
+			"unauthorized":          testSubmitVerificationEndpointUnauthorized,
+			"api token name":        testSubmitVerificationEndpointApiTokenName,
+			"missing permissions":   testSubmitVerificationEndpointMissingPermissions,
+			"creates pending state": testSubmitVerificationEndpointPending,
+		},
+	})
+}
+
+func testStartVerificationEndpointUnauthorized(t *testing.T, pool *pgxpool.Pool, conv *service.SessionJwtConverter, truncateTablesFn func() error) {
+	e := newEchoWithMiddleware(pool, conv)
+	e.PUT("/", StartVerificationEndpoint())
+	test.MustUnauthorized(t, e, httptest.NewRequest(http.MethodPut, "/", nil))
+}
+
+func testStartVerificationEndpointInvalidChallengeId(t *testing.T, pool *pgxpool.Pool, conv *service.SessionJwtConverter, truncateTablesFn func() error) {
+	req := httptest.NewRequest(http.MethodPut, "/", strings.NewReader(`{"challengeId": 42}`))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	accountId, _, _, _ := test.Authenticated(t, req, pool, conv, "google", "GoogleA")
+
+	e := newEchoWithMiddleware(pool, conv)
+	e.PUT("/", StartVerificationEndpoint())
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	test.MustNotExist(t, pool, "SELECT TRUE FROM gw2_account_verification_challenges WHERE account_id = $1", accountId)
+}
+
+func testStartVerificationEndpointSimple(t *testing.T, pool *pgxpool.Pool, conv *service.SessionJwtConverter, truncateTablesFn func() error) {
+	for _, challengeId := range []int{1, 2, 3} {
+		t.Run(fmt.Sprintf("challenge %d", challengeId), func(t *testing.T) {
+			t.Cleanup(func() {
+				if !assert.NoError(t, truncateTablesFn()) {
+					t.FailNow()
+				}
+			})
+
+			req := httptest.NewRequest(http.MethodPut, "/", strings.NewReader(fmt.Sprintf(`{"challengeId": %d}`, challengeId)))
+			req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+			accountId, _, _, _ := test.Authenticated(t, req, pool, conv, "google", "GoogleA")
+
+			e := newEchoWithMiddleware(pool, conv)
+			e.PUT("/", StartVerificationEndpoint())
+			rec := httptest.NewRecorder()
+			e.ServeHTTP(rec, req)
+
+			assert.Equal(t, http.StatusOK, rec.Code)
+
+			var body verificationActiveChallenge
+			if assert.NoError(t, json.NewDecoder(rec.Body).Decode(&body)) {
+				assert.Equal(t, challengeId, body.ChallengeId)
+				assert.NotEmpty(t, body.State)
+
+				test.MustExist(
+					t,
+					pool,
+					"SELECT TRUE FROM gw2_account_verification_challenges WHERE account_id = $1 AND challenge_id = $2 AND state = $3",
+					accountId,
+					challengeId,
+					body.State,
+				)
+			}
+		})
+	}
+}
+
+func testCancelVerificationEndpointUnauthorized(t *testing.T, pool *pgxpool.Pool, conv *service.SessionJwtConverter, truncateTablesFn func() error) {
+	e := newEchoWithMiddleware(pool, conv)
+	e.DELETE("/", CancelVerificationEndpoint())
+	test.MustUnauthorized(t, e, httptest.NewRequest(http.MethodDelete, "/", nil))
+}
+
+func testCancelVerificationEndpointSimple(t *testing.T, pool *pgxpool.Pool, conv *service.SessionJwtConverter, truncateTablesFn func() error) {
+	req := httptest.NewRequest(http.MethodDelete, "/", nil)
+	accountId, _, _, _ := test.Authenticated(t, req, pool, conv, "google", "GoogleA")
+	test.CreateGw2AccountVerificationChallenge(t, pool, accountId, 1, "GW2Auth-state")
+
+	e := newEchoWithMiddleware(pool, conv)
+	e.DELETE("/", CancelVerificationEndpoint())
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+
+	assert.Equal(t, http.StatusOK, rec.Code)
+	test.MustNotExist(t, pool, "SELECT TRUE FROM gw2_account_verification_challenges WHERE account_id = $1", accountId)
+}
+
+func testSubmitVerificationEndpointUnauthorized(t *testing.T, pool *pgxpool.Pool, conv *service.SessionJwtConverter, truncateTablesFn func() error) {
+	e := newEchoWithMiddleware(pool, conv)
+	e.POST("/", SubmitVerificationEndpoint(nil))
+	test.MustUnauthorized(t, e, httptest.NewRequest(http.MethodPost, "/", nil))
+}
+
+func testSubmitVerificationEndpointApiTokenName(t *testing.T, pool *pgxpool.Pool, conv *service.SessionJwtConverter, truncateTablesFn func() error) {
+	otherAccountId := test.NewUUID(t)
+	gw2AccountId := uuid.FromStringOrNil("93df54c6-78f7-e111-809d-78e7d1936ef0")
+
+	test.CreateAccount(t, pool, otherAccountId, time.Now())
+	test.CreateGw2Account(t, pool, otherAccountId, gw2AccountId, "Felix.9127", "Felix.9127")
+	test.CreateGw2ApiToken(t, pool, otherAccountId, gw2AccountId, "otherTestApiToken", []gw2.Permission{gw2.PermissionAccount})
+	test.CreateGw2AccountVerification(t, pool, otherAccountId, gw2AccountId)
+
+	mux := http.NewServeMux()
+	prepareMuxForAccountRequest(mux, "testApiToken", gw2AccountId, "Felix.9127")
+	prepareMuxForTokenInfoRequest(mux, "testApiToken", "GW2Auth-state", gw2.PermissionAccount)
+
+	assert.NoError(t, test.WithGw2ApiClient(mux, func(gw2ApiClient *gw2.ApiClient) error {
+		req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(`{"apiToken": "testApiToken"}`))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		accountId, _, _, _ := test.Authenticated(t, req, pool, conv, "google", "GoogleA")
+		test.CreateGw2AccountVerificationChallenge(t, pool, accountId, 1, "GW2Auth-state")
+
+		e := newEchoWithMiddleware(pool, conv)
+		e.POST("/", SubmitVerificationEndpoint(gw2ApiClient))
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.JSONEq(t, `{"isSuccess": true}`, rec.Body.String())
+
+		test.MustExist(
+			t,
+			pool,
+			"SELECT TRUE FROM gw2_account_verifications WHERE account_id = $1 AND gw2_account_id = $2",
+			accountId,
+			gw2AccountId,
+		)
+
+		test.MustNotExist(
+			t,
+			pool,
+			"SELECT TRUE FROM gw2_account_api_tokens WHERE account_id = $1 AND gw2_account_id = $2",
+			otherAccountId,
+			gw2AccountId,
+		)
+
+		return nil
+	}))
+}
+
+func testSubmitVerificationEndpointMissingPermissions(t *testing.T, pool *pgxpool.Pool, conv *service.SessionJwtConverter, truncateTablesFn func() error) {
+	gw2AccountId := uuid.FromStringOrNil("93df54c6-78f7-e111-809d-78e7d1936ef0")
+
+	mux := http.NewServeMux()
+	prepareMuxForAccountRequest(mux, "testApiToken", gw2AccountId, "Felix.9127")
+	prepareMuxForTokenInfoRequest(mux, "testApiToken", "TokenName", gw2.PermissionAccount)
+
+	assert.NoError(t, test.WithGw2ApiClient(mux, func(gw2ApiClient *gw2.ApiClient) error {
+		req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(`{"apiToken": "testApiToken"}`))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		accountId, _, _, _ := test.Authenticated(t, req, pool, conv, "google", "GoogleA")
+		test.CreateGw2AccountVerificationChallenge(t, pool, accountId, 2, `{"itemId": 19697, "price": 42}`)
+
+		e := newEchoWithMiddleware(pool, conv)
+		e.POST("/", SubmitVerificationEndpoint(gw2ApiClient))
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+
+		assert.Equal(t, http.StatusBadRequest, rec.Code)
+		test.MustNotExist(
+			t,
+			pool,
+			"SELECT TRUE FROM gw2_account_verification_pending_challenges WHERE account_id = $1",
+			accountId,
+		)
+
+		return nil
+	}))
+}
+
+func testSubmitVerificationEndpointPending(t *testing.T, pool *pgxpool.Pool, conv *service.SessionJwtConverter, truncateTablesFn func() error) {
+	gw2AccountId := uuid.FromStringOrNil("93df54c6-78f7-e111-809d-78e7d1936ef0")
+
+	mux := http.NewServeMux()
+	prepareMuxForAccountRequest(mux, "testApiToken", gw2AccountId, "Felix.9127")
+	prepareMuxForTokenInfoRequest(mux, "testApiToken", "TokenName", gw2.PermissionAccount, gw2.PermissionCharacters)
+	mux.HandleFunc("/v2/characters", func(w http.ResponseWriter, req *http.Request) {
+		_ = json.NewEncoder(w).Encode([]string{"Some Character"})
+	})
+
+	assert.NoError(t, test.WithGw2ApiClient(mux, func(gw2ApiClient *gw2.ApiClient) error {
+		req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(`{"apiToken": "testApiToken"}`))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		accountId, _, _, _ := test.Authenticated(t, req, pool, conv, "google", "GoogleA")
+		test.CreateGw2AccountVerificationChallenge(t, pool, accountId, 3, "Abcdefghijkl")
+
+		e := newEchoWithMiddleware(pool, conv)
+		e.POST("/", SubmitVerificationEndpoint(gw2ApiClient))
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.JSONEq(t, `{"isSuccess": false}`, rec.Body.String())
+
+		test.MustExist(
+			t,
+			pool,
+			`
+SELECT TRUE
+FROM gw2_account_verification_pending_challenges
+WHERE account_id = $1
+AND gw2_account_id = $2
+AND challenge_id = $3
+AND state = $4
+AND gw2_api_token = $5
+`,
+			accountId,
+			gw2AccountId,
+			3,
+			"Abcdefghijkl",
+			"testApiToken",
+		)
+
+		return nil
+	}))
+}
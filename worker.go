@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/gw2auth/gw2auth.com-api/config"
+	"github.com/gw2auth/gw2auth.com-api/service/accountlog"
+	"github.com/gw2auth/gw2auth.com-api/service/apitoken"
+	"github.com/gw2auth/gw2auth.com-api/service/verification"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"log/slog"
+	"time"
+)
+
+type workerJob struct {
+	name string
+	fn   func(ctx context.Context) error
+}
+
+// Worker runs the background jobs which keep the stored state up to date.
+type Worker struct {
+	jobs []workerJob
+}
+
+// RunOnce runs every job once. A failing job does not prevent the others from running.
+func (w *Worker) RunOnce(ctx context.Context) error {
+	var err error
+	for _, job := range w.jobs {
+		if e := job.fn(ctx); e != nil {
+			err = errors.Join(err, fmt.Errorf("job %s failed: %w", job.name, e))
+		}
+	}
+
+	return err
+}
+
+// Run calls RunOnce every interval until the context is done.
+func (w *Worker) Run(ctx context.Context, interval time.Duration) error {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if err := w.RunOnce(ctx); err != nil && ctx.Err() == nil {
+			slog.ErrorContext(ctx, "worker run failed", slog.String("error", err.Error()))
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+func newWorker(cfg config.Config, pool *pgxpool.Pool) *Worker {
+	gw2ApiClient := newGw2ApiClient(newHttpClient(), cfg)
+	pendingWorker := verification.NewPendingWorker(pool, gw2ApiClient)
+	revalidator := apitoken.NewRevalidator(pool, gw2ApiClient, apitoken.WithRequestBudget(cfg.TokenRevalidationRequestBudget))
+	logCleaner := accountlog.NewCleaner(pool, time.Duration(cfg.AccountLogRetention))
+
+	return &Worker{
+		jobs: []workerJob{
+			{name: "pending_verification", fn: pendingWorker.RunOnce},
+			{name: "api_token_revalidation", fn: revalidator.RunOnce},
+			{name: "account_log_retention", fn: logCleaner.RunOnce},
+		},
+	}
+}
+
+func WithWorker(ctx context.Context, cfg config.Config, fn func(ctx context.Context, w *Worker) error) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	return withPgx(cfg, func(pool *pgxpool.Pool) error {
+		return fn(ctx, newWorker(cfg, pool))
+	})
+}